@@ -15,6 +15,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -35,17 +36,26 @@ var (
 Check console output for expressions matching failure messages logged
 by a Container Linux instance.
 
-If no files are specified as arguments, stdin is checked.
+If no files are specified as arguments, stdin is checked. Each match is
+printed with its line number and the id of the rule which matched. Only
+matches of rules with severity "fail" are counted as errors.
 `,
 
 		SilenceUsage: true,
 	}
 
 	checkConsoleVerbose bool
+	checkConsoleJSON    bool
 )
 
+type consoleMatchOutput struct {
+	File string `json:"file"`
+	kola.ConsoleMatch
+}
+
 func init() {
-	cmdCheckConsole.Flags().BoolVarP(&checkConsoleVerbose, "verbose", "v", false, "output user input prompts")
+	cmdCheckConsole.Flags().BoolVarP(&checkConsoleVerbose, "verbose", "v", false, "output user input prompts and matched lines")
+	cmdCheckConsole.Flags().BoolVar(&checkConsoleJSON, "json", false, "output matches as JSON")
 	root.AddCommand(cmdCheckConsole)
 }
 
@@ -56,6 +66,7 @@ func runCheckConsole(cmd *cobra.Command, args []string) error {
 	}
 
 	errorcount := 0
+	matches := []consoleMatchOutput{}
 	for _, arg := range args {
 		var console []byte
 		var err error
@@ -74,9 +85,25 @@ func runCheckConsole(cmd *cobra.Command, args []string) error {
 			errorcount += 1
 			continue
 		}
-		for _, badness := range kola.CheckConsole(console, nil) {
-			fmt.Printf("%v: %v\n", sourceName, badness)
-			errorcount += 1
+		for _, m := range kola.CheckConsole(console, nil, "") {
+			if checkConsoleJSON {
+				matches = append(matches, consoleMatchOutput{sourceName, m})
+			} else {
+				fmt.Printf("%v:%d: [%s] %v (%s)\n", sourceName, m.Line, m.Rule.ID, m, m.Rule.Severity)
+				if checkConsoleVerbose {
+					fmt.Printf("\t%s\n", m.Text)
+				}
+			}
+			if m.Rule.Severity == kola.SeverityFail {
+				errorcount += 1
+			}
+		}
+	}
+	if checkConsoleJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(matches); err != nil {
+			return err
 		}
 	}
 	if errorcount > 0 {
@@ -0,0 +1,99 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/mantle/kola"
+	"github.com/coreos/mantle/kola/history"
+)
+
+var (
+	cmdHistory = &cobra.Command{
+		Use:   "history [glob pattern...]",
+		Short: "Show flakiness statistics from the test history",
+		Long: `Summarize the results recorded with --history-file.
+
+For each test, platform and architecture, show the number of runs, the
+failure and flake rates, the mean and recent durations and, for tests
+failing now, the build on which they started failing.
+`,
+		RunE:         runHistory,
+		SilenceUsage: true,
+	}
+
+	historyJSON bool
+)
+
+func init() {
+	root.AddCommand(cmdHistory)
+	cmdHistory.Flags().BoolVar(&historyJSON, "json", false, "format output in JSON")
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	if kola.HistoryFile == "" {
+		return errors.New("--history-file is required")
+	}
+	records, err := history.Load(kola.HistoryFile)
+	if err != nil {
+		return errors.Wrapf(err, "loading history")
+	}
+
+	var stats []history.Stats
+	for _, s := range history.Summarize(records) {
+		matched := len(args) == 0
+		for _, pattern := range args {
+			if ok, err := filepath.Match(pattern, s.Test); err != nil {
+				return err
+			} else if ok {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			stats = append(stats, s)
+		}
+	}
+
+	if historyJSON {
+		out, err := json.MarshalIndent(stats, "", "\t")
+		if err != nil {
+			return errors.Wrapf(err, "marshalling history")
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(w, "Test Name\tPlatform\tArch\tRuns\tFailed\tFlaky\tMean\tRecent\tFirst Failing Build")
+	fmt.Fprintln(w, "\t")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.0f%%\t%.0f%%\t%v\t%v\t%s\n",
+			s.Test, s.Platform, s.Arch, s.Runs,
+			100*s.FailureRate(), 100*s.FlakeRate,
+			s.MeanDuration.Round(time.Second), s.RecentDuration.Round(time.Second),
+			s.FirstFailingBuild)
+	}
+	return w.Flush()
+}
@@ -35,6 +35,7 @@ import (
 	"github.com/coreos/mantle/fcos"
 	"github.com/coreos/mantle/kola"
 	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/conf"
 	"github.com/coreos/mantle/sdk"
 	"github.com/coreos/mantle/system"
@@ -61,9 +62,13 @@ var (
 If the glob pattern is exactly equal to the name of a single test, any
 restrictions on the versions of Container Linux supported by that test
 will be ignored.
+
+With --rerun-failed, the tests which failed in a previous report.json are
+run again, using the platform, options and external test directories
+recorded for that run unless they are overridden on the command line.
 `,
 		RunE:         runRun,
-		PreRunE:      preRun,
+		PreRunE:      preRunRun,
 		SilenceUsage: true,
 	}
 
@@ -77,8 +82,13 @@ will be ignored.
 	}
 
 	cmdList = &cobra.Command{
-		Use:     "list",
-		Short:   "List kola test names",
+		Use:   "list [glob pattern...]",
+		Short: "List kola test names",
+		Long: `List kola test names.
+
+With --explain, list whether each test would be run by "kola run" with the
+given patterns, tags, selection and platform, and if not, why.
+`,
 		PreRunE: preRun,
 		RunE:    runList,
 
@@ -107,13 +117,27 @@ This can be useful for e.g. serving locally built OSTree repos to qemu.
 		RunE: runIgnitionConvert2,
 	}
 
+	cmdGuestRelay = &cobra.Command{
+		Use:    platform.RelaySubcommand + " ADDRESS",
+		Short:  "Relay stdio to a host service",
+		Long:   `Run by QEMU to relay a guest connection to a host service, such as a kola fixture server.`,
+		Args:   cobra.ExactArgs(1),
+		RunE:   runGuestRelay,
+		Hidden: true,
+
+		SilenceUsage: true,
+	}
+
 	listJSON           bool
+	listExplain        bool
 	listPlatform       string
 	listDistro         string
 	httpPort           int
 	findParentImage    bool
 	qemuImageDir       string
 	qemuImageDirIsTemp bool
+	upgradeVersions    []string
+	upgradeGraph       string
 
 	extDependencyDir string
 	runExternals     []string
@@ -124,21 +148,29 @@ func init() {
 	root.AddCommand(cmdRun)
 	cmdRun.Flags().StringArrayVarP(&runExternals, "exttest", "E", nil, "Externally defined tests (will be found in DIR/tests/kola)")
 	cmdRun.Flags().IntVar(&runMultiply, "multiply", 0, "Run the provided tests N times (useful to find race conditions)")
+	cmdRun.Flags().StringVar(&rerunFailed, "rerun-failed", "", "Rerun the failed tests from a previous report.json")
+	cmdRun.Flags().BoolVar(&rerunSkipped, "rerun-skipped", false, "With --rerun-failed, also rerun skipped tests")
 
 	root.AddCommand(cmdList)
 	cmdList.Flags().StringArrayVarP(&runExternals, "exttest", "E", nil, "Externally defined tests in directory")
 	cmdList.Flags().BoolVar(&listJSON, "json", false, "format output in JSON")
 	cmdList.Flags().StringVarP(&listPlatform, "platform", "p", "all", "filter output by platform")
 	cmdList.Flags().StringVarP(&listDistro, "distro", "b", "all", "filter output by distro")
+	cmdList.Flags().BoolVar(&listExplain, "explain", false, "explain why each test is selected or filtered out")
 
 	root.AddCommand(cmdHttpServer)
 	cmdHttpServer.Flags().IntVarP(&httpPort, "port", "P", 8000, "Listen on provided port")
 
 	root.AddCommand(cmdIgnConvert)
 
+	root.AddCommand(cmdGuestRelay)
+
 	root.AddCommand(cmdRunUpgrade)
 	cmdRunUpgrade.Flags().BoolVar(&findParentImage, "find-parent-image", false, "automatically find parent image if not provided -- note on qemu, this will download the image")
 	cmdRunUpgrade.Flags().StringVar(&qemuImageDir, "qemu-image-dir", "", "directory in which to cache QEMU images if --fetch-parent-image is enabled")
+	cmdRunUpgrade.Flags().StringSliceVar(&upgradeVersions, "upgrade-path", nil, "releases to upgrade through before the build under test, oldest first (FCOS only)")
+	cmdRunUpgrade.Flags().StringVar(&upgradeGraph, "upgrade-graph", "", "Cincinnati graph file or URL to take the upgrade path from")
+	cmdRunUpgrade.Flags().StringVar(&kola.UpgradeOstreeURL, "upgrade-ostree-url", "https://ostree.fedoraproject.org", "OSTree repo serving the releases of the upgrade path")
 }
 
 func main() {
@@ -184,7 +216,20 @@ func registerExternals() error {
 
 func runRun(cmd *cobra.Command, args []string) error {
 	var patterns []string
-	if len(args) == 0 {
+	if rerunFailed != "" {
+		var err error
+		patterns, err = failedTestsFromReport(rerunFailed, rerunSkipped)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", rerunFailed)
+		}
+		if len(patterns) == 0 {
+			fmt.Printf("No tests to rerun in %s\n", rerunFailed)
+			return nil
+		}
+		if kola.RerunOf, err = filepath.Abs(rerunFailed); err != nil {
+			return err
+		}
+	} else if len(args) == 0 {
 		patterns = []string{"*"} // run all tests by default
 	} else {
 		patterns = args
@@ -210,6 +255,72 @@ func runRun(cmd *cobra.Command, args []string) error {
 	return runErr
 }
 
+type awsProps struct {
+	Region       string `json:"region"`
+	AMI          string `json:"ami"`
+	InstanceType string `json:"type"`
+}
+type azureProps struct {
+	DiskURI   string `json:"diskUri"`
+	Publisher string `json:"publisher"`
+	Offer     string `json:"offer"`
+	Sku       string `json:"sku"`
+	Version   string `json:"version"`
+	Location  string `json:"location"`
+	Size      string `json:"size"`
+}
+type doProps struct {
+	Region string `json:"region"`
+	Size   string `json:"size"`
+	Image  string `json:"image"`
+}
+type esxProps struct {
+	Server     string `json:"server"`
+	BaseVMName string `json:"base_vm_name"`
+}
+type gceProps struct {
+	Image       string `json:"image"`
+	MachineType string `json:"type"`
+}
+type openStackProps struct {
+	Region string `json:"region"`
+	Image  string `json:"image"`
+	Flavor string `json:"flavor"`
+}
+type packetProps struct {
+	Facility              string `json:"facility"`
+	Plan                  string `json:"plan"`
+	InstallerImageBaseURL string `json:"installer"`
+	Architecture          string `json:"architecture"`
+	IPXEURL               string `json:"ipxe"`
+	ImageURL              string `json:"image"`
+}
+type qemuProps struct {
+	Image     string `json:"image"`
+	ImageSize string `json:"imageSize"`
+	Swtpm     bool   `json:"swtpm"`
+}
+
+// kolaProps is written to properties.json in the output directory to record
+// how a run was invoked.
+type kolaProps struct {
+	Cmdline         []string       `json:"cmdline"`
+	Platform        string         `json:"platform"`
+	Distro          string         `json:"distro"`
+	IgnitionVersion string         `json:"ignitionversion"`
+	Board           string         `json:"board"`
+	OSContainer     string         `json:"oscontainer"`
+	RerunOf         string         `json:"rerunOf,omitempty"`
+	AWS             awsProps       `json:"aws"`
+	Azure           azureProps     `json:"azure"`
+	DO              doProps        `json:"do"`
+	ESX             esxProps       `json:"esx"`
+	GCE             gceProps       `json:"gce"`
+	OpenStack       openStackProps `json:"openstack"`
+	Packet          packetProps    `json:"packet"`
+	QEMU            qemuProps      `json:"qemu"`
+}
+
 func writeProps() error {
 	f, err := os.OpenFile(filepath.Join(outputDir, "properties.json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
@@ -220,78 +331,19 @@ func writeProps() error {
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "    ")
 
-	type AWS struct {
-		Region       string `json:"region"`
-		AMI          string `json:"ami"`
-		InstanceType string `json:"type"`
-	}
-	type Azure struct {
-		DiskURI   string `json:"diskUri"`
-		Publisher string `json:"publisher"`
-		Offer     string `json:"offer"`
-		Sku       string `json:"sku"`
-		Version   string `json:"version"`
-		Location  string `json:"location"`
-		Size      string `json:"size"`
-	}
-	type DO struct {
-		Region string `json:"region"`
-		Size   string `json:"size"`
-		Image  string `json:"image"`
-	}
-	type ESX struct {
-		Server     string `json:"server"`
-		BaseVMName string `json:"base_vm_name"`
-	}
-	type GCE struct {
-		Image       string `json:"image"`
-		MachineType string `json:"type"`
-	}
-	type OpenStack struct {
-		Region string `json:"region"`
-		Image  string `json:"image"`
-		Flavor string `json:"flavor"`
-	}
-	type Packet struct {
-		Facility              string `json:"facility"`
-		Plan                  string `json:"plan"`
-		InstallerImageBaseURL string `json:"installer"`
-		Architecture          string `json:"architecture"`
-		IPXEURL               string `json:"ipxe"`
-		ImageURL              string `json:"image"`
-	}
-	type QEMU struct {
-		Image     string `json:"image"`
-		ImageSize string `json:"imageSize"`
-		Swtpm     bool   `json:"swtpm"`
-	}
-	return enc.Encode(&struct {
-		Cmdline         []string  `json:"cmdline"`
-		Platform        string    `json:"platform"`
-		Distro          string    `json:"distro"`
-		IgnitionVersion string    `json:"ignitionversion"`
-		Board           string    `json:"board"`
-		OSContainer     string    `json:"oscontainer"`
-		AWS             AWS       `json:"aws"`
-		Azure           Azure     `json:"azure"`
-		DO              DO        `json:"do"`
-		ESX             ESX       `json:"esx"`
-		GCE             GCE       `json:"gce"`
-		OpenStack       OpenStack `json:"openstack"`
-		Packet          Packet    `json:"packet"`
-		QEMU            QEMU      `json:"qemu"`
-	}{
+	return enc.Encode(&kolaProps{
 		Cmdline:         os.Args,
 		Platform:        kolaPlatform,
 		Distro:          kola.Options.Distribution,
 		IgnitionVersion: kola.Options.IgnitionVersion,
 		OSContainer:     kola.Options.OSContainer,
-		AWS: AWS{
+		RerunOf:         kola.RerunOf,
+		AWS: awsProps{
 			Region:       kola.AWSOptions.Region,
 			AMI:          kola.AWSOptions.AMI,
 			InstanceType: kola.AWSOptions.InstanceType,
 		},
-		Azure: Azure{
+		Azure: azureProps{
 			DiskURI:   kola.AzureOptions.DiskURI,
 			Publisher: kola.AzureOptions.Publisher,
 			Offer:     kola.AzureOptions.Offer,
@@ -300,32 +352,32 @@ func writeProps() error {
 			Location:  kola.AzureOptions.Location,
 			Size:      kola.AzureOptions.Size,
 		},
-		DO: DO{
+		DO: doProps{
 			Region: kola.DOOptions.Region,
 			Size:   kola.DOOptions.Size,
 			Image:  kola.DOOptions.Image,
 		},
-		ESX: ESX{
+		ESX: esxProps{
 			Server:     kola.ESXOptions.Server,
 			BaseVMName: kola.ESXOptions.BaseVMName,
 		},
-		GCE: GCE{
+		GCE: gceProps{
 			Image:       kola.GCEOptions.Image,
 			MachineType: kola.GCEOptions.MachineType,
 		},
-		OpenStack: OpenStack{
+		OpenStack: openStackProps{
 			Region: kola.OpenStackOptions.Region,
 			Image:  kola.OpenStackOptions.Image,
 			Flavor: kola.OpenStackOptions.Flavor,
 		},
-		Packet: Packet{
+		Packet: packetProps{
 			Facility:     kola.PacketOptions.Facility,
 			Plan:         kola.PacketOptions.Plan,
 			Architecture: kola.PacketOptions.Architecture,
 			IPXEURL:      kola.PacketOptions.IPXEURL,
 			ImageURL:     kola.PacketOptions.ImageURL,
 		},
-		QEMU: QEMU{
+		QEMU: qemuProps{
 			Image:     kola.QEMUOptions.DiskImage,
 			ImageSize: kola.QEMUOptions.DiskSize,
 			Swtpm:     kola.QEMUOptions.Swtpm,
@@ -337,8 +389,16 @@ func runList(cmd *cobra.Command, args []string) error {
 	if err := registerExternals(); err != nil {
 		return err
 	}
+	if listExplain {
+		return runListExplain(args)
+	}
 	var testlist []*item
 	for name, test := range register.Tests {
+		if match, err := kola.SelectionMatches(test); err != nil {
+			return err
+		} else if !match {
+			continue
+		}
 		item := &item{
 			name,
 			test.Platforms,
@@ -397,6 +457,43 @@ func runList(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runListExplain(patterns []string) error {
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+	pltfrm := kolaPlatform
+	if listPlatform != "all" {
+		pltfrm = listPlatform
+	}
+	explanations, err := kola.ExplainTests(register.Tests, patterns, pltfrm)
+	if err != nil {
+		return err
+	}
+
+	if listJSON {
+		out, err := json.MarshalIndent(explanations, "", "\t")
+		if err != nil {
+			return errors.Wrapf(err, "marshalling test list")
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	var w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Test Name\tStatus\tReason")
+	for _, e := range explanations {
+		status := "filtered"
+		if e.Selected {
+			status = "selected"
+		} else if e.Denied {
+			status = "skipped"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Name, status, e.Reason)
+	}
+	w.Flush()
+	return nil
+}
+
 type item struct {
 	Name                 string
 	Platforms            []string
@@ -457,6 +554,10 @@ func runHttpServer(cmd *cobra.Command, args []string) error {
 	return http.ListenAndServe(fmt.Sprintf(":%d", httpPort), nil)
 }
 
+func runGuestRelay(cmd *cobra.Command, args []string) error {
+	return platform.Relay(args[0])
+}
+
 func runIgnitionConvert2(cmd *cobra.Command, args []string) error {
 	buf, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
@@ -488,6 +589,10 @@ func preRunUpgrade(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if err := syncUpgradePath(); err != nil {
+		return err
+	}
+
 	if findParentImage {
 		err = syncFindParentImageOptions()
 		if err != nil {
@@ -499,6 +604,47 @@ func preRunUpgrade(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// syncUpgradePath sets kola.UpgradePath from --upgrade-path or
+// --upgrade-graph. The build under test is dropped from the end of the path.
+func syncUpgradePath() error {
+	if len(upgradeVersions) == 0 && upgradeGraph == "" {
+		return nil
+	}
+	if kola.CosaBuild == nil {
+		return errors.New("--upgrade-path and --upgrade-graph require --build")
+	}
+	var err error
+	switch {
+	case len(upgradeVersions) > 0 && upgradeGraph != "":
+		return errors.New("--upgrade-path and --upgrade-graph are mutually exclusive")
+	case upgradeGraph != "":
+		if kola.UpgradePath, err = kola.LoadUpgradeGraph(upgradeGraph); err != nil {
+			return err
+		}
+	case len(upgradeVersions) > 0:
+		if kola.Options.Distribution != "fcos" {
+			return fmt.Errorf("--upgrade-path not yet supported for distro %s", kola.Options.Distribution)
+		}
+		if kola.CosaBuild.Meta.BuildRef == "" {
+			return errors.New("no ref in build metadata")
+		}
+		stream := filepath.Base(kola.CosaBuild.Meta.BuildRef)
+		for _, version := range upgradeVersions {
+			build, err := cosa.FetchAndParseBuild(fcos.GetCosaBuildUrl(stream, version) + "meta.json")
+			if err != nil {
+				return errors.Wrapf(err, "fetching metadata of %s", version)
+			}
+			kola.UpgradePath = append(kola.UpgradePath, kola.UpgradeNode{
+				Version: version,
+				Payload: build.OstreeCommit,
+			})
+		}
+	}
+
+	kola.UpgradePath, err = kola.TrimUpgradePath(kola.UpgradePath, kola.CosaBuild.Meta.OstreeVersion)
+	return err
+}
+
 func runUpgradeCleanup() {
 	if qemuImageDir != "" && qemuImageDirIsTemp {
 		os.RemoveAll(qemuImageDir)
@@ -604,7 +750,10 @@ func getParentFcosBuildBase(stream string) (string, error) {
 	// fetch the stream metadata, then fetch the release metadata
 
 	var parentVersion string
-	if kola.CosaBuild.Meta.FedoraCoreOsParentVersion != "" {
+	if len(kola.UpgradePath) > 0 {
+		// start from the oldest release of the upgrade path
+		parentVersion = kola.UpgradePath[0].Version
+	} else if kola.CosaBuild.Meta.FedoraCoreOsParentVersion != "" {
 		parentVersion = kola.CosaBuild.Meta.FedoraCoreOsParentVersion
 	} else {
 		// ok, we're probably operating on a local dev build since the pipeline
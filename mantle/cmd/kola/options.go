@@ -54,13 +54,27 @@ func init() {
 	root.PersistentFlags().StringVarP(&kola.Options.Distribution, "distro", "b", "", "Distribution: "+strings.Join(kolaDistros, ", "))
 	root.PersistentFlags().IntVarP(&kola.TestParallelism, "parallel", "j", 1, "number of tests to run in parallel")
 	sv(&kola.TAPFile, "tapfile", "", "file to write TAP results to")
+	sv(&kola.HistoryFile, "history-file", "", "JSON-lines file to append test results to")
+	sv(&kola.MetricsBaseline, "metrics-baseline", "", "report.json whose metrics to compare results with")
+	root.PersistentFlags().Float64Var(&kola.MetricsTolerance, "metrics-tolerance", 0, "fail tests whose metrics grew more than this percentage over the baseline")
 	root.PersistentFlags().BoolVarP(&kola.Options.NoTestExitError, "no-test-exit-error", "T", false, "Don't exit with non-zero if tests fail")
 	sv(&kola.Options.BaseName, "basename", "kola", "Cluster name prefix")
 	ss("debug-systemd-unit", []string{}, "full-unit-name.service to enable SYSTEMD_LOG_LEVEL=debug on. Can be specified multiple times.")
 	sv(&kola.Options.IgnitionVersion, "ignition-version", "", "Ignition version override: v2, v3")
 	ssv(&kola.DenylistedTests, "denylist-test", []string{}, "Test pattern to add to denylist. Can be specified multiple times.")
+	sv(&kola.DenylistFile, "denylist-file", "", "Denylist YAML file (default: kola-denylist.yaml in the config repo, if any)")
+	sv(&kola.ConsoleRulesFile, "console-rules", "", "Console badness rules YAML file (default: kola-console-rules.yaml in the config repo, if any)")
+	sv(&kola.AVCAllowlistFile, "avc-allowlist", "", "Allowed SELinux denials YAML file (default: kola-avc-allowlist.yaml in the config repo, if any)")
+	sv(&kola.AVCSeverity, "avc-denials", kola.SeverityFail, "What to do with SELinux denials not allowed: fail, warn or ignore")
+	bv(&kola.AllowFailedUnits, "allow-failed-units", false, "Don't fail tests leaving failed systemd units or crashes behind")
+	sv(&kola.DiagnosticsFile, "diagnostics-file", "", "Diagnostics YAML file (default: kola-diagnostics.yaml in the config repo, if any)")
+	bv(&kola.NoDiagnostics, "no-diagnostics", false, "Don't collect diagnostics from the machines of failed tests")
 	bv(&kola.NoNet, "no-net", false, "Don't run tests that require an Internet connection")
+	sv(&kola.ChangedSince, "changed-since", "", "Only run tests covering packages changed since this build (or \"parent\"), and tests declaring no packages")
+	bv(&kola.NoMachinePool, "no-machine-pool", false, "Boot fresh machines for non-destructive QEMU tests instead of sharing pooled machines")
 	ssv(&kola.Tags, "tag", []string{}, "Test tag to run. Can be specified multiple times.")
+	ssv(&kola.CollectPaths, "collect-path", []string{}, "Guest path to copy to the output directory after each test. Can be specified multiple times.")
+	sv(&kola.Selection, "select", "", "Run only tests matching this expression, e.g. \"ignition and not (slow or flag:needs-internet)\"")
 	bv(&kola.Options.SSHOnTestFailure, "ssh-on-test-failure", false, "SSH into a machine when tests fail")
 	sv(&kola.Options.CosaWorkdir, "workdir", "", "coreos-assembler working directory")
 	sv(&kola.Options.CosaBuildId, "build", "", "coreos-assembler build ID")
@@ -146,6 +160,10 @@ func init() {
 	bv(&kola.QEMUOptions.Native4k, "qemu-native-4k", false, "Force 4k sectors for main disk")
 	bv(&kola.QEMUOptions.Nvme, "qemu-nvme", false, "Use NVMe for main disk")
 	bv(&kola.QEMUOptions.Swtpm, "qemu-swtpm", true, "Create temporary software TPM")
+	bv(&kola.QEMUOptions.PrivateNetwork, "qemu-private-network", true, "Connect the machines of a cluster over a private network")
+	root.PersistentFlags().IntVar(&kola.HostMemory, "qemu-host-memory", 0, "Host memory budget in MiB shared by parallel tests (0 means unlimited)")
+	root.PersistentFlags().IntVar(&kola.HostCPUs, "qemu-host-cpus", 0, "Host vCPU budget shared by parallel tests (0 means unlimited)")
+	sv(&kola.HostDisk, "qemu-host-disk", "", "Host disk budget shared by parallel tests, e.g. 200G (default unlimited)")
 
 	sv(&kola.QEMUIsoOptions.IsoPath, "qemu-iso", "", "path to CoreOS ISO image")
 }
@@ -280,6 +298,48 @@ func syncOptionsImpl(useCosa bool) error {
 		}
 	}
 
+	if kola.DenylistFile != "" {
+		var stream string
+		if kola.CosaBuild != nil && kola.CosaBuild.Meta.BuildRef != "" {
+			stream = filepath.Base(kola.CosaBuild.Meta.BuildRef)
+		}
+		if err := kola.LoadDenylist(kola.DenylistFile, stream, kolaPlatform); err != nil {
+			return err
+		}
+	}
+
+	if kola.ChangedSince != "" {
+		pkgs, err := kola.ChangedPackagesSince(kola.ChangedSince)
+		if err != nil {
+			return errors.Wrapf(err, "--changed-since")
+		}
+		plog.Infof("Packages changed since %s: %s", kola.ChangedSince, strings.Join(pkgs, " "))
+		kola.ChangedPackages = pkgs
+	}
+
+	if kola.ConsoleRulesFile != "" {
+		if err := kola.LoadConsoleRules(kola.ConsoleRulesFile); err != nil {
+			return err
+		}
+	}
+
+	switch kola.AVCSeverity {
+	case kola.SeverityFail, kola.SeverityWarn, kola.SeverityIgnore:
+	default:
+		return fmt.Errorf("unsupported --avc-denials value %q", kola.AVCSeverity)
+	}
+	if kola.AVCAllowlistFile != "" {
+		if err := kola.LoadAVCAllowlist(kola.AVCAllowlistFile); err != nil {
+			return err
+		}
+	}
+
+	if kola.DiagnosticsFile != "" {
+		if err := kola.LoadDiagnostics(kola.DiagnosticsFile); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -318,5 +378,24 @@ func syncCosaOptions() error {
 
 	runExternals = append(runExternals, filepath.Join(kola.Options.CosaWorkdir, "src/config"))
 
+	// Default to the files the config repository has
+	for _, f := range []struct {
+		path *string
+		name string
+	}{
+		{&kola.DenylistFile, kola.DenylistFileName},
+		{&kola.ConsoleRulesFile, kola.ConsoleRulesFileName},
+		{&kola.AVCAllowlistFile, kola.AVCAllowlistFileName},
+		{&kola.DiagnosticsFile, kola.DiagnosticsFileName},
+	} {
+		if *f.path != "" {
+			continue
+		}
+		path := filepath.Join(kola.Options.CosaWorkdir, "src/config", f.name)
+		if _, err := os.Stat(path); err == nil {
+			*f.path = path
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,184 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+var (
+	rerunFailed  string
+	rerunSkipped bool
+)
+
+// preRunRun applies the options of the run being repeated with
+// --rerun-failed before the usual option processing.
+func preRunRun(cmd *cobra.Command, args []string) error {
+	if rerunFailed != "" {
+		if len(args) > 0 {
+			return errors.New("--rerun-failed cannot be combined with test patterns")
+		}
+		propsPath := filepath.Join(filepath.Dir(filepath.Dir(rerunFailed)), "properties.json")
+		props, err := readProps(propsPath)
+		if os.IsNotExist(err) {
+			plog.Warningf("No %s; rerunning with the current options", propsPath)
+		} else if err != nil {
+			return err
+		} else if err := applyProps(cmd, props); err != nil {
+			return err
+		}
+	}
+	return preRun(cmd, args)
+}
+
+func readProps(path string) (*kolaProps, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var props kolaProps
+	if err := json.NewDecoder(f).Decode(&props); err != nil {
+		return nil, err
+	}
+	return &props, nil
+}
+
+// applyProps sets the flags recorded in props which were not given on the
+// current command line.
+func applyProps(cmd *cobra.Command, props *kolaProps) error {
+	values := map[string]string{
+		"platform":            props.Platform,
+		"distro":              props.Distro,
+		"ignition-version":    props.IgnitionVersion,
+		"oscontainer":         props.OSContainer,
+		"aws-region":          props.AWS.Region,
+		"aws-ami":             props.AWS.AMI,
+		"aws-type":            props.AWS.InstanceType,
+		"azure-disk-uri":      props.Azure.DiskURI,
+		"azure-publisher":     props.Azure.Publisher,
+		"azure-offer":         props.Azure.Offer,
+		"azure-sku":           props.Azure.Sku,
+		"azure-version":       props.Azure.Version,
+		"azure-location":      props.Azure.Location,
+		"azure-size":          props.Azure.Size,
+		"do-region":           props.DO.Region,
+		"do-size":             props.DO.Size,
+		"do-image":            props.DO.Image,
+		"esx-server":          props.ESX.Server,
+		"esx-base-vm":         props.ESX.BaseVMName,
+		"gce-image":           props.GCE.Image,
+		"gce-machinetype":     props.GCE.MachineType,
+		"openstack-region":    props.OpenStack.Region,
+		"openstack-image":     props.OpenStack.Image,
+		"openstack-flavor":    props.OpenStack.Flavor,
+		"packet-facility":     props.Packet.Facility,
+		"packet-plan":         props.Packet.Plan,
+		"packet-architecture": props.Packet.Architecture,
+		"packet-ipxe-url":     props.Packet.IPXEURL,
+		"packet-image-url":    props.Packet.ImageURL,
+		"qemu-image":          props.QEMU.Image,
+		"qemu-size":           props.QEMU.ImageSize,
+		"qemu-swtpm":          strconv.FormatBool(props.QEMU.Swtpm),
+	}
+	for name, value := range values {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed || value == "" {
+			continue
+		}
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return err
+		}
+	}
+	// external tests aren't registered unless their directories are
+	// given again
+	if flag := cmd.Flags().Lookup("exttest"); flag != nil && !flag.Changed {
+		for _, dir := range extTestDirs(props.Cmdline) {
+			if err := cmd.Flags().Set("exttest", dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extTestDirs returns the directories given with --exttest (-E) in a
+// recorded command line.
+func extTestDirs(cmdline []string) []string {
+	var dirs []string
+	for i := 0; i < len(cmdline); i++ {
+		arg := cmdline[i]
+		switch {
+		case arg == "--":
+			return dirs
+		case arg == "-E" || arg == "--exttest":
+			if i+1 < len(cmdline) {
+				i++
+				dirs = append(dirs, cmdline[i])
+			}
+		case strings.HasPrefix(arg, "--exttest="):
+			dirs = append(dirs, strings.TrimPrefix(arg, "--exttest="))
+		case strings.HasPrefix(arg, "-E"):
+			dirs = append(dirs, strings.TrimPrefix(strings.TrimPrefix(arg, "-E"), "="))
+		}
+	}
+	return dirs
+}
+
+// failedTestsFromReport returns the names of the top-level tests which
+// failed in a report.json, and those which were skipped if includeSkipped
+// is set. A failed subtest selects its parent test.
+func failedTestsFromReport(path string, includeSkipped bool) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var report struct {
+		Tests []struct {
+			Name   string                `json:"name"`
+			Result testresult.TestResult `json:"result"`
+		} `json:"tests"`
+	}
+	if err := json.NewDecoder(f).Decode(&report); err != nil {
+		return nil, err
+	}
+
+	selected := map[string]struct{}{}
+	for _, t := range report.Tests {
+		if t.Result == testresult.Fail || (includeSkipped && t.Result == testresult.Skip) {
+			name := strings.SplitN(t.Name, "/", 2)[0]
+			selected[name] = struct{}{}
+		}
+	}
+	var names []string
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestFailedTestsFromReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-rerun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+	report := `{"tests": [
+	  {"name": "basic", "result": "PASS"},
+	  {"name": "coreos.selinux.enforce", "result": "FAIL"},
+	  {"name": "ext.config.rpm-ostree/upgrade", "result": "FAIL"},
+	  {"name": "ext.config.rpm-ostree", "result": "FAIL"},
+	  {"name": "coreos.tls.fetch-urls", "result": "SKIP"},
+	  {"name": "coreos.ignition.once/reboot", "result": "SKIP"}]}`
+	if err := ioutil.WriteFile(path, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		includeSkipped bool
+		names          []string
+	}{
+		{false, []string{"coreos.selinux.enforce", "ext.config.rpm-ostree"}},
+		{true, []string{"coreos.ignition.once", "coreos.selinux.enforce", "coreos.tls.fetch-urls", "ext.config.rpm-ostree"}},
+	}
+	for _, tt := range tests {
+		names, err := failedTestsFromReport(path, tt.includeSkipped)
+		if err != nil {
+			t.Errorf("skipped %v: %v", tt.includeSkipped, err)
+		} else if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("skipped %v: got %q, expected %q", tt.includeSkipped, names, tt.names)
+		}
+	}
+
+	if _, err := failedTestsFromReport(filepath.Join(dir, "missing.json"), false); err == nil {
+		t.Errorf("missing report: no error")
+	}
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := failedTestsFromReport(path, false); err == nil {
+		t.Errorf("invalid report: no error")
+	}
+}
+
+func TestExtTestDirs(t *testing.T) {
+	cmdline := []string{
+		"kola", "run", "-p", "qemu-unpriv",
+		"-E", "/srv/a", "--exttest", "/srv/b", "--exttest=/srv/c",
+		"-E/srv/d", "-E=/srv/e", "--parallel", "2",
+		"--", "-E", "/srv/f",
+	}
+	expected := []string{"/srv/a", "/srv/b", "/srv/c", "/srv/d", "/srv/e"}
+	if dirs := extTestDirs(cmdline); !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("got %q, expected %q", dirs, expected)
+	}
+	if dirs := extTestDirs([]string{"kola", "run", "-E"}); dirs != nil {
+		t.Errorf("missing value: got %q", dirs)
+	}
+}
+
+func TestApplyProps(t *testing.T) {
+	var platform, image string
+	var swtpm bool
+	var externals []string
+	cmd := &cobra.Command{}
+	cmd.Flags().StringVarP(&platform, "platform", "p", "", "")
+	cmd.Flags().StringVar(&image, "qemu-image", "", "")
+	cmd.Flags().BoolVar(&swtpm, "qemu-swtpm", false, "")
+	cmd.Flags().StringArrayVarP(&externals, "exttest", "E", nil, "")
+	if err := cmd.Flags().Parse([]string{"--qemu-image", "new.qcow2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	props := &kolaProps{
+		Cmdline:  []string{"kola", "run", "-p", "qemu-unpriv", "-E", "/srv/config"},
+		Platform: "qemu-unpriv",
+		QEMU:     qemuProps{Image: "old.qcow2", Swtpm: true},
+		// no such flag
+		GCE: gceProps{Image: "projects/foo"},
+	}
+	if err := applyProps(cmd, props); err != nil {
+		t.Fatal(err)
+	}
+	if platform != "qemu-unpriv" {
+		t.Errorf("platform: got %q", platform)
+	}
+	if image != "new.qcow2" {
+		t.Errorf("command line qemu-image overridden with %q", image)
+	}
+	if !swtpm {
+		t.Errorf("qemu-swtpm not applied")
+	}
+	if !reflect.DeepEqual(externals, []string{"/srv/config"}) {
+		t.Errorf("exttest: got %q", externals)
+	}
+
+	// directories given on the command line replace the recorded ones
+	externals = nil
+	cmd = &cobra.Command{}
+	cmd.Flags().StringArrayVarP(&externals, "exttest", "E", nil, "")
+	if err := cmd.Flags().Parse([]string{"-E", "/srv/other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProps(cmd, props); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(externals, []string{"/srv/other"}) {
+		t.Errorf("command line exttest: got %q", externals)
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -57,8 +58,12 @@ var (
 	spawnSetSSHKeys     bool
 	spawnSSHKeys        []string
 	spawnJSONInfoFd     int
+	spawnCheckpoint     bool
 )
 
+// spawnCheckpointName is the name of the snapshot taken with --checkpoint
+const spawnCheckpointName = "kola-spawn"
+
 func init() {
 	cmdSpawn.Flags().IntVarP(&spawnNodeCount, "nodecount", "c", 1, "number of nodes to spawn")
 	cmdSpawn.Flags().StringVarP(&spawnUserData, "userdata", "u", "", "file containing userdata to pass to the instances")
@@ -71,6 +76,7 @@ func init() {
 	cmdSpawn.Flags().StringVar(&spawnMachineOptions, "qemu-options", "", "experimental: path to QEMU machine options JSON")
 	cmdSpawn.Flags().IntVarP(&spawnJSONInfoFd, "json-info-fd", "", -1, "experimental: write JSON information about spawned machines")
 	cmdSpawn.Flags().BoolVarP(&spawnSetSSHKeys, "keys", "k", false, "add SSH keys from --key options")
+	cmdSpawn.Flags().BoolVar(&spawnCheckpoint, "checkpoint", false, "snapshot the instances once booted and offer to restore them when the shell exits (qemu only)")
 	cmdSpawn.Flags().StringSliceVar(&spawnSSHKeys, "key", nil, "path to SSH public key (default: SSH agent + ~/.ssh/id_{rsa,dsa,ecdsa,ed25519}.pub)")
 	root.AddCommand(cmdSpawn)
 }
@@ -97,6 +103,15 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Cannot use --reconnect on non-qemu platforms %v", kolaPlatform)
 	}
 
+	if spawnCheckpoint {
+		if !strings.HasPrefix(kolaPlatform, "qemu") {
+			return fmt.Errorf("Cannot use --checkpoint on non-qemu platforms %v", kolaPlatform)
+		}
+		if !spawnShell {
+			return fmt.Errorf("--checkpoint requires --shell")
+		}
+	}
+
 	var userdata *conf.UserData
 	if spawnUserData != "" {
 		userbytes, err := ioutil.ReadFile(spawnUserData)
@@ -161,6 +176,7 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 	}
 
 	var someMach platform.Machine
+	var machs []platform.Machine
 	// XXX: should spawn in parallel
 	for i := 0; i < spawnNodeCount; i++ {
 		var mach platform.Machine
@@ -208,6 +224,7 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 		}
 
 		someMach = mach
+		machs = append(machs, mach)
 	}
 
 	if spawnShell {
@@ -217,6 +234,14 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 				return errors.Wrapf(err, "Setting shell prompt failed")
 			}
 		}
+		if spawnCheckpoint {
+			for _, mach := range machs {
+				if err := mach.(platform.SnapshotMachine).Snapshot(spawnCheckpointName); err != nil {
+					return errors.Wrapf(err, "Checkpointing %s failed", mach.ID())
+				}
+			}
+			fmt.Printf("Saved checkpoint %s\n", spawnCheckpointName)
+		}
 		for {
 			var bootId string
 			if spawnReconnect {
@@ -225,6 +250,16 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 				}
 			}
 			err = platform.Manhole(someMach)
+			if spawnCheckpoint {
+				if promptYesNo("Restore the checkpoint and reconnect? [y/N] ") {
+					for _, mach := range machs {
+						if err := mach.(platform.SnapshotMachine).Restore(spawnCheckpointName); err != nil {
+							return errors.Wrapf(err, "Restoring %s failed", mach.ID())
+						}
+					}
+					continue
+				}
+			}
 			if !spawnReconnect {
 				return errors.Wrapf(err, "Manhole failed")
 			}
@@ -242,6 +277,18 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// promptYesNo asks a question on stdout and returns whether the answer read
+// from stdin is yes. End of input is a no.
+func promptYesNo(prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
 func addSSHKeys(userdata *conf.UserData) (*conf.UserData, error) {
 	// if no keys specified, use keys from agent plus ~/.ssh/id_{rsa,dsa,ecdsa,ed25519}.pub
 	if len(spawnSSHKeys) == 0 {
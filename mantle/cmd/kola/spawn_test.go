@@ -0,0 +1,72 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPromptYesNo(t *testing.T) {
+	defer func(stdin, stdout *os.File) {
+		os.Stdin = stdin
+		os.Stdout = stdout
+	}(os.Stdin, os.Stdout)
+
+	for input, expected := range map[string]bool{
+		"y\n":        true,
+		"Y\n":        true,
+		"yes\n":      true,
+		" YES \r\n":  true,
+		"y":          true,
+		"n\n":        false,
+		"\n":         false,
+		"":           false,
+		"yes please": false,
+		"sure\n":     false,
+	} {
+		stdin, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		stdout, err := ioutil.TempFile("", "kola-prompt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdin = stdin
+		os.Stdout = stdout
+
+		got := promptYesNo("Continue? [y/N] ")
+
+		stdin.Close()
+		stdout.Close()
+		prompt, err := ioutil.ReadFile(stdout.Name())
+		os.Remove(stdout.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(prompt) != "Continue? [y/N] " {
+			t.Errorf("%q: prompted %q", input, prompt)
+		}
+		if got != expected {
+			t.Errorf("%q: got %v, expected %v", input, got, expected)
+		}
+	}
+}
@@ -100,3 +100,19 @@ func (build *Build) WriteMeta(path string, validate bool) error {
 	}
 	return ioutil.WriteFile(path, out, 0644)
 }
+
+// Packages returns the names of the packages in a pkgdiff, whose items are
+// [name, type, details] lists as output by `rpm-ostree db diff`.
+func (diff PackageSetDifferences) Packages() []string {
+	var names []string
+	for _, item := range diff {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) == 0 {
+			continue
+		}
+		if name, ok := fields[0].(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -105,3 +105,17 @@ func TestBuildURL(t *testing.T) {
 		}
 	}
 }
+
+func TestPkgdiffPackages(t *testing.T) {
+	b, err := ParseBuild("../../fixtures/fcos.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	pkgs := b.PkgdiffBetweenBuilds.Packages()
+	if len(pkgs) != 2 || pkgs[0] != "podman" || pkgs[1] != "podman-plugins" {
+		t.Errorf("unexpected packages %v", pkgs)
+	}
+	if pkgs := b.PkgdiffAgainstParent.Packages(); len(pkgs) != 0 {
+		t.Errorf("unexpected parent packages %v", pkgs)
+	}
+}
@@ -71,7 +71,9 @@ type H struct {
 
 	isParallel bool
 
-	reporters reporters.Reporters
+	reporters   reporters.Reporters
+	attachments []reporters.Attachment // guarded by mu
+	metrics     []reporters.Metric     // guarded by mu
 }
 
 func (c *H) parentContext() context.Context {
@@ -359,6 +361,62 @@ func (h *H) TempFile(prefix string) *os.File {
 	return tmp
 }
 
+// Attach records a file produced by the test, such as a console log,
+// so that reporters can link to it. The file should normally live under
+// OutputDir, in which case it is reported relative to the suite output
+// directory. Attach may be called from multiple goroutines.
+func (h *H) Attach(name, path, mediaType string) {
+	if _, err := os.Stat(path); err != nil {
+		h.log(fmt.Sprintf("Failed to attach %s: %v", name, err))
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		if base, err := filepath.Abs(h.suite.opts.OutputDir); err == nil {
+			if rel, err := filepath.Rel(base, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attachments = append(h.attachments, reporters.Attachment{
+		Name:      name,
+		Path:      path,
+		MediaType: mediaType,
+	})
+}
+
+// Attachments returns the files attached to the test so far.
+func (h *H) Attachments() []reporters.Attachment {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]reporters.Attachment(nil), h.attachments...)
+}
+
+// RecordMetric records a named measurement, such as a boot time in
+// seconds, so that reporters include it with the test's result. Recording
+// the same name again replaces the previous value. RecordMetric may be
+// called from multiple goroutines.
+func (h *H) RecordMetric(name string, value float64, unit string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m := reporters.Metric{Name: name, Value: value, Unit: unit}
+	for i := range h.metrics {
+		if h.metrics[i].Name == name {
+			h.metrics[i] = m
+			return
+		}
+	}
+	h.metrics = append(h.metrics, m)
+}
+
+// Metrics returns the measurements recorded by the test so far.
+func (h *H) Metrics() []reporters.Metric {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]reporters.Metric(nil), h.metrics...)
+}
+
 // Parallel signals that this test is to be run in parallel with (and only with)
 // other parallel tests.
 func (t *H) Parallel() {
@@ -381,6 +439,19 @@ func (t *H) Parallel() {
 	t.start = time.Now()
 }
 
+// Wait calls wait, which blocks until the test can go on, such as while
+// waiting for a resource held by other tests. The test doesn't count
+// against the parallelism limit meanwhile. It must be called after
+// Parallel.
+func (t *H) Wait(wait func()) {
+	if !t.isParallel {
+		panic("harness: H.Wait called before H.Parallel")
+	}
+	t.suite.release()
+	wait()
+	t.suite.waitParallel()
+}
+
 func tRunner(t *H, fn func(t *H)) {
 	t.ctx, t.cancel = context.WithCancel(t.parentContext())
 	defer t.cancel()
@@ -500,7 +571,7 @@ func (t *H) report() {
 	// could also write verbosely to the 'reporter sink'.  I'm fine with
 	// this being a TODO if you don't want to tackle it in this initial
 	// PR.
-	t.reporters.ReportTest(t.name, status, t.duration, t.output.Bytes())
+	t.reporters.ReportTest(t.name, status, t.duration, t.output.Bytes(), t.Attachments(), t.Metrics())
 }
 
 // CleanOutputDir creates/empties an output directory and returns the cleaned path.
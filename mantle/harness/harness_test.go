@@ -28,6 +28,9 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/coreos/mantle/harness/reporters"
+	"github.com/coreos/mantle/harness/testresult"
 )
 
 func TestMain(m *testing.M) {
@@ -487,3 +490,123 @@ func TestTempFile(t *testing.T) {
 		t.Errorf("%q missing %q prefix", second, "second")
 	}
 }
+
+type recordingReporter struct {
+	attachments map[string][]reporters.Attachment
+	metrics     map[string][]reporters.Metric
+}
+
+func (r *recordingReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, attachments []reporters.Attachment, metrics []reporters.Metric) {
+	r.attachments[name] = attachments
+	r.metrics[name] = metrics
+}
+
+func (r *recordingReporter) Output(path string) error { return nil }
+
+func (r *recordingReporter) SetResult(result testresult.TestResult) {}
+
+func TestAttach(t *testing.T) {
+	var suitedir string
+	if dir, err := ioutil.TempDir("", ""); err != nil {
+		t.Fatal(err)
+	} else {
+		defer os.RemoveAll(dir)
+		suitedir = filepath.Join(dir, "_test_temp")
+	}
+
+	rep := &recordingReporter{
+		attachments: map[string][]reporters.Attachment{},
+		metrics:     map[string][]reporters.Metric{},
+	}
+	opts := Options{
+		OutputDir: suitedir,
+		Verbose:   true,
+		Reporters: reporters.Reporters{rep},
+	}
+	suite := NewSuite(opts, Tests{
+		"Attach": func(h *H) {
+			path := filepath.Join(h.OutputDir(), "console.txt")
+			if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+				h.Fatal(err)
+			}
+			h.Attach("console", path, "text/plain")
+			h.Attach("missing", filepath.Join(h.OutputDir(), "nope"), "text/plain")
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Error(err)
+	}
+
+	expect := []reporters.Attachment{
+		{Name: "console", Path: filepath.Join("Attach", "console.txt"), MediaType: "text/plain"},
+	}
+	if got := rep.attachments["Attach"]; !reflect.DeepEqual(got, expect) {
+		t.Errorf("%v != %v", got, expect)
+	}
+}
+
+func TestRecordMetric(t *testing.T) {
+	rep := &recordingReporter{
+		attachments: map[string][]reporters.Attachment{},
+		metrics:     map[string][]reporters.Metric{},
+	}
+	opts := Options{Reporters: reporters.Reporters{rep}}
+	suite := NewSuite(opts, Tests{
+		"Metric": func(h *H) {
+			h.RecordMetric("boot", 10, "s")
+			h.RecordMetric("memory", 300, "MiB")
+			h.RecordMetric("boot", 12, "s")
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Error(err)
+	}
+
+	expect := []reporters.Metric{
+		{Name: "boot", Value: 12, Unit: "s"},
+		{Name: "memory", Value: 300, Unit: "MiB"},
+	}
+	if got := rep.metrics["Metric"]; !reflect.DeepEqual(got, expect) {
+		t.Errorf("%v != %v", got, expect)
+	}
+}
+
+func TestWait(t *testing.T) {
+	// With a single slot, a test waiting on another one deadlocks unless
+	// it lends its slot while waiting.
+	ch := make(chan struct{})
+	suite := NewSuite(Options{Parallel: 1}, Tests{
+		"Waiter": func(h *H) {
+			h.Parallel()
+			h.Wait(func() { <-ch })
+		},
+		"Closer": func(h *H) {
+			h.Parallel()
+			close(ch)
+		},
+	})
+
+	done := make(chan error)
+	buf := &bytes.Buffer{}
+	go func() {
+		done <- suite.runTests(buf, nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Log("\n" + buf.String())
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("waiting test held its slot")
+	}
+	if suite.running != 1 || suite.waiting != 0 {
+		t.Errorf("running and waiting: got %d and %d", suite.running, suite.waiting)
+	}
+}
@@ -31,6 +31,9 @@ type jsonReporter struct {
 	// Context variables
 	Platform string `json:"platform"`
 	Version  string `json:"version"`
+
+	// RerunOf is the path of the report whose failures this run repeats
+	RerunOf string `json:"rerunOf,omitempty"`
 }
 
 type jsonTest struct {
@@ -38,6 +41,9 @@ type jsonTest struct {
 	Result   testresult.TestResult `json:"result"`
 	Duration time.Duration         `json:"duration"`
 	Output   string                `json:"output"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Metrics     []Metric     `json:"metrics,omitempty"`
 }
 
 func NewJSONReporter(filename, platform, version string) *jsonReporter {
@@ -48,12 +54,14 @@ func NewJSONReporter(filename, platform, version string) *jsonReporter {
 	}
 }
 
-func (r *jsonReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte) {
+func (r *jsonReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, attachments []Attachment, metrics []Metric) {
 	r.Tests = append(r.Tests, jsonTest{
-		Name:     name,
-		Result:   result,
-		Duration: duration,
-		Output:   string(b),
+		Name:        name,
+		Result:      result,
+		Duration:    duration,
+		Output:      string(b),
+		Attachments: attachments,
+		Metrics:     metrics,
 	})
 }
 
@@ -0,0 +1,160 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporters
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+type junitReporter struct {
+	mu       sync.Mutex
+	filename string
+	platform string
+	version  string
+	result   testresult.TestResult
+	tests    []junitTestCase
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitMessage   `xml:"failure,omitempty"`
+	Skipped    *junitMessage   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+
+	duration time.Duration
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// NewJUnitReporter creates a reporter writing JUnit XML to filename.
+// Attachments are listed in each test case's system-out using the
+// [[ATTACHMENT|path]] convention understood by Jenkins, and metrics are
+// recorded as test case properties.
+func NewJUnitReporter(filename, platform, version string) *junitReporter {
+	return &junitReporter{
+		filename: filename,
+		platform: platform,
+		version:  version,
+	}
+}
+
+func (r *junitReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, attachments []Attachment, metrics []Metric) {
+	tc := junitTestCase{
+		Name:     name,
+		Time:     fmt.Sprintf("%.3f", duration.Seconds()),
+		duration: duration,
+	}
+	for _, m := range metrics {
+		tc.Properties = append(tc.Properties, junitProperty{
+			Name:  "metric." + m.Name,
+			Value: strings.TrimSpace(fmt.Sprintf("%g %s", m.Value, m.Unit)),
+		})
+	}
+	switch result {
+	case testresult.Fail:
+		tc.Failure = &junitMessage{Message: "test failed"}
+	case testresult.Skip:
+		tc.Skipped = &junitMessage{}
+	}
+
+	var out strings.Builder
+	out.Write(b)
+	for _, a := range attachments {
+		fmt.Fprintf(&out, "[[ATTACHMENT|%s]]\n", a.Path)
+	}
+	tc.SystemOut = out.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tests = append(r.tests, tc)
+}
+
+func (r *junitReporter) Output(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	suite := junitTestSuite{
+		Name:  r.platform,
+		Tests: len(r.tests),
+		Properties: []junitProperty{
+			{Name: "platform", Value: r.platform},
+			{Name: "version", Value: r.version},
+			{Name: "result", Value: string(r.result)},
+		},
+		TestCases: r.tests,
+	}
+	var total time.Duration
+	for _, tc := range r.tests {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		total += tc.duration
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	f, err := os.Create(filepath.Join(path, r.filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	return enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}})
+}
+
+func (r *junitReporter) SetResult(result testresult.TestResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result = result
+}
@@ -20,11 +20,28 @@ import (
 	"github.com/coreos/mantle/harness/testresult"
 )
 
+// Attachment is a file produced by a test, such as a console log or a
+// screenshot, that reporters should link to. Path is relative to the
+// suite output directory when the file lives inside it.
+type Attachment struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	MediaType string `json:"mediaType,omitempty"`
+}
+
+// Metric is a named measurement recorded by a test, such as a boot time
+// or a memory usage.
+type Metric struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
 type Reporters []Reporter
 
-func (reps Reporters) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte) {
+func (reps Reporters) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, attachments []Attachment, metrics []Metric) {
 	for _, r := range reps {
-		r.ReportTest(name, result, duration, b)
+		r.ReportTest(name, result, duration, b, attachments, metrics)
 	}
 }
 
@@ -45,7 +62,7 @@ func (reps Reporters) SetResult(s testresult.TestResult) {
 }
 
 type Reporter interface {
-	ReportTest(string, testresult.TestResult, time.Duration, []byte)
+	ReportTest(string, testresult.TestResult, time.Duration, []byte, []Attachment, []Metric)
 	Output(string) error
 	SetResult(testresult.TestResult)
 }
@@ -0,0 +1,381 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ajeddeloh/yaml"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/network/journal"
+)
+
+// AVCAllowlistFileName is the name of the SELinux denial allowlist kola
+// reads from the config repository.
+const AVCAllowlistFileName = "kola-avc-allowlist.yaml"
+
+// SeverityIgnore drops a match without logging it
+const SeverityIgnore = "ignore"
+
+var (
+	avcDenialRe = regexp.MustCompile(`avc:\s+denied\s+\{\s*([^}]*?)\s*\}(.*)`)
+	avcFieldRe  = regexp.MustCompile(`\b(comm|scontext|tcontext|tclass|permissive)=("[^"]*"|\S+)`)
+	auditIDRe   = regexp.MustCompile(`audit\(\d+\.\d+:(\d+)\)`)
+	avcRuleRe   = regexp.MustCompile(`^(\S+)\s+(\S+):(\S+)(?:\s+\{\s*([^}]*?)\s*\})?$`)
+)
+
+// AVCDenials are the SELinux denials of one source type, target type and
+// class found in a journal, with the permissions denied.
+type AVCDenials struct {
+	Source      string
+	Target      string
+	Class       string
+	Permissions []string
+	Commands    []string
+	Count       int
+	Permissive  bool
+}
+
+// String returns the denials as an audit2allow rule.
+func (d *AVCDenials) String() string {
+	return fmt.Sprintf("allow %s %s:%s { %s };", d.Source, d.Target, d.Class, strings.Join(d.Permissions, " "))
+}
+
+// contextType returns the type of an SELinux context such as
+// "system_u:system_r:init_t:s0".
+func contextType(context string) string {
+	fields := strings.Split(context, ":")
+	if len(fields) < 3 {
+		return context
+	}
+	return fields[2]
+}
+
+// ParseAVCDenials reads a journal in export format and returns its SELinux
+// denials, grouped by source type, target type and class, and sorted.
+// Denials the kernel logged to both the audit and kmsg transports are only
+// counted once: audit serials are unique within a boot.
+func ParseAVCDenials(r io.Reader) ([]*AVCDenials, error) {
+	groups := make(map[string]*AVCDenials)
+	seen := make(map[string]bool)
+	reader := journal.NewExportReader(r)
+	for {
+		entry, err := reader.ReadEntry()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// The recorder may have been cut off mid-entry
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		msg := entry[journal.FIELD_MESSAGE]
+		m := avcDenialRe.FindSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		serial := string(entry["_AUDIT_ID"])
+		if serial == "" {
+			if idm := auditIDRe.FindSubmatch(msg); idm != nil {
+				serial = string(idm[1])
+			}
+		}
+		if serial != "" {
+			id := string(entry[journal.FIELD_BOOT_ID]) + ":" + serial
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+
+		fields := make(map[string]string)
+		for _, f := range avcFieldRe.FindAllSubmatch(m[2], -1) {
+			fields[string(f[1])] = strings.Trim(string(f[2]), `"`)
+		}
+		d := &AVCDenials{
+			Source: contextType(fields["scontext"]),
+			Target: contextType(fields["tcontext"]),
+			Class:  fields["tclass"],
+		}
+		key := d.Source + " " + d.Target + ":" + d.Class
+		if g, ok := groups[key]; ok {
+			d = g
+		} else {
+			groups[key] = d
+		}
+		d.Count++
+		d.Permissive = d.Permissive || fields["permissive"] == "1"
+		for _, perm := range strings.Fields(string(m[1])) {
+			if !hasString(perm, d.Permissions) {
+				d.Permissions = append(d.Permissions, perm)
+			}
+		}
+		if comm := fields["comm"]; comm != "" && !hasString(comm, d.Commands) {
+			d.Commands = append(d.Commands, comm)
+		}
+	}
+
+	var ret []*AVCDenials
+	for _, d := range groups {
+		sort.Strings(d.Permissions)
+		sort.Strings(d.Commands)
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
+	return ret, nil
+}
+
+// AVCRule allows SELinux denials. Allow is an audit2allow-like rule,
+// "source_t target_t:class { perm ... }", whose types and class may be
+// glob patterns; without permissions, all of them are allowed. Empty
+// Platforms and Tests match all of them.
+type AVCRule struct {
+	Allow     string   `yaml:"allow"`
+	Severity  string   `yaml:"severity"`
+	Tracker   string   `yaml:"tracker"`
+	Platforms []string `yaml:"platforms"`
+	Tests     []string `yaml:"tests"`
+
+	source      string
+	target      string
+	class       string
+	permissions []string
+}
+
+// AVCAllowlist holds the rules loaded with LoadAVCAllowlist.
+var AVCAllowlist []*AVCRule
+
+// AVCSeverity is the severity of denials no rule allows: SeverityFail,
+// SeverityWarn or SeverityIgnore to turn the check off.
+var AVCSeverity = SeverityFail
+
+// compile validates the rule and defaults its severity.
+func (r *AVCRule) compile() error {
+	m := avcRuleRe.FindStringSubmatch(strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(r.Allow, "allow ")), ";"))
+	if m == nil {
+		return fmt.Errorf("malformed rule %q", r.Allow)
+	}
+	r.source, r.target, r.class = m[1], m[2], m[3]
+	r.permissions = strings.Fields(m[4])
+	for _, pattern := range append([]string{r.source, r.target, r.class}, r.Tests...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "rule %q: pattern %q", r.Allow, pattern)
+		}
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarn
+	case SeverityWarn, SeverityIgnore:
+	default:
+		return fmt.Errorf("rule %q: unknown severity %q", r.Allow, r.Severity)
+	}
+	return nil
+}
+
+// LoadAVCAllowlist adds the rules in the file at path to AVCAllowlist.
+func LoadAVCAllowlist(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []*AVCRule
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return errors.Wrapf(err, "parsing %s", path)
+	}
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return errors.Wrapf(err, "%s", path)
+		}
+	}
+	AVCAllowlist = append(AVCAllowlist, rules...)
+	return nil
+}
+
+// applies reports whether the rule is checked for t on pltfrm.
+func (r *AVCRule) applies(t *register.Test, pltfrm string) bool {
+	if len(r.Platforms) > 0 && !hasString(pltfrm, r.Platforms) &&
+		!(pltfrm == "qemu-unpriv" && hasString("qemu", r.Platforms)) {
+		return false
+	}
+	if len(r.Tests) == 0 {
+		return true
+	}
+	for _, pattern := range r.Tests {
+		if match, _ := filepath.Match(pattern, t.Name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the rule allows all the denials of d.
+func (r *AVCRule) allows(d *AVCDenials) bool {
+	for _, p := range []struct{ pattern, s string }{
+		{r.source, d.Source},
+		{r.target, d.Target},
+		{r.class, d.Class},
+	} {
+		if match, _ := filepath.Match(p.pattern, p.s); !match {
+			return false
+		}
+	}
+	if len(r.permissions) == 0 {
+		return true
+	}
+	for _, perm := range d.Permissions {
+		if !hasString(perm, r.permissions) {
+			return false
+		}
+	}
+	return true
+}
+
+// avcRules returns the rules checked for t on pltfrm: those it declares,
+// which are ignored, then the allowlist.
+func avcRules(t *register.Test, pltfrm string) ([]*AVCRule, error) {
+	var rules []*AVCRule
+	for _, allow := range t.AllowedAVCs {
+		rule := &AVCRule{Allow: allow, Severity: SeverityIgnore}
+		if err := rule.compile(); err != nil {
+			return nil, errors.Wrapf(err, "test %s", t.Name)
+		}
+		rules = append(rules, rule)
+	}
+	for _, rule := range AVCAllowlist {
+		if rule.applies(t, pltfrm) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// writeAVCSummary writes denials to w in the format of audit2allow.
+func writeAVCSummary(w io.Writer, denials []*AVCDenials) {
+	source := ""
+	for _, d := range denials {
+		if d.Source != source {
+			source = d.Source
+			fmt.Fprintf(w, "\n#============= %s ==============\n", source)
+		}
+		fmt.Fprintf(w, "# %d denials", d.Count)
+		if len(d.Commands) > 0 {
+			fmt.Fprintf(w, " from %s", strings.Join(d.Commands, ", "))
+		}
+		if d.Permissive {
+			fmt.Fprint(w, " (permissive)")
+		}
+		fmt.Fprintf(w, "\n%s\n", d)
+	}
+}
+
+// checkAVCDenials checks the journal export of machine id at path for
+// SELinux denials. Denials which no rule allows fail h according to
+// AVCSeverity; allowed ones are logged unless their rule ignores them. The
+// denials are summarized to avc-denials.txt next to the journal and
+// attached to the report.
+func checkAVCDenials(h *harness.H, t *register.Test, pltfrm, id, path string) {
+	if AVCSeverity == SeverityIgnore {
+		return
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		h.Errorf("Checking SELinux denials on machine %s: %v", id, err)
+		return
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err == io.EOF {
+			// empty journal
+			return
+		} else if err != nil {
+			h.Errorf("Checking SELinux denials on machine %s: %v", id, err)
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+	denials, err := ParseAVCDenials(r)
+	if err != nil {
+		h.Errorf("Checking SELinux denials on machine %s: %v", id, err)
+		return
+	}
+	reportAVCDenials(h, t, pltfrm, id, filepath.Dir(path), denials)
+}
+
+// reportAVCDenials reports the SELinux denials found on machine id and
+// writes their summary to dir.
+func reportAVCDenials(h *harness.H, t *register.Test, pltfrm, id, dir string, denials []*AVCDenials) {
+	if len(denials) == 0 {
+		return
+	}
+	rules, err := avcRules(t, pltfrm)
+	if err != nil {
+		h.Error(err)
+		return
+	}
+	reported := false
+	for _, d := range denials {
+		var rule *AVCRule
+		for _, r := range rules {
+			if r.allows(d) {
+				rule = r
+				break
+			}
+		}
+		msg := fmt.Sprintf("Found %d SELinux denials on machine %s: %s", d.Count, id, d)
+		if rule == nil || rule.Severity != SeverityIgnore {
+			reported = true
+		}
+		switch {
+		case rule == nil && AVCSeverity == SeverityFail:
+			h.Error(msg)
+		case rule == nil:
+			h.Log(msg)
+		case rule.Severity == SeverityWarn:
+			if rule.Tracker != "" {
+				msg += ": " + rule.Tracker
+			}
+			h.Log(msg + " (allowed)")
+		}
+	}
+
+	var summary bytes.Buffer
+	writeAVCSummary(&summary, denials)
+	if reported {
+		h.Logf("SELinux denials on machine %s:%s", id, strings.TrimRight(summary.String(), "\n"))
+	}
+	path := filepath.Join(dir, "avc-denials.txt")
+	if err := ioutil.WriteFile(path, bytes.TrimLeft(summary.Bytes(), "\n"), 0644); err != nil {
+		h.Errorf("Writing %s: %v", path, err)
+		return
+	}
+	h.Attach(fmt.Sprintf("%s/avc-denials", id), path, "text/plain")
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+// avcJournal has a denial logged to both the audit and kmsg transports,
+// the same audit serial on the next boot, and an unrelated entry.
+const avcJournal = `__CURSOR=s=1;i=1
+_BOOT_ID=aaaa
+_TRANSPORT=audit
+_AUDIT_ID=100
+MESSAGE=AVC avc:  denied  { read } for  pid=700 comm="rpm-ostree" name="foo" scontext=system_u:system_r:rpm_t:s0 tcontext=system_u:object_r:var_t:s0 tclass=file permissive=0
+
+__CURSOR=s=1;i=2
+_BOOT_ID=aaaa
+_TRANSPORT=kernel
+MESSAGE=audit: type=1400 audit(1600000000.123:100): avc:  denied  { read } for  pid=700 comm="rpm-ostree" name="foo" scontext=system_u:system_r:rpm_t:s0 tcontext=system_u:object_r:var_t:s0 tclass=file permissive=0
+
+__CURSOR=s=1;i=3
+_BOOT_ID=aaaa
+_TRANSPORT=syslog
+MESSAGE=avc is not a denial
+
+__CURSOR=s=1;i=4
+_BOOT_ID=bbbb
+_TRANSPORT=kernel
+MESSAGE=audit: type=1400 audit(1600000100.456:100): avc:  denied  { open write } for  pid=80 comm="systemd" scontext=system_u:system_r:init_t:s0 tcontext=system_u:object_r:var_t:s0 tclass=file permissive=1
+
+__CURSOR=s=1;i=5
+_BOOT_ID=bbbb
+_TRANSPORT=audit
+_AUDIT_ID=101
+MESSAGE=AVC avc:  denied  { getattr } for  pid=701 comm="rpm-ostree" scontext=system_u:system_r:rpm_t:s0 tcontext=system_u:object_r:var_t:s0 tclass=file permissive=0
+
+`
+
+func TestParseAVCDenials(t *testing.T) {
+	denials, err := ParseAVCDenials(strings.NewReader(avcJournal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*AVCDenials{
+		{
+			Source:      "init_t",
+			Target:      "var_t",
+			Class:       "file",
+			Permissions: []string{"open", "write"},
+			Commands:    []string{"systemd"},
+			Count:       1,
+			Permissive:  true,
+		},
+		{
+			Source:      "rpm_t",
+			Target:      "var_t",
+			Class:       "file",
+			Permissions: []string{"getattr", "read"},
+			Commands:    []string{"rpm-ostree"},
+			Count:       2,
+		},
+	}
+	if !reflect.DeepEqual(denials, expected) {
+		for _, d := range denials {
+			t.Logf("got %+v", *d)
+		}
+		t.Errorf("unexpected denials")
+	}
+	if s := denials[1].String(); s != "allow rpm_t var_t:file { getattr read };" {
+		t.Errorf("got rule %q", s)
+	}
+}
+
+func TestAVCRuleCompile(t *testing.T) {
+	r := &AVCRule{Allow: "allow rpm_t var_*:file { read getattr };"}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if r.source != "rpm_t" || r.target != "var_*" || r.class != "file" ||
+		!reflect.DeepEqual(r.permissions, []string{"read", "getattr"}) {
+		t.Errorf("compiled to %+v", r)
+	}
+	if r.Severity != SeverityWarn {
+		t.Errorf("got default severity %q", r.Severity)
+	}
+
+	for _, rule := range []AVCRule{
+		{Allow: ""},
+		{Allow: "rpm_t var_t"},
+		{Allow: "rpm_t var_t:file { read"},
+		{Allow: "rpm_t [var_t:file"},
+		{Allow: "rpm_t var_t:file", Tests: []string{"[basic"}},
+		{Allow: "rpm_t var_t:file", Severity: "fail"},
+	} {
+		if err := rule.compile(); err == nil {
+			t.Errorf("%+v: no error", rule)
+		}
+	}
+}
+
+func TestAVCRuleAllows(t *testing.T) {
+	d := &AVCDenials{
+		Source:      "rpm_t",
+		Target:      "var_lib_t",
+		Class:       "file",
+		Permissions: []string{"getattr", "read"},
+	}
+	tests := []struct {
+		allow  string
+		allows bool
+	}{
+		{"rpm_t var_lib_t:file", true},
+		{"rpm_t var_*:file { read getattr open }", true},
+		{"* *:*", true},
+		{"rpm_t var_lib_t:file { read }", false},
+		{"rpm_t var_lib_t:dir", false},
+		{"init_t var_lib_t:file", false},
+	}
+	for _, tt := range tests {
+		r := &AVCRule{Allow: tt.allow}
+		if err := r.compile(); err != nil {
+			t.Fatalf("%q: %v", tt.allow, err)
+		}
+		if allows := r.allows(d); allows != tt.allows {
+			t.Errorf("%q: allows %v, expected %v", tt.allow, allows, tt.allows)
+		}
+	}
+}
+
+func TestAVCRuleApplies(t *testing.T) {
+	test := &register.Test{Name: "ext.config.selinux"}
+	tests := []struct {
+		platforms []string
+		tests     []string
+		pltfrm    string
+		applies   bool
+	}{
+		{nil, nil, "aws", true},
+		{[]string{"qemu"}, nil, "qemu", true},
+		{[]string{"qemu"}, nil, "qemu-unpriv", true},
+		{[]string{"qemu-unpriv"}, nil, "qemu", false},
+		{[]string{"aws", "gcp"}, nil, "qemu-unpriv", false},
+		{nil, []string{"ext.config.*"}, "aws", true},
+		{nil, []string{"basic", "ext.*.other"}, "aws", false},
+		{[]string{"qemu"}, []string{"ext.*"}, "aws", false},
+	}
+	for _, tt := range tests {
+		r := &AVCRule{Allow: "* *:*", Platforms: tt.platforms, Tests: tt.tests}
+		if err := r.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if applies := r.applies(test, tt.pltfrm); applies != tt.applies {
+			t.Errorf("platforms %v tests %v on %s: applies %v, expected %v", tt.platforms, tt.tests, tt.pltfrm, applies, tt.applies)
+		}
+	}
+}
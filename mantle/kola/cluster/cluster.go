@@ -17,11 +17,13 @@ package cluster
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/fixtures"
 	"github.com/coreos/mantle/platform"
 	"github.com/coreos/pkg/capnslog"
 	"github.com/pkg/errors"
@@ -38,6 +40,10 @@ type TestCluster struct {
 	platform.Cluster
 	NativeFuncs []string
 
+	// Fixtures serves Ignition remote resources to the machines, for
+	// tests flagged RequiresFixtures
+	Fixtures *fixtures.Fixtures
+
 	// If set to true and a sub-test fails all future sub-tests will be skipped
 	FailFast   bool
 	hasFailure bool
@@ -49,11 +55,11 @@ func (t *TestCluster) Run(name string, f func(c TestCluster)) bool {
 		return t.H.Run(name, func(h *harness.H) {
 			func(c TestCluster) {
 				c.Skip("A previous test has already failed")
-			}(TestCluster{H: h, Cluster: t.Cluster})
+			}(TestCluster{H: h, Cluster: t.Cluster, Fixtures: t.Fixtures})
 		})
 	}
 	t.hasFailure = !t.H.Run(name, func(h *harness.H) {
-		f(TestCluster{H: h, Cluster: t.Cluster})
+		f(TestCluster{H: h, Cluster: t.Cluster, Fixtures: t.Fixtures})
 	})
 	return !t.hasFailure
 
@@ -167,3 +173,181 @@ func (t *TestCluster) MustSSH(m platform.Machine, cmd string) []byte {
 func (t *TestCluster) MustSSHf(m platform.Machine, f string, args ...interface{}) []byte {
 	return t.MustSSH(m, fmt.Sprintf(f, args...))
 }
+
+// lineLogger writes each complete line it is given to the test's output
+// as a 'Log' line, prefixed with the machine and stream.
+type lineLogger struct {
+	t      *TestCluster
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.t.Logf("%s%s", l.prefix, l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs a trailing partial line.
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.t.Logf("%s%s", l.prefix, l.buf)
+		l.buf = nil
+	}
+}
+
+// Exec runs req on the given machine in the cluster. Output not sent to
+// req.Stdout or req.Stderr is written to the test's output as 'Log' lines
+// while the command runs. The command is killed if the test is cancelled.
+func (t *TestCluster) Exec(m platform.Machine, req platform.ExecRequest) (*platform.ExecResult, error) {
+	var loggers []*lineLogger
+	if req.Stdout == nil {
+		l := &lineLogger{t: t, prefix: m.ID() + " stdout: "}
+		loggers = append(loggers, l)
+		req.Stdout = l
+	}
+	if req.Stderr == nil {
+		l := &lineLogger{t: t, prefix: m.ID() + " stderr: "}
+		loggers = append(loggers, l)
+		req.Stderr = l
+	}
+	res, err := m.Exec(t.H.Context(), req)
+	for _, l := range loggers {
+		l.flush()
+	}
+	return res, err
+}
+
+// MustExec runs req on the given machine in the cluster, fails the test
+// unless the command exits with status 0, and returns its stdout with
+// surrounding whitespace trimmed. Stdout is also written to req.Stdout, if
+// set; stderr is logged like with Exec.
+func (t *TestCluster) MustExec(m platform.Machine, req platform.ExecRequest) []byte {
+	var stdout bytes.Buffer
+	if req.Stdout != nil {
+		req.Stdout = io.MultiWriter(&stdout, req.Stdout)
+	} else {
+		req.Stdout = &stdout
+	}
+	cmd := req.Command()
+	res, err := t.Exec(m, req)
+	if err == nil && !res.Success() {
+		err = fmt.Errorf("%s", res)
+	}
+	if err != nil {
+		if t.SSHOnTestFailure() {
+			plog.Errorf("dropping to shell: %q on %s failed: %v", cmd, m.ID(), err)
+			platform.Manhole(m)
+		}
+		t.Fatalf("%q on %s failed: %v", cmd, m.ID(), err)
+	}
+	return bytes.TrimSpace(stdout.Bytes())
+}
+
+// MustRun runs argv on the given machine in the cluster like MustExec.
+func (t *TestCluster) MustRun(m platform.Machine, argv ...string) []byte {
+	return t.MustExec(m, platform.ExecRequest{Argv: argv})
+}
+
+// MustRunSudo runs argv as root on the given machine in the cluster like
+// MustExec.
+func (t *TestCluster) MustRunSudo(m platform.Machine, argv ...string) []byte {
+	return t.MustExec(m, platform.ExecRequest{Argv: argv, Sudo: true})
+}
+
+// MustUpload copies the local file src to dst on the given machine in the
+// cluster, failing the test on error.
+func (t *TestCluster) MustUpload(m platform.Machine, src, dst string, opts platform.TransferOptions) {
+	if err := platform.Upload(m, src, dst, opts); err != nil {
+		t.Fatalf("uploading %s to %s on %s: %v", src, dst, m.ID(), err)
+	}
+}
+
+// MustDownload copies the file src on the given machine in the cluster to
+// the local path dst, failing the test on error. A relative dst is taken
+// as relative to the machine's directory in the test's output directory.
+func (t *TestCluster) MustDownload(m platform.Machine, src, dst string, opts platform.TransferOptions) {
+	if !filepath.IsAbs(dst) {
+		dst = filepath.Join(t.H.OutputDir(), m.ID(), dst)
+	}
+	if err := platform.Download(m, src, dst, opts); err != nil {
+		t.Fatalf("downloading %s from %s to %s: %v", src, m.ID(), dst, err)
+	}
+}
+
+// networkFaultMachine returns m as a NetworkFaultMachine, failing the test
+// if it isn't one.
+func (t *TestCluster) networkFaultMachine(m platform.Machine) platform.NetworkFaultMachine {
+	nm, ok := m.(platform.NetworkFaultMachine)
+	if !ok {
+		t.Fatalf("machine %s does not support network faults; set the InjectsNetworkFaults flag", m.ID())
+	}
+	return nm
+}
+
+// MustSetNetworkConditions degrades the network link of the given machine
+// in the cluster with c, failing the test on error.
+func (t *TestCluster) MustSetNetworkConditions(m platform.Machine, c platform.NetworkConditions) {
+	if err := t.networkFaultMachine(m).SetNetworkConditions(c); err != nil {
+		t.Fatalf("setting network conditions on %s: %v", m.ID(), err)
+	}
+}
+
+// MustSetLinkUp sets the network link of the given machine in the cluster
+// up or down, failing the test on error.
+func (t *TestCluster) MustSetLinkUp(m platform.Machine, up bool) {
+	if err := t.networkFaultMachine(m).SetLinkUp(up); err != nil {
+		t.Fatalf("setting link of %s up=%v: %v", m.ID(), up, err)
+	}
+}
+
+// diskHotplugMachine returns m as a DiskHotplugMachine, failing the test
+// if it isn't one.
+func (t *TestCluster) diskHotplugMachine(m platform.Machine) platform.DiskHotplugMachine {
+	dm, ok := m.(platform.DiskHotplugMachine)
+	if !ok {
+		t.Fatalf("machine %s does not support disk hot-plugging", m.ID())
+	}
+	return dm
+}
+
+// MustUnplugDisk hot-unplugs the disk with the given serial from the
+// given machine in the cluster, failing the test on error.
+func (t *TestCluster) MustUnplugDisk(m platform.Machine, serial string) {
+	if err := t.diskHotplugMachine(m).UnplugDisk(serial); err != nil {
+		t.Fatalf("unplugging disk %s from %s: %v", serial, m.ID(), err)
+	}
+}
+
+// MustPlugDisk plugs the disk with the given serial back into the given
+// machine in the cluster, failing the test on error.
+func (t *TestCluster) MustPlugDisk(m platform.Machine, serial string) {
+	if err := t.diskHotplugMachine(m).PlugDisk(serial); err != nil {
+		t.Fatalf("plugging disk %s into %s: %v", serial, m.ID(), err)
+	}
+}
+
+// MustUnplugDiskPath hot-unplugs a path of the multipath disk with the
+// given serial from the given machine in the cluster, failing the test on
+// error.
+func (t *TestCluster) MustUnplugDiskPath(m platform.Machine, serial string, path int) {
+	if err := t.diskHotplugMachine(m).UnplugDiskPath(serial, path); err != nil {
+		t.Fatalf("unplugging path %d of disk %s from %s: %v", path, serial, m.ID(), err)
+	}
+}
+
+// MustPlugDiskPath plugs a path of the multipath disk with the given
+// serial back into the given machine in the cluster, failing the test on
+// error.
+func (t *TestCluster) MustPlugDiskPath(m platform.Machine, serial string, path int) {
+	if err := t.diskHotplugMachine(m).PlugDiskPath(serial, path); err != nil {
+		t.Fatalf("plugging path %d of disk %s into %s: %v", path, serial, m.ID(), err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/mantle/platform"
+)
+
+var monotonicTimestamp = regexp.MustCompile(`^\[\s*(\d+\.\d+)\]`)
+
+// RecordBootMetrics records the standard metrics of a booted machine:
+// the boot phase times reported by systemd-analyze, the time Ignition
+// spent in the initramfs, the memory in use and the space used on
+// /sysroot. The Ignition duration is skipped if Ignition didn't run in the
+// current boot; other metrics which cannot be collected fail the test.
+func (t *TestCluster) RecordBootMetrics(m platform.Machine) {
+	// systemd only records the finish timestamp once boot is complete
+	t.SSH(m, "systemctl is-system-running --wait")
+
+	props := map[string]float64{}
+	out := t.MustSSH(m, "systemctl show -p InitRDTimestampMonotonic -p UserspaceTimestampMonotonic -p FinishTimestampMonotonic")
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		usec, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", line, err)
+		}
+		props[kv[0]] = usec / 1e6
+	}
+	initrd := props["InitRDTimestampMonotonic"]
+	userspace := props["UserspaceTimestampMonotonic"]
+	finish := props["FinishTimestampMonotonic"]
+	if finish == 0 {
+		t.Fatalf("boot has not finished on %s", m.ID())
+	}
+	// the same arithmetic as systemd-analyze time
+	if initrd != 0 {
+		t.RecordMetric("boot-kernel", initrd, "s")
+		t.RecordMetric("boot-initrd", userspace-initrd, "s")
+	} else {
+		t.RecordMetric("boot-kernel", userspace, "s")
+	}
+	t.RecordMetric("boot-userspace", finish-userspace, "s")
+	t.RecordMetric("boot-time", finish, "s")
+
+	// Ignition runs in the initramfs, so measure it from its journal
+	// messages in the current boot.
+	out = t.MustSSH(m, "journalctl -b 0 -t ignition -o short-monotonic -q --no-pager")
+	var first, last float64
+	for _, line := range strings.Split(string(out), "\n") {
+		match := monotonicTimestamp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		ts, _ := strconv.ParseFloat(match[1], 64)
+		if first == 0 {
+			first = ts
+		}
+		last = ts
+	}
+	if first != 0 {
+		t.RecordMetric("ignition-duration", last-first, "s")
+	}
+
+	out = t.MustSSH(m, "awk '/^MemTotal:/ {total=$2} /^MemAvailable:/ {avail=$2} END {print total-avail}' /proc/meminfo")
+	kib, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		t.Fatalf("parsing memory usage %q: %v", out, err)
+	}
+	t.RecordMetric("memory-used", kib/1024, "MiB")
+
+	out = t.MustSSH(m, "df --output=used -B1M /sysroot | tail -n1")
+	used, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		t.Fatalf("parsing /sysroot usage %q: %v", out, err)
+	}
+	t.RecordMetric("sysroot-used", used, "MiB")
+}
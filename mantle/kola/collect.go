@@ -0,0 +1,79 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+)
+
+// collectedDir is where guest paths are copied to, under the directory of
+// each machine in the test's output directory.
+const collectedDir = "collected"
+
+// pathsToCollect returns the guest paths to copy back after t runs.
+func pathsToCollect(t *register.Test) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, p := range append(append([]string(nil), CollectPaths...), t.CollectPaths...) {
+		p = path.Clean(p)
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// collectPaths copies the guest paths declared by t or on the command line
+// from each machine to the test's output directory and attaches them to
+// the report. Missing paths are skipped; failures are logged but do not
+// fail the test.
+func collectPaths(h *harness.H, t *register.Test, machines []platform.Machine) {
+	paths := pathsToCollect(t)
+	if len(paths) == 0 {
+		return
+	}
+	for _, m := range machines {
+		for _, p := range paths {
+			if !path.IsAbs(p) {
+				h.Logf("Not collecting relative path %s", p)
+				continue
+			}
+			dst := filepath.Join(h.OutputDir(), m.ID(), collectedDir, filepath.FromSlash(p))
+			err := platform.CopyTreeFromMachine(m, p, dst, platform.TransferOptions{})
+			if os.IsNotExist(errors.Cause(err)) {
+				h.Logf("Not collecting %s from %s: no such file or directory", p, m.ID())
+				continue
+			} else if err != nil {
+				h.Logf("Collecting %s from %s: %v", p, m.ID(), err)
+				continue
+			}
+			mediaType := "application/octet-stream"
+			if fi, err := os.Lstat(dst); err == nil && fi.IsDir() {
+				mediaType = "inode/directory"
+			}
+			h.Attach(fmt.Sprintf("%s/%s%s", m.ID(), collectedDir, p), dst, mediaType)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ajeddeloh/yaml"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/system"
+)
+
+// ConsoleRulesFileName is the name of the console rules file kola reads
+// from the config repository.
+const ConsoleRulesFileName = "kola-console-rules.yaml"
+
+const (
+	// SeverityFail fails the test when a rule matches
+	SeverityFail = "fail"
+	// SeverityWarn only logs a rule match
+	SeverityWarn = "warn"
+)
+
+// ConsoleRule describes badness to look for in the console and journal of
+// test machines. Empty Platforms, Arches and Tests match all of them; Tests
+// are glob patterns of test names.
+type ConsoleRule struct {
+	ID        string   `yaml:"id" json:"id"`
+	Desc      string   `yaml:"description" json:"description"`
+	Match     string   `yaml:"match" json:"match"`
+	Severity  string   `yaml:"severity" json:"severity"`
+	Tracker   string   `yaml:"tracker" json:"tracker,omitempty"`
+	Platforms []string `yaml:"platforms" json:"platforms,omitempty"`
+	Arches    []string `yaml:"arches" json:"arches,omitempty"`
+	Tests     []string `yaml:"tests" json:"tests,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// ConsoleMatch is a match of a ConsoleRule. Line is the 1-based line of
+// output the match starts on, and Text that line.
+type ConsoleMatch struct {
+	Rule   *ConsoleRule `json:"rule"`
+	Line   int          `json:"line"`
+	Text   string       `json:"text"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+// String returns a short description of the match.
+func (m ConsoleMatch) String() string {
+	s := m.Rule.Desc
+	if m.Detail != "" {
+		s += fmt.Sprintf(" (%s)", m.Detail)
+	}
+	return s
+}
+
+// ConsoleRules are the rules CheckConsole applies. Rules loaded with
+// LoadConsoleRules replace built-in rules with the same id.
+var ConsoleRules = []*ConsoleRule{
+	{
+		ID:    "emergency-shell",
+		Desc:  "emergency shell",
+		Match: "Press Enter for emergency shell|Starting Emergency Shell|You are in emergency mode",
+	},
+	{
+		ID:    "dracut-fatal",
+		Desc:  "dracut fatal",
+		Match: "dracut: Refusing to continue",
+	},
+	{
+		ID:    "kernel-panic",
+		Desc:  "kernel panic",
+		Match: "Kernel panic - not syncing: (.*)",
+	},
+	{
+		ID:    "kernel-oops",
+		Desc:  "kernel oops",
+		Match: "Oops:",
+	},
+	{
+		ID:    "kernel-warning",
+		Desc:  "kernel warning",
+		Match: `WARNING: CPU: \d+ PID: \d+ at (.+)`,
+	},
+	{
+		ID:    "offline-device-io",
+		Desc:  "failure of disk under I/O",
+		Match: "rejecting I/O to offline device",
+	},
+	{
+		// Failure to set up Packet networking in initramfs,
+		// perhaps due to unresponsive metadata server
+		ID:    "coreos-metadata-network",
+		Desc:  "coreos-metadata failure to set up initramfs network",
+		Match: "Failed to start CoreOS Static Network Agent",
+	},
+	{
+		ID:      "bonding-link-status",
+		Desc:    "excessive bonding link status messages",
+		Match:   "(?s:link status up for interface [^,]+, enabling it in [0-9]+ ms.*?){10}",
+		Tracker: "https://github.com/coreos/bugs/issues/2065",
+	},
+	{
+		ID:      "ext4-delalloc",
+		Desc:    "ext4 delayed allocation failure",
+		Match:   `EXT4-fs \([^)]+\): Delayed block allocation failed for inode \d+ at logical offset \d+ with max blocks \d+ with (error \d+)`,
+		Tracker: "https://github.com/coreos/bugs/issues/2180",
+	},
+	{
+		ID:      "grub-memory-corruption",
+		Desc:    "GRUB memory corruption",
+		Match:   "((alloc|free) magic) (is )?broken",
+		Tracker: "https://github.com/coreos/bugs/issues/2284",
+	},
+	{
+		ID:      "ignition-fetch-cancel",
+		Desc:    "Ignition fetch cancellation race",
+		Match:   `ignition\[[0-9]+\]: failed to fetch config: context canceled`,
+		Tracker: "https://github.com/coreos/bugs/issues/2435",
+	},
+	{
+		ID:      "initrd-cleanup-killed",
+		Desc:    "initrd-cleanup.service terminated",
+		Match:   `initrd-cleanup\.service: Main process exited, code=killed, status=15/TERM`,
+		Tracker: "https://github.com/coreos/bugs/issues/2526",
+	},
+	{
+		// kernel 4.14.11
+		ID:    "bad-page-table",
+		Desc:  "bad page table",
+		Match: `mm/pgtable-generic.c:\d+: bad (p.d|pte)`,
+	},
+	{
+		ID:    "go-panic",
+		Desc:  "Go panic",
+		Match: "panic: (.*)",
+	},
+	{
+		ID:    "segfault",
+		Desc:  "segfault",
+		Match: "SIGSEGV|=11/SEGV",
+	},
+	{
+		ID:    "core-dump",
+		Desc:  "core dump",
+		Match: "[Cc]ore dump",
+	},
+}
+
+// flagRules maps test flags to the rule ids they suppress
+var flagRules = map[register.Flag]string{
+	register.NoEmergencyShellCheck: "emergency-shell",
+}
+
+func init() {
+	for _, rule := range ConsoleRules {
+		if err := rule.compile(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// compile validates the rule, defaults its severity and description, and
+// compiles its expression.
+func (r *ConsoleRule) compile() error {
+	if r.ID == "" {
+		return fmt.Errorf("rule without an id")
+	}
+	if r.Match == "" {
+		return fmt.Errorf("rule %s: no match expression", r.ID)
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityFail
+	case SeverityFail, SeverityWarn:
+	default:
+		return fmt.Errorf("rule %s: unknown severity %q", r.ID, r.Severity)
+	}
+	if r.Desc == "" {
+		r.Desc = r.ID
+	}
+	for _, pattern := range r.Tests {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "rule %s: test pattern %q", r.ID, pattern)
+		}
+	}
+	re, err := regexp.Compile(r.Match)
+	if err != nil {
+		return errors.Wrapf(err, "rule %s", r.ID)
+	}
+	r.re = re
+	return nil
+}
+
+// LoadConsoleRules adds the rules in the file at path to ConsoleRules,
+// replacing built-in rules with the same id.
+func LoadConsoleRules(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules, err := parseConsoleRules(data)
+	if err != nil {
+		return errors.Wrapf(err, "%s", path)
+	}
+	ConsoleRules = mergeConsoleRules(ConsoleRules, rules)
+	return nil
+}
+
+// parseConsoleRules returns the compiled rules in data.
+func parseConsoleRules(data []byte) ([]*ConsoleRule, error) {
+	var rules []*ConsoleRule
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, errors.Wrapf(err, "parsing console rules")
+	}
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// mergeConsoleRules returns rules with each of extra replacing the rule
+// with the same id, or appended if there is none. rules is not modified.
+func mergeConsoleRules(rules, extra []*ConsoleRule) []*ConsoleRule {
+	merged := append([]*ConsoleRule(nil), rules...)
+	for _, rule := range extra {
+		replaced := false
+		for i, existing := range merged {
+			if existing.ID == rule.ID {
+				merged[i] = rule
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, rule)
+		}
+	}
+	return merged
+}
+
+// applies reports whether the rule is checked for t on pltfrm. A nil t or
+// empty pltfrm match rules scoped to any test or platform respectively.
+func (r *ConsoleRule) applies(t *register.Test, pltfrm string) bool {
+	if len(r.Arches) > 0 && !hasString(system.RpmArch(), r.Arches) {
+		return false
+	}
+	if pltfrm != "" && len(r.Platforms) > 0 && !hasString(pltfrm, r.Platforms) &&
+		!(pltfrm == "qemu-unpriv" && hasString("qemu", r.Platforms)) {
+		return false
+	}
+	if t == nil {
+		return true
+	}
+	if hasString(r.ID, t.SuppressConsoleRules) {
+		return false
+	}
+	for flag, id := range flagRules {
+		if id == r.ID && t.HasFlag(flag) {
+			return false
+		}
+	}
+	if len(r.Tests) == 0 {
+		return true
+	}
+	for _, pattern := range r.Tests {
+		if match, _ := filepath.Match(pattern, t.Name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckConsole checks some console output for badness and returns the
+// first match of each rule applying to t on pltfrm. If t is specified, the
+// rules it suppresses are skipped.
+func CheckConsole(output []byte, t *register.Test, pltfrm string) []ConsoleMatch {
+	var ret []ConsoleMatch
+	for _, rule := range ConsoleRules {
+		if !rule.applies(t, pltfrm) {
+			continue
+		}
+		loc := rule.re.FindSubmatchIndex(output)
+		if loc == nil {
+			continue
+		}
+		start := bytes.LastIndexByte(output[:loc[0]], '\n') + 1
+		end := bytes.IndexByte(output[loc[0]:], '\n')
+		if end < 0 {
+			end = len(output)
+		} else {
+			end += loc[0]
+		}
+		match := ConsoleMatch{
+			Rule: rule,
+			Line: bytes.Count(output[:loc[0]], []byte("\n")) + 1,
+			Text: string(bytes.TrimRight(output[start:end], "\r")),
+		}
+		if len(loc) > 3 && loc[2] >= 0 {
+			// include first subexpression
+			match.Detail = string(bytes.TrimRight(output[loc[2]:loc[3]], "\r"))
+		}
+		ret = append(ret, match)
+	}
+	return ret
+}
+
+// reportConsoleMatches fails h for matches of fail rules and logs matches
+// of warn rules found in the output of the named source of machine id.
+func reportConsoleMatches(h *harness.H, matches []ConsoleMatch, id, source string) {
+	for _, m := range matches {
+		msg := fmt.Sprintf("Found %s on machine %s %s line %d [%s]", m, id, source, m.Line, m.Rule.ID)
+		if m.Rule.Tracker != "" {
+			msg += ": " + m.Rule.Tracker
+		}
+		if m.Rule.Severity == SeverityWarn {
+			h.Log(msg)
+		} else {
+			h.Error(msg)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/system"
+)
+
+func findRule(rules []*ConsoleRule, id string) *ConsoleRule {
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return nil
+}
+
+func TestParseConsoleRules(t *testing.T) {
+	extra, err := parseConsoleRules([]byte(`
+- id: segfault
+  description: segmentation fault
+  match: SIGSEGV
+  severity: warn
+- id: selinux-denial
+  match: "avc:  denied"
+  tracker: https://github.com/coreos/fedora-coreos-tracker/issues/1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := mergeConsoleRules(ConsoleRules, extra)
+	if len(rules) != len(ConsoleRules)+1 {
+		t.Errorf("got %d rules, expected %d", len(rules), len(ConsoleRules)+1)
+	}
+	if rule := findRule(rules, "segfault"); rule == nil || rule.Desc != "segmentation fault" || rule.Severity != SeverityWarn {
+		t.Errorf("segfault: not replaced: %+v", rule)
+	}
+	if rule := findRule(rules, "selinux-denial"); rule == nil || rule.Desc != "selinux-denial" || rule.Severity != SeverityFail {
+		t.Errorf("selinux-denial: not defaulted: %+v", rule)
+	}
+	if rule := findRule(ConsoleRules, "segfault"); rule.Severity != SeverityFail {
+		t.Errorf("built-in rules modified")
+	}
+
+	for _, tt := range []struct {
+		data string
+		err  string
+	}{
+		{"- match: foo\n", "rule without an id"},
+		{"- id: foo\n", "no match expression"},
+		{"- id: foo\n  match: foo\n  severity: fatal\n", "unknown severity"},
+		{"- id: foo\n  match: \"(foo\"\n", "missing closing )"},
+		{"- id: foo\n  match: foo\n  tests: [\"[\"]\n", "test pattern"},
+		{"- id: foo\n  match: foo\n  unknown: key\n", "parsing console rules"},
+	} {
+		if _, err := parseConsoleRules([]byte(tt.data)); err == nil {
+			t.Errorf("%q: no error", tt.data)
+		} else if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %q, expected %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestConsoleRuleApplies(t *testing.T) {
+	rule := func(r ConsoleRule) *ConsoleRule {
+		r.Match = "foo"
+		if err := r.compile(); err != nil {
+			t.Fatal(err)
+		}
+		return &r
+	}
+	tests := []struct {
+		name    string
+		rule    *ConsoleRule
+		test    *register.Test
+		pltfrm  string
+		applies bool
+	}{
+		{
+			"unscoped",
+			rule(ConsoleRule{ID: "a"}), &register.Test{Name: "basic"}, "aws",
+			true,
+		},
+		{
+			"this arch",
+			rule(ConsoleRule{ID: "a", Arches: []string{system.RpmArch()}}), nil, "aws",
+			true,
+		},
+		{
+			"other arch",
+			rule(ConsoleRule{ID: "a", Arches: []string{"not-" + system.RpmArch()}}), nil, "aws",
+			false,
+		},
+		{
+			"platform",
+			rule(ConsoleRule{ID: "a", Platforms: []string{"aws"}}), nil, "aws",
+			true,
+		},
+		{
+			"other platform",
+			rule(ConsoleRule{ID: "a", Platforms: []string{"aws"}}), nil, "gcp",
+			false,
+		},
+		{
+			"qemu-unpriv as qemu",
+			rule(ConsoleRule{ID: "a", Platforms: []string{"qemu"}}), nil, "qemu-unpriv",
+			true,
+		},
+		{
+			"any platform",
+			rule(ConsoleRule{ID: "a", Platforms: []string{"aws"}}), nil, "",
+			true,
+		},
+		{
+			"test pattern",
+			rule(ConsoleRule{ID: "a", Tests: []string{"coreos.*"}}), &register.Test{Name: "coreos.selinux.enforce"}, "aws",
+			true,
+		},
+		{
+			"other test",
+			rule(ConsoleRule{ID: "a", Tests: []string{"coreos.*"}}), &register.Test{Name: "basic"}, "aws",
+			false,
+		},
+		{
+			"any test",
+			rule(ConsoleRule{ID: "a", Tests: []string{"coreos.*"}}), nil, "aws",
+			true,
+		},
+		{
+			"suppressed",
+			rule(ConsoleRule{ID: "a"}), &register.Test{Name: "basic", SuppressConsoleRules: []string{"a"}}, "aws",
+			false,
+		},
+		{
+			"suppressed by flag",
+			rule(ConsoleRule{ID: "emergency-shell"}), &register.Test{Name: "basic", Flags: []register.Flag{register.NoEmergencyShellCheck}}, "aws",
+			false,
+		},
+	}
+	for _, tt := range tests {
+		if applies := tt.rule.applies(tt.test, tt.pltfrm); applies != tt.applies {
+			t.Errorf("%s: applies %v, expected %v", tt.name, applies, tt.applies)
+		}
+	}
+}
+
+func TestCheckConsole(t *testing.T) {
+	output := []byte("[    0.000000] Linux version\r\n" +
+		"[    1.000000] systemd[1]: Started foo.\r\n" +
+		"[    2.000000] Kernel panic - not syncing: Attempted to kill init!\r\n" +
+		"[    3.000000] foo[100]: segfault at 0\n" +
+		"[    4.000000] foo.service: Main process exited, code=killed, status=11/SEGV")
+
+	matches := CheckConsole(output, nil, "aws")
+	if len(matches) != 2 {
+		t.Fatalf("got matches %v", matches)
+	}
+	expected := []struct {
+		id, detail, text string
+		line             int
+	}{
+		{"kernel-panic", "Attempted to kill init!", "[    2.000000] Kernel panic - not syncing: Attempted to kill init!", 3},
+		{"segfault", "", "[    4.000000] foo.service: Main process exited, code=killed, status=11/SEGV", 5},
+	}
+	for i, m := range matches {
+		e := expected[i]
+		if m.Rule.ID != e.id || m.Line != e.line || m.Text != e.text || m.Detail != e.detail {
+			t.Errorf("match %d: got %s line %d %q (%q), expected %s line %d %q (%q)",
+				i, m.Rule.ID, m.Line, m.Text, m.Detail, e.id, e.line, e.text, e.detail)
+		}
+	}
+
+	matches = CheckConsole(output, &register.Test{Name: "basic", SuppressConsoleRules: []string{"segfault"}}, "aws")
+	if len(matches) != 1 || matches[0].Rule.ID != "kernel-panic" {
+		t.Errorf("suppressed: got matches %v", matches)
+	}
+
+	if matches := CheckConsole([]byte("all good\n"), nil, "aws"); matches != nil {
+		t.Errorf("clean output: got matches %v", matches)
+	}
+}
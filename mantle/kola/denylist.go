@@ -0,0 +1,127 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/ajeddeloh/yaml"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/system"
+)
+
+// DenylistFileName is the name of the denylist kola reads from the config
+// repository.
+const DenylistFileName = "kola-denylist.yaml"
+
+// snoozeFormat is the format of snooze dates in the denylist
+const snoozeFormat = "2006-01-02"
+
+// DenylistEntry is an entry of a kola-denylist.yaml. Empty Streams, Arches
+// and Platforms match all of them.
+type DenylistEntry struct {
+	Pattern   string   `yaml:"pattern"`
+	Tracker   string   `yaml:"tracker"`
+	Snooze    string   `yaml:"snooze"`
+	Streams   []string `yaml:"streams"`
+	Arches    []string `yaml:"arches"`
+	Platforms []string `yaml:"platforms"`
+}
+
+// denylistReasons maps the patterns in DenylistedTests which came from a
+// denylist file to the reason reported for the tests they skip
+var denylistReasons = map[string]string{}
+
+// LoadDenylist adds the entries of the denylist file at path which apply to
+// stream and pltfrm on this architecture to DenylistedTests.
+func LoadDenylist(path, stream, pltfrm string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries, err := parseDenylist(data, stream, pltfrm)
+	if err != nil {
+		return errors.Wrapf(err, "%s", path)
+	}
+	for _, e := range entries {
+		DenylistedTests = append(DenylistedTests, e.Pattern)
+		denylistReasons[e.Pattern] = e.reason()
+	}
+	return nil
+}
+
+// parseDenylist returns the entries of the denylist data which apply to
+// stream and pltfrm on this architecture. Entries whose snooze date has
+// passed are left out with a warning.
+func parseDenylist(data []byte, stream, pltfrm string) ([]DenylistEntry, error) {
+	var entries []DenylistEntry
+	if err := yaml.UnmarshalStrict(data, &entries); err != nil {
+		return nil, errors.Wrapf(err, "parsing denylist")
+	}
+
+	var applicable []DenylistEntry
+	today := time.Now().UTC().Format(snoozeFormat)
+	for _, e := range entries {
+		if e.Pattern == "" {
+			return nil, fmt.Errorf("entry without a pattern")
+		}
+		if e.Snooze != "" {
+			if _, err := time.Parse(snoozeFormat, e.Snooze); err != nil {
+				return nil, errors.Wrapf(err, "parsing snooze date of %s", e.Pattern)
+			}
+			// dates in this format compare correctly as strings
+			if today > e.Snooze {
+				plog.Warningf("Snooze of %s expired on %s, running it again (%s)", e.Pattern, e.Snooze, e.Tracker)
+				continue
+			}
+		}
+		if len(e.Streams) > 0 && !hasString(stream, e.Streams) {
+			continue
+		}
+		if len(e.Arches) > 0 && !hasString(system.RpmArch(), e.Arches) {
+			continue
+		}
+		if len(e.Platforms) > 0 && !hasString(pltfrm, e.Platforms) &&
+			!(pltfrm == "qemu-unpriv" && hasString("qemu", e.Platforms)) {
+			continue
+		}
+		applicable = append(applicable, e)
+	}
+	return applicable, nil
+}
+
+// reason returns why the entry skips the tests matching its pattern.
+func (e DenylistEntry) reason() string {
+	reason := "denylisted in " + DenylistFileName
+	if e.Tracker != "" {
+		reason += ": " + e.Tracker
+	}
+	if e.Snooze != "" {
+		reason += fmt.Sprintf(" (snoozed until %s)", e.Snooze)
+	}
+	return reason
+}
+
+// denylistReason returns why tests matching the DenylistedTests pattern
+// are skipped.
+func denylistReason(pattern string) string {
+	if reason, ok := denylistReasons[pattern]; ok {
+		return reason
+	}
+	return fmt.Sprintf("denylisted by --denylist-test %s", pattern)
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/system"
+)
+
+func TestParseDenylist(t *testing.T) {
+	future := time.Now().UTC().AddDate(0, 0, 7).Format(snoozeFormat)
+	past := time.Now().UTC().AddDate(0, 0, -7).Format(snoozeFormat)
+	data := fmt.Sprintf(`
+- pattern: always
+  tracker: https://github.com/coreos/fedora-coreos-tracker/issues/1
+- pattern: snoozed
+  snooze: %s
+- pattern: expired
+  snooze: %s
+- pattern: next-only
+  streams:
+    - next
+- pattern: other-arch
+  arches:
+    - not-%s
+- pattern: this-arch
+  arches:
+    - %s
+- pattern: aws-only
+  platforms:
+    - aws
+- pattern: qemu-only
+  platforms:
+    - qemu
+`, future, past, system.RpmArch(), system.RpmArch())
+
+	tests := []struct {
+		stream, pltfrm string
+		patterns       []string
+	}{
+		{"stable", "aws", []string{"always", "snoozed", "this-arch", "aws-only"}},
+		{"next", "gcp", []string{"always", "snoozed", "next-only", "this-arch"}},
+		{"stable", "qemu", []string{"always", "snoozed", "this-arch", "qemu-only"}},
+		{"stable", "qemu-unpriv", []string{"always", "snoozed", "this-arch", "qemu-only"}},
+	}
+	for _, tt := range tests {
+		entries, err := parseDenylist([]byte(data), tt.stream, tt.pltfrm)
+		if err != nil {
+			t.Errorf("%s on %s: %v", tt.stream, tt.pltfrm, err)
+			continue
+		}
+		var patterns []string
+		for _, e := range entries {
+			patterns = append(patterns, e.Pattern)
+		}
+		if !reflect.DeepEqual(patterns, tt.patterns) {
+			t.Errorf("%s on %s: got %v, expected %v", tt.stream, tt.pltfrm, patterns, tt.patterns)
+		}
+	}
+
+	for _, tt := range []struct {
+		entry  DenylistEntry
+		reason string
+	}{
+		{DenylistEntry{Pattern: "a"}, "denylisted in kola-denylist.yaml"},
+		{DenylistEntry{Pattern: "a", Tracker: "https://github.com/coreos/fedora-coreos-tracker/issues/1"}, "denylisted in kola-denylist.yaml: https://github.com/coreos/fedora-coreos-tracker/issues/1"},
+		{DenylistEntry{Pattern: "a", Snooze: future}, fmt.Sprintf("denylisted in kola-denylist.yaml (snoozed until %s)", future)},
+	} {
+		if reason := tt.entry.reason(); reason != tt.reason {
+			t.Errorf("%+v: got reason %q, expected %q", tt.entry, reason, tt.reason)
+		}
+	}
+	if reason := denylistReason("from-flag"); reason != "denylisted by --denylist-test from-flag" {
+		t.Errorf("got reason %q", reason)
+	}
+}
+
+func TestParseDenylistErrors(t *testing.T) {
+	for _, data := range []string{
+		"- tracker: no pattern\n",
+		"- pattern: foo\n  snooze: next week\n",
+		"- pattern: foo\n  unknown: key\n",
+		"pattern: not a list\n",
+	} {
+		if _, err := parseDenylist([]byte(data), "stable", "aws"); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ajeddeloh/yaml"
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// DiagnosticsFileName is the name of the diagnostics file kola reads from
+// the config repository.
+const DiagnosticsFileName = "kola-diagnostics.yaml"
+
+// diagnosticsDir is where diagnostics are written, under the directory of
+// each machine in the test's output directory.
+const diagnosticsDir = "diagnostics"
+
+// diagnosticTimeout bounds each diagnostic command, so a wedged machine
+// doesn't hold up the teardown of the test.
+const diagnosticTimeout = time.Minute
+
+// Diagnostic is a command run as root on the machines of a failed test,
+// whose output is saved as <id>.txt. It is skipped on machines without the
+// Requires program, if set. Empty Platforms match all of them.
+type Diagnostic struct {
+	ID        string   `yaml:"id"`
+	Command   string   `yaml:"command"`
+	Requires  string   `yaml:"requires"`
+	Platforms []string `yaml:"platforms"`
+}
+
+// Diagnostics are the commands collectDiagnostics runs. Diagnostics loaded
+// with LoadDiagnostics replace built-in ones with the same id.
+var Diagnostics = []*Diagnostic{
+	{
+		ID:       "rpm-ostree-status",
+		Command:  "rpm-ostree status --json",
+		Requires: "rpm-ostree",
+	},
+	{
+		ID:      "failed-units",
+		Command: "systemctl --failed --all --no-pager",
+	},
+	{
+		ID:      "ignition",
+		Command: "journalctl -o short-monotonic --no-pager -b -t ignition",
+	},
+	{
+		ID:       "avc-denials",
+		Command:  "ausearch -i -m AVC,USER_AVC,SELINUX_ERR -ts boot",
+		Requires: "ausearch",
+	},
+	{
+		ID:       "coredumps",
+		Command:  "coredumpctl list --no-pager",
+		Requires: "coredumpctl",
+	},
+	{
+		ID:      "network",
+		Command: "ip -d addr; ip route; ip -6 route; cat /etc/resolv.conf",
+	},
+	{
+		ID:       "networkmanager",
+		Command:  "nmcli -f all device show; nmcli -f all connection show",
+		Requires: "nmcli",
+	},
+	{
+		ID:       "podman",
+		Command:  "podman ps -a; podman images; podman pod ps",
+		Requires: "podman",
+	},
+	{
+		ID:       "crio",
+		Command:  "systemctl status --no-pager crio; crictl pods; crictl ps -a",
+		Requires: "crictl",
+	},
+}
+
+// LoadDiagnostics adds the diagnostics in the file at path to Diagnostics,
+// replacing built-in ones with the same id. A diagnostic without a command
+// removes the built-in one.
+func LoadDiagnostics(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	diags, err := parseDiagnostics(data)
+	if err != nil {
+		return errors.Wrapf(err, "%s", path)
+	}
+	Diagnostics = mergeDiagnostics(Diagnostics, diags)
+	return nil
+}
+
+// parseDiagnostics returns the diagnostics in data.
+func parseDiagnostics(data []byte) ([]*Diagnostic, error) {
+	var diags []*Diagnostic
+	if err := yaml.UnmarshalStrict(data, &diags); err != nil {
+		return nil, errors.Wrapf(err, "parsing diagnostics")
+	}
+	for _, d := range diags {
+		if d.ID == "" {
+			return nil, fmt.Errorf("diagnostic without an id")
+		}
+	}
+	return diags, nil
+}
+
+// mergeDiagnostics returns diags with each of extra replacing the one with
+// the same id, or removing it if extra has no command. diags is not
+// modified.
+func mergeDiagnostics(diags, extra []*Diagnostic) []*Diagnostic {
+	merged := diags
+	for _, d := range extra {
+		var kept []*Diagnostic
+		for _, existing := range merged {
+			if existing.ID != d.ID {
+				kept = append(kept, existing)
+			}
+		}
+		if d.Command != "" {
+			kept = append(kept, d)
+		}
+		merged = kept
+	}
+	return merged
+}
+
+// platformDiagnostics returns the diags which run on pltfrm.
+func platformDiagnostics(diags []*Diagnostic, pltfrm string) []*Diagnostic {
+	var matched []*Diagnostic
+	for _, d := range diags {
+		if len(d.Platforms) == 0 || hasString(pltfrm, d.Platforms) ||
+			(pltfrm == "qemu-unpriv" && hasString("qemu", d.Platforms)) {
+			matched = append(matched, d)
+		}
+	}
+	return matched
+}
+
+// script returns the shell script running the diagnostic.
+func (d *Diagnostic) script() string {
+	if d.Requires == "" {
+		return d.Command
+	}
+	requires := shellquote.Join(d.Requires)
+	return fmt.Sprintf("command -v %s >/dev/null || { echo %s; exit 0; }\n%s",
+		requires, shellquote.Join("not installed: "+d.Requires), d.Command)
+}
+
+// collectDiagnostics runs Diagnostics on each machine if the test failed,
+// saving their output to the test's output directory and attaching it to
+// the report. Failures to collect are logged but do not otherwise affect
+// the test.
+func collectDiagnostics(h *harness.H, pltfrm string, machines []platform.Machine) {
+	if NoDiagnostics || !h.Failed() {
+		return
+	}
+	diags := platformDiagnostics(Diagnostics, pltfrm)
+	if len(diags) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, m := range machines {
+		wg.Add(1)
+		go func(m platform.Machine) {
+			defer wg.Done()
+			dir := filepath.Join(h.OutputDir(), m.ID(), diagnosticsDir)
+			if err := os.MkdirAll(dir, 0777); err != nil {
+				h.Logf("Collecting diagnostics from %s: %v", m.ID(), err)
+				return
+			}
+			// Don't wait for every diagnostic to fail on a dead machine
+			client, err := m.SSHClient()
+			if err != nil {
+				h.Logf("Not collecting diagnostics from unreachable %s: %v", m.ID(), err)
+				return
+			}
+			client.Close()
+			for _, d := range diags {
+				path := filepath.Join(dir, d.ID+".txt")
+				if err := runDiagnostic(m, d, path); err != nil {
+					h.Logf("Collecting diagnostic %s from %s: %v", d.ID, m.ID(), err)
+				}
+				h.Attach(fmt.Sprintf("%s/%s/%s", m.ID(), diagnosticsDir, d.ID), path, "text/plain")
+			}
+		}(m)
+	}
+	wg.Wait()
+}
+
+// runDiagnostic runs d on m, writing its output and exit status to path.
+func runDiagnostic(m platform.Machine, d *Diagnostic, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "# %s\n", d.Command)
+	res, err := m.Exec(context.Background(), platform.ExecRequest{
+		Argv:    []string{"sh", "-c", d.script()},
+		Sudo:    true,
+		Stdout:  f,
+		Stderr:  f,
+		Timeout: diagnosticTimeout,
+	})
+	if err != nil {
+		fmt.Fprintf(f, "# %v\n", err)
+		return err
+	}
+	fmt.Fprintf(f, "# %s\n", res)
+	return nil
+}
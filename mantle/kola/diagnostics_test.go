@@ -0,0 +1,178 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/platform"
+)
+
+func diagnosticIDs(diags []*Diagnostic) []string {
+	var ids []string
+	for _, d := range diags {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	builtin := []*Diagnostic{
+		{ID: "failed-units", Command: "systemctl --failed"},
+		{ID: "network", Command: "ip addr"},
+		{ID: "podman", Command: "podman ps -a", Requires: "podman"},
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		ids     []string
+		command string // of the last diagnostic
+	}{
+		{"nothing", "[]\n", []string{"failed-units", "network", "podman"}, "podman ps -a"},
+		{"added", "- id: journal\n  command: journalctl -b\n", []string{"failed-units", "network", "podman", "journal"}, "journalctl -b"},
+		{"replaced", "- id: network\n  command: ip -d addr\n", []string{"failed-units", "podman", "network"}, "ip -d addr"},
+		{"removed", "- id: podman\n", []string{"failed-units", "network"}, "ip addr"},
+		{"removed and added", "- id: network\n- id: podman\n  command: podman pod ps\n", []string{"failed-units", "podman"}, "podman pod ps"},
+	}
+	for _, tt := range tests {
+		extra, err := parseDiagnostics([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		diags := mergeDiagnostics(builtin, extra)
+		if ids := diagnosticIDs(diags); !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: got %q, expected %q", tt.name, ids, tt.ids)
+		} else if command := diags[len(diags)-1].Command; command != tt.command {
+			t.Errorf("%s: got command %q, expected %q", tt.name, command, tt.command)
+		}
+	}
+	if ids := diagnosticIDs(builtin); !reflect.DeepEqual(ids, []string{"failed-units", "network", "podman"}) {
+		t.Errorf("built-in diagnostics modified: %q", ids)
+	}
+
+	for _, tt := range []struct {
+		data string
+		err  string
+	}{
+		{"- command: true\n", "diagnostic without an id"},
+		{"- id: foo\n  unknown: key\n", "parsing diagnostics"},
+		{"id: not a list\n", "parsing diagnostics"},
+	} {
+		if _, err := parseDiagnostics([]byte(tt.data)); err == nil {
+			t.Errorf("%q: no error", tt.data)
+		} else if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %q, expected %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestPlatformDiagnostics(t *testing.T) {
+	diags := []*Diagnostic{
+		{ID: "all", Command: "true"},
+		{ID: "aws", Command: "true", Platforms: []string{"aws"}},
+		{ID: "qemu", Command: "true", Platforms: []string{"qemu"}},
+		{ID: "unpriv", Command: "true", Platforms: []string{"qemu-unpriv", "gcp"}},
+	}
+	for _, tt := range []struct {
+		pltfrm string
+		ids    []string
+	}{
+		{"aws", []string{"all", "aws"}},
+		{"gcp", []string{"all", "unpriv"}},
+		{"qemu", []string{"all", "qemu"}},
+		{"qemu-unpriv", []string{"all", "qemu", "unpriv"}},
+		{"azure", []string{"all"}},
+	} {
+		if ids := diagnosticIDs(platformDiagnostics(diags, tt.pltfrm)); !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: got %q, expected %q", tt.pltfrm, ids, tt.ids)
+		}
+	}
+}
+
+func TestDiagnosticScript(t *testing.T) {
+	for _, tt := range []struct {
+		diag   Diagnostic
+		output string
+	}{
+		{Diagnostic{Command: "echo ok"}, "ok\n"},
+		{Diagnostic{Command: "echo ok", Requires: "sh"}, "ok\n"},
+		{Diagnostic{Command: "echo ok", Requires: "kola-no-such-program"}, "not installed: kola-no-such-program\n"},
+		{Diagnostic{Command: "echo ok", Requires: "kola no; such"}, "not installed: kola no; such\n"},
+	} {
+		script := tt.diag.script()
+		if tt.diag.Requires == "" && script != tt.diag.Command {
+			t.Errorf("%+v: got script %q", tt.diag, script)
+		}
+		output, err := exec.Command("sh", "-c", script).CombinedOutput()
+		if err != nil {
+			t.Errorf("%+v: %v: %s", tt.diag, err, output)
+		} else if string(output) != tt.output {
+			t.Errorf("%+v: got %q, expected %q", tt.diag, output, tt.output)
+		}
+	}
+}
+
+// execMachine runs the commands it is given locally.
+type execMachine struct {
+	platform.Machine
+	req platform.ExecRequest
+}
+
+func (m *execMachine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	m.req = req
+	cmd := exec.CommandContext(ctx, req.Argv[0], req.Argv[1:]...)
+	cmd.Stdout = req.Stdout
+	cmd.Stderr = req.Stderr
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return &platform.ExecResult{ExitCode: exit.ExitCode()}, nil
+	}
+	return &platform.ExecResult{}, err
+}
+
+func TestRunDiagnostic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.txt")
+
+	m := &execMachine{}
+	if err := runDiagnostic(m, &Diagnostic{ID: "test", Command: "echo out; echo err >&2; exit 3"}, path); err != nil {
+		t.Fatal(err)
+	}
+	if !m.req.Sudo || m.req.Timeout != diagnosticTimeout {
+		t.Errorf("got request %+v", m.req)
+	}
+	output, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# echo out; echo err >&2; exit 3\nout\nerr\n# exited with status 3 after 0s\n"
+	if !bytes.Equal(output, []byte(expected)) {
+		t.Errorf("got %q, expected %q", output, expected)
+	}
+}
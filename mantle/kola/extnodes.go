@@ -0,0 +1,174 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/platform"
+)
+
+const (
+	// kolaExtNodeEnv is written by Ignition on each node of an external
+	// test with its index, role and the number of nodes
+	kolaExtNodeEnv = "/etc/kola-runext-node.env"
+
+	// kolaExtPeersEnv is written by the harness before starting the test
+	// unit with the addresses of the nodes. It is persistent, as the test
+	// unit may run again after rebooting its node.
+	kolaExtPeersEnv = "/etc/kola-runext-peers.env"
+)
+
+// nodeRoles returns the role of each node of an external test. Nodes of
+// tests which don't declare roles have an empty role.
+func (meta *externalTestMeta) nodeRoles() ([]string, error) {
+	if len(meta.Roles) == 0 {
+		n := meta.Nodes
+		if n < 1 {
+			n = 1
+		}
+		return make([]string, n), nil
+	}
+	if meta.Nodes != 0 && meta.Nodes != len(meta.Roles) {
+		return nil, fmt.Errorf("nodes is %d but %d roles are given", meta.Nodes, len(meta.Roles))
+	}
+	return meta.Roles, nil
+}
+
+// nodeEnvContents returns the contents of kolaExtNodeEnv for a node.
+func nodeEnvContents(index int, role string, count int) string {
+	return fmt.Sprintf("KOLA_NODE_INDEX=%d\nKOLA_NODE_ROLE=%s\nKOLA_NODE_COUNT=%d\n", index, role, count)
+}
+
+// peersEnvContents returns the contents of kolaExtPeersEnv for node self:
+// the addresses of all nodes in index order, of the other nodes, and of
+// the nodes of each role.
+func peersEnvContents(ips, roles []string, self int) string {
+	var peers []string
+	byRole := map[string][]string{}
+	var roleOrder []string
+	for i, ip := range ips {
+		if i != self {
+			peers = append(peers, ip)
+		}
+		if roles[i] == "" {
+			continue
+		}
+		if _, ok := byRole[roles[i]]; !ok {
+			roleOrder = append(roleOrder, roles[i])
+		}
+		byRole[roles[i]] = append(byRole[roles[i]], ip)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "KOLA_NODE_IPS=\"%s\"\n", strings.Join(ips, " "))
+	fmt.Fprintf(&b, "KOLA_PEER_IPS=\"%s\"\n", strings.Join(peers, " "))
+	for _, role := range roleOrder {
+		fmt.Fprintf(&b, "KOLA_ROLE_%s_IPS=\"%s\"\n", envName(role), strings.Join(byRole[role], " "))
+	}
+	return b.String()
+}
+
+// envName turns a role name into an environment variable name component.
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, s)
+}
+
+// runExternalTestNodes tells each machine of an external test about its
+// peers, runs the test unit on all of them concurrently and fails the
+// test if it failed on any of them.
+func runExternalTestNodes(c cluster.TestCluster) {
+	machines := c.Machines()
+	nodes := make([]platform.Machine, len(machines))
+	roles := make([]string, len(machines))
+	ips := make([]string, len(machines))
+	for _, mach := range machines {
+		out := c.MustSSH(mach, "cat "+kolaExtNodeEnv)
+		env := map[string]string{}
+		for _, line := range strings.Split(string(out), "\n") {
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+				env[kv[0]] = kv[1]
+			}
+		}
+		i, err := strconv.Atoi(env["KOLA_NODE_INDEX"])
+		if err != nil || i < 0 || i >= len(nodes) || nodes[i] != nil {
+			c.Fatalf("invalid node index on %s: %q", mach.ID(), out)
+		}
+		nodes[i] = mach
+		roles[i] = env["KOLA_NODE_ROLE"]
+		ips[i] = mach.PrivateIP()
+	}
+
+	for i, mach := range nodes {
+		contents := peersEnvContents(ips, roles, i)
+		if err := platform.InstallFile(strings.NewReader(contents), mach, kolaExtPeersEnv); err != nil {
+			c.Fatal(errors.Wrapf(err, "writing %s on %s", kolaExtPeersEnv, mach.ID()))
+		}
+	}
+
+	plog.Debugf("Running kolet on %d nodes", len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, mach := range nodes {
+		wg.Add(1)
+		go func(i int, mach platform.Machine) {
+			defer wg.Done()
+			errs[i] = runExternalTest(c, mach)
+		}(i, mach)
+	}
+	wg.Wait()
+
+	failed := false
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed = true
+		mach := nodes[i]
+		out, stderr, suberr := mach.SSH(fmt.Sprintf("sudo systemctl status --lines=40 %s", shellquote.Join(KoletExtTestUnit)))
+		if len(out) > 0 {
+			fmt.Printf("systemctl status %s:\n%s\n", KoletExtTestUnit, string(out))
+		} else {
+			fmt.Printf("Fetching status failed: %v\n", suberr)
+		}
+		if Options.SSHOnTestFailure {
+			plog.Errorf("dropping to shell: kolet failed: %v: %s", err, stderr)
+			platform.Manhole(mach)
+		}
+		err = errors.Wrapf(err, "kolet failed: %s", stderr)
+		if len(nodes) > 1 {
+			err = errors.Wrapf(err, "node %d (%s, role %q)", i, mach.ID(), roles[i])
+		}
+		c.Error(err.Error())
+	}
+	if failed {
+		c.FailNow()
+	}
+}
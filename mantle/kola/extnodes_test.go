@@ -0,0 +1,75 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeRoles(t *testing.T) {
+	tests := []struct {
+		meta  externalTestMeta
+		roles []string
+	}{
+		{externalTestMeta{}, []string{""}},
+		{externalTestMeta{Nodes: 3}, []string{"", "", ""}},
+		{externalTestMeta{Roles: []string{"server", "client"}}, []string{"server", "client"}},
+		{externalTestMeta{Nodes: 2, Roles: []string{"server", "client"}}, []string{"server", "client"}},
+	}
+	for _, tt := range tests {
+		roles, err := tt.meta.nodeRoles()
+		if err != nil {
+			t.Errorf("%+v: %v", tt.meta, err)
+		} else if !reflect.DeepEqual(roles, tt.roles) {
+			t.Errorf("%+v: got roles %q", tt.meta, roles)
+		}
+	}
+
+	meta := externalTestMeta{Nodes: 3, Roles: []string{"server", "client"}}
+	if _, err := meta.nodeRoles(); err == nil {
+		t.Errorf("%+v: no error", meta)
+	}
+}
+
+func TestPeersEnvContents(t *testing.T) {
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	got := peersEnvContents(ips, []string{"", "", ""}, 1)
+	expected := `KOLA_NODE_IPS="10.0.0.1 10.0.0.2 10.0.0.3"
+KOLA_PEER_IPS="10.0.0.1 10.0.0.3"
+`
+	if got != expected {
+		t.Errorf("without roles: got\n%s\nexpected\n%s", got, expected)
+	}
+
+	got = peersEnvContents(ips, []string{"etcd-server", "client", "etcd-server"}, 0)
+	expected = `KOLA_NODE_IPS="10.0.0.1 10.0.0.2 10.0.0.3"
+KOLA_PEER_IPS="10.0.0.2 10.0.0.3"
+KOLA_ROLE_ETCD_SERVER_IPS="10.0.0.1 10.0.0.3"
+KOLA_ROLE_CLIENT_IPS="10.0.0.2"
+`
+	if got != expected {
+		t.Errorf("with roles: got\n%s\nexpected\n%s", got, expected)
+	}
+
+	got = peersEnvContents(ips[:1], []string{""}, 0)
+	expected = `KOLA_NODE_IPS="10.0.0.1"
+KOLA_PEER_IPS=""
+`
+	if got != expected {
+		t.Errorf("single node: got\n%s\nexpected\n%s", got, expected)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fixtures serves local stand-ins for the remote resources that
+// Ignition tests fetch: plain HTTP, HTTPS signed by a generated CA, an
+// S3-compatible endpoint with versioning and authentication, and TFTP.
+// QEMU machines reach them through usermode networking, so the tests need
+// no Internet access.
+package fixtures
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+const (
+	// GuestIP is the address guests reach the HTTP, HTTPS and S3 fixtures
+	// at, on ports 80, 443 and 9000 respectively.
+	GuestIP = "10.0.2.100"
+	// TFTPGuestIP is the address guests reach the TFTP fixture at.
+	TFTPGuestIP = "10.0.2.2"
+
+	// CAVar is replaced in test configs by a data URL of the CA
+	// certificate, for use in Ignition's tls.certificateAuthorities.
+	CAVar = "$FIXTURES_CA"
+)
+
+var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "kola/fixtures")
+
+// Fixtures is a running set of fixture servers for one cluster.
+type Fixtures struct {
+	// CA is the PEM certificate of the CA which signed the HTTPS and S3
+	// certificate.
+	CA []byte
+	// AccessKeyID and SecretAccessKey are the credentials accepted by the
+	// S3 endpoint.
+	AccessKeyID     string
+	SecretAccessKey string
+	// TFTPDir is the directory served over TFTP; it holds the public
+	// objects of the bucket.
+	TFTPDir string
+
+	httpAddr  string
+	httpsAddr string
+	s3Addr    string
+	servers   []*http.Server
+}
+
+// Start starts fixture servers on the loopback interface, keeping their
+// state in dir, which must exist.
+func Start(dir string) (*Fixtures, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	f := &Fixtures{
+		AccessKeyID:     "KOLAFIXTURES",
+		SecretAccessKey: hex.EncodeToString(secret),
+		TFTPDir:         filepath.Join(dir, "tftp"),
+	}
+	if err := writeTFTPDir(f.TFTPDir); err != nil {
+		return nil, errors.Wrapf(err, "populating TFTP directory")
+	}
+	cert, err := f.generateCerts()
+	if err != nil {
+		return nil, errors.Wrapf(err, "generating certificates")
+	}
+
+	bucket := newBucket()
+	s3 := &s3Handler{
+		bucket:          bucket,
+		accessKeyID:     f.AccessKeyID,
+		secretAccessKey: f.SecretAccessKey,
+	}
+	// The HTTP and HTTPS servers serve the bucket anonymously like its
+	// virtual-hosted endpoint, https://rh-kola-fixtures.s3.amazonaws.com
+	anonymous := &s3Handler{
+		bucket:      bucket,
+		virtualHost: true,
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if f.httpAddr, err = f.serve(anonymous, nil); err != nil {
+		return nil, err
+	}
+	if f.httpsAddr, err = f.serve(anonymous, tlsConfig); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if f.s3Addr, err = f.serve(s3, nil); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Fixtures) serve(handler http.Handler, tlsConfig *tls.Config) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	srv := &http.Server{
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	f.servers = append(f.servers, srv)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			err = srv.ServeTLS(l, "", "")
+		} else {
+			err = srv.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			plog.Errorf("Fixture server on %s failed: %v", l.Addr(), err)
+		}
+	}()
+	return l.Addr().String(), nil
+}
+
+// Close stops the servers.
+func (f *Fixtures) Close() {
+	for _, srv := range f.servers {
+		srv.Close()
+	}
+	f.servers = nil
+}
+
+// S3Endpoint returns the host-side URL of the S3 endpoint, for path-style
+// requests.
+func (f *Fixtures) S3Endpoint() string {
+	return "http://" + f.s3Addr
+}
+
+// GuestForwardPorts returns the forwards making the fixtures reachable at
+// GuestIP.
+func (f *Fixtures) GuestForwardPorts() ([]platform.GuestForwardPort, error) {
+	var fwds []platform.GuestForwardPort
+	for _, svc := range []struct {
+		port int
+		addr string
+	}{
+		{80, f.httpAddr},
+		{443, f.httpsAddr},
+		{9000, f.s3Addr},
+	} {
+		fwd, err := platform.GuestRelay(GuestIP, svc.port, svc.addr)
+		if err != nil {
+			return nil, err
+		}
+		fwds = append(fwds, fwd)
+	}
+	return fwds, nil
+}
+
+// Subst replaces CAVar in userdata.
+func (f *Fixtures) Subst(userdata *conf.UserData) *conf.UserData {
+	if userdata == nil {
+		return nil
+	}
+	return userdata.Subst(CAVar, "data:,"+url.PathEscape(string(f.CA)))
+}
+
+// generateCerts creates the CA, stored in f.CA, and returns the server
+// certificate it signed for GuestIP and the loopback address.
+func (f *Fixtures) generateCerts() (tls.Certificate, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kola fixtures CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(7 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	f.CA = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: GuestIP},
+		NotBefore:    notBefore,
+		NotAfter:     caTemplate.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP(GuestIP), net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// writeTFTPDir writes the public objects of the bucket to dir.
+func writeTFTPDir(dir string) error {
+	for key, obj := range newBucket() {
+		if !obj.public {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, obj.latest().data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
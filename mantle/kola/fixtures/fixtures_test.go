@@ -0,0 +1,199 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func startFixtures(t *testing.T) (*Fixtures, func()) {
+	dir, err := ioutil.TempDir("", "kola-fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Start(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return f, func() {
+		f.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("fetching %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestAnonymous(t *testing.T) {
+	f, cleanup := startFixtures(t)
+	defer cleanup()
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(f.CA) {
+		t.Fatal("parsing CA")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"http://" + f.httpAddr + "/resources/anonymous", 200, "kola-anonymous"},
+		{"https://" + f.httpsAddr + "/resources/anonymous", 200, "kola-anonymous"},
+		{"https://" + f.httpsAddr + "/resources/versioned", 200, "updated"},
+		{"https://" + f.httpsAddr + "/resources/versioned?versionId=" + OriginalVersionID, 200, "original"},
+		{"https://" + f.httpsAddr + "/resources/authenticated", 403, ""},
+		{"https://" + f.httpsAddr + "/resources/missing", 404, ""},
+		{f.S3Endpoint() + "/" + Bucket + "/resources/anonymous", 200, "kola-anonymous"},
+		{f.S3Endpoint() + "/" + Bucket + "/resources/authenticated", 403, ""},
+	}
+	for _, tt := range tests {
+		status, body := get(t, client, tt.url)
+		if status != tt.status {
+			t.Errorf("%s: got status %d, expected %d", tt.url, status, tt.status)
+		}
+		if tt.body != "" && body != tt.body {
+			t.Errorf("%s: got %q, expected %q", tt.url, body, tt.body)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(f.TFTPDir, "resources", "anonymous"))
+	if err != nil || string(content) != "kola-anonymous" {
+		t.Errorf("TFTP directory: got %q, %v", content, err)
+	}
+}
+
+func TestS3Client(t *testing.T) {
+	f, cleanup := startFixtures(t)
+	defer cleanup()
+
+	newClient := func(secret string) *s3.S3 {
+		sess := session.Must(session.NewSession(&aws.Config{
+			Credentials:      credentials.NewStaticCredentials(f.AccessKeyID, secret, ""),
+			Endpoint:         aws.String(f.S3Endpoint()),
+			Region:           aws.String("us-east-1"),
+			S3ForcePathStyle: aws.Bool(true),
+		}))
+		return s3.New(sess)
+	}
+	client := newClient(f.SecretAccessKey)
+
+	out, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String("resources/authenticated"),
+	})
+	if err != nil {
+		t.Fatalf("fetching authenticated object: %v", err)
+	}
+	body, _ := ioutil.ReadAll(out.Body)
+	out.Body.Close()
+	if string(body) != "kola-authenticated" {
+		t.Errorf("authenticated object: got %q", body)
+	}
+
+	out, err = client.GetObject(&s3.GetObjectInput{
+		Bucket:    aws.String(Bucket),
+		Key:       aws.String("resources/versioned"),
+		VersionId: aws.String(OriginalVersionID),
+	})
+	if err != nil {
+		t.Fatalf("fetching original version: %v", err)
+	}
+	body, _ = ioutil.ReadAll(out.Body)
+	out.Body.Close()
+	if string(body) != "original" || aws.StringValue(out.VersionId) != OriginalVersionID {
+		t.Errorf("original version: got %q, version %q", body, aws.StringValue(out.VersionId))
+	}
+
+	versioning, err := client.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(Bucket),
+	})
+	if err != nil {
+		t.Fatalf("fetching bucket versioning: %v", err)
+	}
+	if aws.StringValue(versioning.Status) != "Enabled" {
+		t.Errorf("bucket versioning: got %q", aws.StringValue(versioning.Status))
+	}
+
+	_, err = newClient("wrong").GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String("resources/authenticated"),
+	})
+	if err == nil {
+		t.Errorf("fetching with a wrong secret succeeded")
+	}
+}
+
+func TestSignGuestRequest(t *testing.T) {
+	f, cleanup := startFixtures(t)
+	defer cleanup()
+
+	url, header, err := f.SignGuestRequest("resources/authenticated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "http://10.0.2.100:9000/rh-kola-fixtures/resources/authenticated" {
+		t.Errorf("got URL %s", url)
+	}
+	// Send it as the guest would, through the forward
+	fetch := func(header http.Header) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, f.S3Endpoint()+"/rh-kola-fixtures/resources/authenticated", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "10.0.2.100:9000"
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if status, body := fetch(header); status != http.StatusOK || body != "kola-authenticated" {
+		t.Errorf("signed request: got %d %q", status, body)
+	}
+
+	header.Set("X-Amz-Date", "20200101T000000Z")
+	if status, _ := fetch(header); status != http.StatusForbidden {
+		t.Errorf("tampered request: got %d", status)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixtures
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const (
+	// Bucket is the name of the bucket served by the S3 endpoint, after
+	// the one holding the remote fixtures.
+	Bucket = "rh-kola-fixtures"
+
+	// OriginalVersionID is the version of resources/versioned whose
+	// content is "original"; the latest version is "updated".
+	OriginalVersionID = "Ym98GTx0npVaJznSAd0I1eUjFoZMP8Zo"
+)
+
+// objectVersion is one version of an object, oldest first in object.
+type objectVersion struct {
+	id   string
+	data []byte
+}
+
+type object struct {
+	public   bool
+	versions []objectVersion
+}
+
+func (o *object) latest() objectVersion {
+	return o.versions[len(o.versions)-1]
+}
+
+func (o *object) version(id string) (objectVersion, bool) {
+	for _, v := range o.versions {
+		if v.id == id {
+			return v, true
+		}
+	}
+	return objectVersion{}, false
+}
+
+func obj(public bool, data string) *object {
+	return &object{
+		public:   public,
+		versions: []objectVersion{{id: "null", data: []byte(data)}},
+	}
+}
+
+// newBucket returns the objects of Bucket, mirroring the remote fixtures.
+func newBucket() map[string]*object {
+	return map[string]*object{
+		"resources/anonymous":     obj(true, "kola-anonymous"),
+		"resources/authenticated": obj(false, "kola-authenticated"),
+		"resources/versioned": {
+			public: true,
+			versions: []objectVersion{
+				{id: OriginalVersionID, data: []byte("original")},
+				{id: "kolaUpdatedVersion", data: []byte("updated")},
+			},
+		},
+		"resources/anonymous-var.ign": obj(true, `{
+  "ignition": { "version": "2.1.0" },
+  "storage": {
+    "files": [{
+      "filesystem": "root",
+      "path": "/var/resource/config",
+      "contents": { "source": "data:,kola-config" },
+      "mode": 420
+    }]
+  }
+}`),
+		"resources/anonymous-var-v3.ign": obj(true, `{
+  "ignition": { "version": "3.0.0" },
+  "storage": {
+    "files": [{
+      "path": "/var/resource/config",
+      "contents": { "source": "data:,kola-config" },
+      "mode": 420
+    }]
+  }
+}`),
+	}
+}
+
+// s3Handler serves GET and HEAD requests for the objects of Bucket. With
+// virtualHost the path is the key, as if the bucket were in the host
+// name; otherwise requests are path-style. Private objects are served to
+// requests signed with AWS Signature Version 4 using the configured
+// credentials, which are unset for anonymous-only handlers.
+type s3Handler struct {
+	bucket          map[string]*object
+	virtualHost     bool
+	accessKeyID     string
+	secretAccessKey string
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string
+}
+
+func (h *s3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "only GET and HEAD are supported")
+		return
+	}
+
+	authenticated := false
+	if r.Header.Get("Authorization") != "" {
+		if h.accessKeyID == "" || !h.verifySignature(r) {
+			h.error(w, r, http.StatusForbidden, "SignatureDoesNotMatch", "the request signature does not match")
+			return
+		}
+		authenticated = true
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if !h.virtualHost {
+		var bucket string
+		bucket, path = splitBucket(path)
+		if bucket != Bucket {
+			h.error(w, r, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+			return
+		}
+		if _, ok := r.URL.Query()["versioning"]; ok && path == "" {
+			h.xml(w, r, http.StatusOK, versioningConfiguration{Status: "Enabled"})
+			return
+		}
+	}
+
+	o, ok := h.bucket[path]
+	if !ok {
+		h.error(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	if !o.public && !authenticated {
+		h.error(w, r, http.StatusForbidden, "AccessDenied", "access denied")
+		return
+	}
+	v := o.latest()
+	if id := r.URL.Query().Get("versionId"); id != "" {
+		if v, ok = o.version(id); !ok {
+			h.error(w, r, http.StatusNotFound, "NoSuchVersion", "the specified version does not exist")
+			return
+		}
+	}
+
+	sum := md5.Sum(v.data)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:])))
+	w.Header().Set("x-amz-version-id", v.id)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(v.data))
+}
+
+func splitBucket(path string) (string, string) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func (h *s3Handler) error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	h.xml(w, r, status, s3Error{Code: code, Message: message})
+}
+
+func (h *s3Handler) xml(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v) // Ignore errors
+}
+
+// SignGuestRequest returns the URL at which guests fetch the object key of
+// Bucket from the S3 endpoint, and the headers signing a GET request for it
+// with AWS Signature Version 4, for guests which can't sign requests
+// themselves.
+func (f *Fixtures) SignGuestRequest(key string) (string, http.Header, error) {
+	url := fmt.Sprintf("http://%s:9000/%s/%s", GuestIP, Bucket, key)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	signer := v4.NewSigner(credentials.NewStaticCredentials(f.AccessKeyID, f.SecretAccessKey, ""))
+	signer.DisableURIPathEscaping = true
+	if _, err := signer.Sign(req, nil, "s3", "us-east-1", time.Now()); err != nil {
+		return "", nil, err
+	}
+	return url, req.Header, nil
+}
+
+// verifySignature checks the AWS Signature Version 4 of r by signing a
+// copy of it, with the same signed headers and time, and comparing the
+// results.
+func (h *s3Handler) verifySignature(r *http.Request) bool {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	// Credential is <key>/<date>/<region>/<service>/aws4_request
+	scope := strings.Split(fields["Credential"], "/")
+	if len(scope) != 5 || scope[0] != h.accessKeyID {
+		return false
+	}
+	signTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return false
+	}
+	req.Host = r.Host
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		if name != "host" {
+			req.Header[http.CanonicalHeaderKey(name)] = r.Header[http.CanonicalHeaderKey(name)]
+		}
+	}
+	signer := v4.NewSigner(credentials.NewStaticCredentials(h.accessKeyID, h.secretAccessKey, ""))
+	signer.DisableURIPathEscaping = scope[3] == "s3"
+	if _, err := signer.Sign(req, nil, scope[3], scope[2], signTime); err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(req.Header.Get("Authorization")), []byte(r.Header.Get("Authorization")))
+}
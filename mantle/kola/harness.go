@@ -21,9 +21,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
@@ -35,7 +33,10 @@ import (
 	"github.com/coreos/mantle/harness"
 	"github.com/coreos/mantle/harness/reporters"
 	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/fixtures"
+	"github.com/coreos/mantle/kola/history"
 	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/kola/selector"
 	"github.com/coreos/mantle/network"
 	"github.com/coreos/mantle/platform"
 	awsapi "github.com/coreos/mantle/platform/api/aws"
@@ -91,91 +92,40 @@ var (
 	CosaBuild *sdk.LocalBuild // this is a parsed cosa build
 
 	TestParallelism int    //glue var to set test parallelism from main
+	HostMemory      int    // host memory budget in MiB for QEMU tests, 0 means unlimited
+	HostCPUs        int    // host vCPU budget for QEMU tests, 0 means unlimited
+	HostDisk        string // host disk budget for QEMU tests (e.g. "200G"), empty means unlimited
 	TAPFile         string // if not "", write TAP results here
 	NoNet           bool   // Disable tests requiring Internet
 
+	RerunOf     string // if not "", the report.json whose failed tests are being rerun
+	HistoryFile string // if not "", append test results to this history file
+
+	MetricsBaseline  string  // if not "", compare recorded metrics to this report.json
+	MetricsTolerance float64 // fail tests whose metrics exceed the baseline by this percentage, 0 means report only
+
 	DenylistedTests []string // tests which are on the denylist
+	DenylistFile    string   // if not "", a kola-denylist.yaml to add to DenylistedTests
 	Tags            []string // tags to be ran
+	Selection       string   // if not "", a selector expression tests must also match
+	NoMachinePool   bool     // boot fresh machines for NonDestructive tests too
 
-	consoleChecks = []struct {
-		desc     string
-		match    *regexp.Regexp
-		skipFlag *register.Flag
-	}{
-		{
-			desc:     "emergency shell",
-			match:    regexp.MustCompile("Press Enter for emergency shell|Starting Emergency Shell|You are in emergency mode"),
-			skipFlag: &[]register.Flag{register.NoEmergencyShellCheck}[0],
-		},
-		{
-			desc:  "dracut fatal",
-			match: regexp.MustCompile("dracut: Refusing to continue"),
-		},
-		{
-			desc:  "kernel panic",
-			match: regexp.MustCompile("Kernel panic - not syncing: (.*)"),
-		},
-		{
-			desc:  "kernel oops",
-			match: regexp.MustCompile("Oops:"),
-		},
-		{
-			desc:  "kernel warning",
-			match: regexp.MustCompile(`WARNING: CPU: \d+ PID: \d+ at (.+)`),
-		},
-		{
-			desc:  "failure of disk under I/O",
-			match: regexp.MustCompile("rejecting I/O to offline device"),
-		},
-		{
-			// Failure to set up Packet networking in initramfs,
-			// perhaps due to unresponsive metadata server
-			desc:  "coreos-metadata failure to set up initramfs network",
-			match: regexp.MustCompile("Failed to start CoreOS Static Network Agent"),
-		},
-		{
-			// https://github.com/coreos/bugs/issues/2065
-			desc:  "excessive bonding link status messages",
-			match: regexp.MustCompile("(?s:link status up for interface [^,]+, enabling it in [0-9]+ ms.*?){10}"),
-		},
-		{
-			// https://github.com/coreos/bugs/issues/2180
-			desc:  "ext4 delayed allocation failure",
-			match: regexp.MustCompile(`EXT4-fs \([^)]+\): Delayed block allocation failed for inode \d+ at logical offset \d+ with max blocks \d+ with (error \d+)`),
-		},
-		{
-			// https://github.com/coreos/bugs/issues/2284
-			desc:  "GRUB memory corruption",
-			match: regexp.MustCompile("((alloc|free) magic) (is )?broken"),
-		},
-		{
-			// https://github.com/coreos/bugs/issues/2435
-			desc:  "Ignition fetch cancellation race",
-			match: regexp.MustCompile("ignition\\[[0-9]+\\]: failed to fetch config: context canceled"),
-		},
-		{
-			// https://github.com/coreos/bugs/issues/2526
-			desc:  "initrd-cleanup.service terminated",
-			match: regexp.MustCompile("initrd-cleanup\\.service: Main process exited, code=killed, status=15/TERM"),
-		},
-		{
-			// kernel 4.14.11
-			desc:  "bad page table",
-			match: regexp.MustCompile("mm/pgtable-generic.c:\\d+: bad (p.d|pte)"),
-		},
-		{
-			desc:  "Go panic",
-			match: regexp.MustCompile("panic: (.*)"),
-		},
-		{
-			desc:  "segfault",
-			match: regexp.MustCompile("SIGSEGV|=11/SEGV"),
-		},
-		{
-			desc:  "core dump",
-			match: regexp.MustCompile("[Cc]ore dump"),
-		},
-	}
+	ChangedSince    string   // if not "", only run tests impacted by the packages changed since this build
+	ChangedPackages []string // if not nil, only run tests covering one of these packages or declaring none
+
+	UpgradePath      []UpgradeNode // releases to upgrade through before the build under test, oldest first
+	UpgradeOstreeURL string        // OSTree repo serving the releases of UpgradePath
+
+	ConsoleRulesFile string // if not "", a kola-console-rules.yaml to add to the console checks
+
+	CollectPaths []string // guest paths to copy to the output directory after each test
+
+	AVCAllowlistFile string // if not "", a kola-avc-allowlist.yaml of allowed SELinux denials
+
+	AllowFailedUnits bool // don't fail tests leaving failed systemd units or crashes behind
+
+	DiagnosticsFile string // if not "", a kola-diagnostics.yaml to add to the diagnostics
+	NoDiagnostics   bool   // don't collect diagnostics from the machines of failed tests
 )
 
 const (
@@ -273,138 +223,198 @@ func testRequiresInternet(test *register.Test) bool {
 	return false
 }
 
-func filterTests(tests map[string]*register.Test, patterns []string, pltfrm string, version semver.Version) (map[string]*register.Test, error) {
+// filterTests returns the tests selected by patterns which can run on
+// pltfrm and version. Tests which would be selected but are denylisted are
+// returned separately, mapped to the reason they are skipped.
+func filterTests(tests map[string]*register.Test, patterns []string, pltfrm string, version semver.Version) (map[string]*register.Test, map[string]string, error) {
 	r := make(map[string]*register.Test)
+	denied := make(map[string]string)
 
-	checkPlatforms := []string{pltfrm}
-
-	// qemu-unpriv has the same restrictions as QEMU but might also want additional restrictions due to the lack of a Local cluster
-	if pltfrm == "qemu-unpriv" {
-		checkPlatforms = append(checkPlatforms, "qemu")
+	selection, err := parseSelection()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	noPattern := hasString("*", patterns)
 	for name, t := range tests {
-		if NoNet && testRequiresInternet(t) {
-			plog.Debugf("Skipping test that requires network: %s", t.Name)
+		denyPattern, err := denylistPattern(t)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reason, err := filterReason(t, patterns, pltfrm, version, selection)
+		if err != nil {
+			return nil, nil, err
+		}
+		if reason != "" {
+			plog.Debugf("Skipping test %s: %s", t.Name, reason)
 			continue
 		}
 
-		var denylisted bool
-		// Drop anything which is denylisted directly or by pattern
-		for _, bl := range DenylistedTests {
-			match, err := filepath.Match(bl, t.Name)
-			if err != nil {
-				return nil, err
-			}
-			// If it matched the pattern this test is denylisted
-			if match {
-				denylisted = true
-				break
+		// Check native tests for arch-specific and distro-specfic exclusion
+		for k, NativeFuncWrap := range t.NativeFuncs {
+			_, excluded := isAllowed(Options.Distribution, nil, NativeFuncWrap.Exclusions)
+			if excluded {
+				delete(t.NativeFuncs, k)
+				continue
 			}
-
-			// Check if any native tests are denylisted. To exclude native tests, specify the high level
-			// test and a "/" and then the glob pattern.
-			// - basic/TestNetworkScripts: excludes only TestNetworkScripts
-			// - basic/* - excludes all
-			// - If no pattern is specified after / , excludes none
-			nativedenylistindex := strings.Index(bl, "/")
-			if nativedenylistindex > -1 {
-				// Check native tests for arch specific exclusion
-				for nativetestname := range t.NativeFuncs {
-					match, err := filepath.Match(bl[nativedenylistindex+1:], nativetestname)
-					if err != nil {
-						return nil, err
-					}
-					if match {
-						delete(t.NativeFuncs, nativetestname)
-					}
-				}
+			_, excluded = isAllowed(system.RpmArch(), nil, NativeFuncWrap.Exclusions)
+			if excluded {
+				delete(t.NativeFuncs, k)
 			}
 		}
-		// If the test is denylisted, skip it and continue to the next test
-		if denylisted {
+
+		// If the test is denylisted, report it as skipped instead of running it
+		if denyPattern != "" {
 			plog.Debugf("Skipping denylisted test %s", t.Name)
+			denied[name] = denylistReason(denyPattern)
 			continue
 		}
 
-		match, err := matchesPatterns(t.Name, patterns)
-		if err != nil {
-			return nil, err
-		}
+		r[name] = t
+	}
 
-		tagMatch := false
-		for _, tag := range Tags {
-			tagMatch = hasString(tag, t.Tags)
-			if tagMatch {
-				break
-			}
-		}
+	return r, denied, nil
+}
 
-		if (!noPattern && !match && !tagMatch) || (!tagMatch && noPattern && len(Tags) > 0) {
-			continue
+// denylistPattern returns the DenylistedTests pattern matching t, if any.
+// Patterns of the form "test/native" instead drop the matching native
+// tests of t.
+func denylistPattern(t *register.Test) (string, error) {
+	for _, bl := range DenylistedTests {
+		match, err := filepath.Match(bl, t.Name)
+		if err != nil {
+			return "", err
 		}
-
-		// Check the test's min and end versions when running more than one test
-		if !hasString(t.Name, patterns) && versionOutsideRange(version, t.MinVersion, t.EndVersion) {
-			continue
+		// If it matched the pattern this test is denylisted
+		if match {
+			return bl, nil
 		}
 
-		isAllowed := func(item string, include, exclude []string) (bool, bool) {
-			allowed, excluded := true, false
-			for _, i := range include {
-				if i == item {
-					allowed = true
-					break
-				} else {
-					allowed = false
+		// Check if any native tests are denylisted. To exclude native tests, specify the high level
+		// test and a "/" and then the glob pattern.
+		// - basic/TestNetworkScripts: excludes only TestNetworkScripts
+		// - basic/* - excludes all
+		// - If no pattern is specified after / , excludes none
+		nativedenylistindex := strings.Index(bl, "/")
+		if nativedenylistindex > -1 {
+			// Check native tests for arch specific exclusion
+			for nativetestname := range t.NativeFuncs {
+				match, err := filepath.Match(bl[nativedenylistindex+1:], nativetestname)
+				if err != nil {
+					return "", err
 				}
-			}
-			for _, i := range exclude {
-				if i == item {
-					allowed = false
-					excluded = true
+				if match {
+					delete(t.NativeFuncs, nativetestname)
 				}
 			}
-			return allowed, excluded
 		}
+	}
+	return "", nil
+}
 
-		isExcluded := false
-		allowed := false
-		for _, platform := range checkPlatforms {
-			allowedPlatform, excluded := isAllowed(platform, t.Platforms, t.ExcludePlatforms)
-			if excluded {
-				isExcluded = true
-				break
-			}
-			allowedArchitecture, _ := isAllowed(system.RpmArch(), t.Architectures, t.ExcludeArchitectures)
-			allowed = allowed || (allowedPlatform && allowedArchitecture)
+// isAllowed returns whether item is allowed by the include list, which
+// allows everything if empty, and whether it is excluded.
+func isAllowed(item string, include, exclude []string) (bool, bool) {
+	allowed, excluded := true, false
+	for _, i := range include {
+		if i == item {
+			allowed = true
+			break
+		} else {
+			allowed = false
 		}
-		if isExcluded || !allowed {
-			continue
+	}
+	for _, i := range exclude {
+		if i == item {
+			allowed = false
+			excluded = true
 		}
+	}
+	return allowed, excluded
+}
 
-		if allowed, excluded := isAllowed(Options.Distribution, t.Distros, t.ExcludeDistros); !allowed || excluded {
-			continue
+// platformAllowed returns whether t can run on pltfrm, and whether pltfrm
+// is explicitly excluded.
+func platformAllowed(t *register.Test, pltfrm string) (bool, bool) {
+	checkPlatforms := []string{pltfrm}
+
+	// qemu-unpriv has the same restrictions as QEMU but might also want additional restrictions due to the lack of a Local cluster
+	if pltfrm == "qemu-unpriv" {
+		checkPlatforms = append(checkPlatforms, "qemu")
+	}
+
+	allowed := false
+	for _, platform := range checkPlatforms {
+		allowedPlatform, excluded := isAllowed(platform, t.Platforms, t.ExcludePlatforms)
+		if excluded {
+			return false, true
 		}
+		allowed = allowed || allowedPlatform
+	}
+	return allowed, false
+}
 
-		// Check native tests for arch-specific and distro-specfic exclusion
-		for k, NativeFuncWrap := range t.NativeFuncs {
-			_, excluded := isAllowed(Options.Distribution, nil, NativeFuncWrap.Exclusions)
-			if excluded {
-				delete(t.NativeFuncs, k)
-				continue
-			}
-			_, excluded = isAllowed(system.RpmArch(), nil, NativeFuncWrap.Exclusions)
-			if excluded {
-				delete(t.NativeFuncs, k)
-			}
+// filterReason returns why t is not selected by patterns, Tags and
+// selection to run on pltfrm and version, or "" if it is. Denylisting is
+// not considered.
+func filterReason(t *register.Test, patterns []string, pltfrm string, version semver.Version, selection selector.Expr) (string, error) {
+	if NoNet && testRequiresInternet(t) {
+		return "requires Internet access", nil
+	}
+
+	match, err := matchesPatterns(t.Name, patterns)
+	if err != nil {
+		return "", err
+	}
+
+	tagMatch := false
+	for _, tag := range Tags {
+		tagMatch = hasString(tag, t.Tags)
+		if tagMatch {
+			break
 		}
+	}
 
-		r[name] = t
+	noPattern := hasString("*", patterns)
+	if (!noPattern && !match && !tagMatch) || (!tagMatch && noPattern && len(Tags) > 0) {
+		if len(Tags) > 0 {
+			return "does not match the patterns or tags", nil
+		}
+		return "does not match the patterns", nil
+	}
+
+	if selection != nil && !selection.Match(selectionMatcher(t)) {
+		return fmt.Sprintf("does not match selection %q", Selection), nil
+	}
+
+	if !testImpacted(t) {
+		return "covers none of the changed packages", nil
+	}
+
+	// Check the test's min and end versions when running more than one test
+	if !hasString(t.Name, patterns) && versionOutsideRange(version, t.MinVersion, t.EndVersion) {
+		return fmt.Sprintf("version %s is outside of the test's version range", version), nil
+	}
+
+	if allowed, excluded := platformAllowed(t, pltfrm); excluded {
+		return fmt.Sprintf("platform %s is excluded", pltfrm), nil
+	} else if !allowed {
+		return fmt.Sprintf("platform %s is not in the test's platforms", pltfrm), nil
+	}
+
+	if allowed, excluded := isAllowed(system.RpmArch(), t.Architectures, t.ExcludeArchitectures); excluded {
+		return fmt.Sprintf("architecture %s is excluded", system.RpmArch()), nil
+	} else if !allowed {
+		return fmt.Sprintf("architecture %s is not in the test's architectures", system.RpmArch()), nil
+	}
+
+	if allowed, excluded := isAllowed(Options.Distribution, t.Distros, t.ExcludeDistros); excluded {
+		return fmt.Sprintf("distro %s is excluded", Options.Distribution), nil
+	} else if !allowed {
+		return fmt.Sprintf("distro %s is not in the test's distros", Options.Distribution), nil
 	}
 
-	return r, nil
+	return "", nil
 }
 
 // versionOutsideRange checks to see if version is outside [min, end). If end
@@ -440,7 +450,7 @@ func runProvidedTests(tests map[string]*register.Test, patterns []string, multip
 	// 1) none of the selected tests care about the version
 	// 2) glob is an exact match which means minVersion will be ignored
 	//    either way
-	tests, err := filterTests(tests, patterns, pltfrm, semver.Version{})
+	tests, denied, err := filterTests(tests, patterns, pltfrm, semver.Version{})
 	if err != nil {
 		plog.Fatal(err)
 	}
@@ -484,28 +494,57 @@ func runProvidedTests(tests map[string]*register.Test, patterns []string, multip
 		versionStr = version.String()
 
 		// one more filter pass now that we know real version
-		tests, err = filterTests(tests, patterns, pltfrm, *version)
+		tests, _, err = filterTests(tests, patterns, pltfrm, *version)
 		if err != nil {
 			plog.Fatal(err)
 		}
 	}
 
+	budget, err := newHostBudget(pltfrm)
+	if err != nil {
+		plog.Fatal(err)
+	}
+
+	baseline, err := loadMetricBaseline(MetricsBaseline)
+	if err != nil {
+		plog.Fatal(err)
+	}
+
+	pool := newMachinePool(flight, pltfrm, outputDir, budget)
+	defer pool.Destroy()
+
+	jsonReporter := reporters.NewJSONReporter("report.json", pltfrm, versionStr)
+	jsonReporter.RerunOf = RerunOf
 	opts := harness.Options{
 		OutputDir: outputDir,
 		Parallel:  TestParallelism,
 		Verbose:   true,
 		Reporters: reporters.Reporters{
-			reporters.NewJSONReporter("report.json", pltfrm, versionStr),
+			jsonReporter,
+			reporters.NewJUnitReporter("report.xml", pltfrm, versionStr),
 		},
 	}
+	if HistoryFile != "" {
+		build := Options.CosaBuildId
+		if build == "" {
+			build = versionStr
+		}
+		opts.Reporters = append(opts.Reporters, history.NewReporter(HistoryFile, pltfrm, system.RpmArch(), build))
+	}
 	var htests harness.Tests
 	for _, test := range tests {
 		test := test // for the closure
 		run := func(h *harness.H) {
-			runTest(h, test, pltfrm, flight)
+			runTest(h, test, pltfrm, flight, pool, budget, baseline)
 		}
 		htests.Add(test.Name, run)
 	}
+	for name, reason := range denied {
+		reason := reason // for the closure
+		htests.Add(name, func(h *harness.H) {
+			h.Skip(reason)
+		})
+	}
 
 	suite := harness.NewSuite(opts, htests)
 	err = suite.Run()
@@ -544,8 +583,13 @@ type externalTestMeta struct {
 	Platforms       string   `json:",platforms,omitempty"`
 	Distros         string   `json:",distros,omitempty"`
 	Tags            string   `json:",tags,omitempty"`
+	Packages        string   `json:",packages,omitempty"`
 	AdditionalDisks []string `json:",additionalDisks,omitempty"`
 	MinMemory       int      `json:",minMemory,omitempty"`
+	AllowedAVCs     []string `json:",allowedAVCs,omitempty"`
+	CollectPaths    []string `json:",collectPaths,omitempty"`
+	Nodes           int      `json:",nodes,omitempty"`
+	Roles           []string `json:",roles,omitempty"`
 }
 
 // metadataFromTestBinary extracts JSON-in-comment like:
@@ -639,12 +683,9 @@ func runExternalTest(c cluster.TestCluster, mach platform.Machine) error {
 	}
 }
 
-func registerExternalTest(testname, executable, dependencydir, ignition string, baseMeta externalTestMeta) error {
-	config, err := conf.Ignition(ignition).Render(IsIgnitionV2())
-	if err != nil {
-		return errors.Wrapf(err, "Parsing config.ign")
-	}
-
+// registerExternalTest registers an external test. ignition is the
+// config of nodes without a role-specific config in roleIgnition.
+func registerExternalTest(testname, executable, dependencydir, ignition string, roleIgnition map[string]string, baseMeta externalTestMeta) error {
 	targetMeta, err := metadataFromTestBinary(executable)
 	if err != nil {
 		return errors.Wrapf(err, "Parsing metadata from %s", executable)
@@ -654,17 +695,43 @@ func registerExternalTest(testname, executable, dependencydir, ignition string,
 		targetMeta = &metaCopy
 	}
 
+	roles, err := targetMeta.nodeRoles()
+	if err != nil {
+		return errors.Wrapf(err, "Parsing metadata for %s", testname)
+	}
+	for role := range roleIgnition {
+		if !hasString(role, roles) {
+			plog.Warningf("%s: config for role %q which no node has", testname, role)
+		}
+	}
+
 	remotepath := fmt.Sprintf("/usr/local/bin/kola-runext-%s", filepath.Base(executable))
 	// Note this isn't Type=oneshot because it's cleaner to support self-SIGTERM that way
 	unit := fmt.Sprintf(`[Unit]
 [Service]
 RemainAfterExit=yes
 EnvironmentFile=-/run/kola-runext-env
+EnvironmentFile=-%s
+EnvironmentFile=-%s
 Environment=KOLA_UNIT=%s
 Environment=%s=%s
 ExecStart=%s
-`, KoletExtTestUnit, kolaExtBinDataEnv, kolaExtBinDataDir, remotepath)
-	config.AddSystemdUnit(KoletExtTestUnit, unit, conf.NoState)
+`, kolaExtNodeEnv, kolaExtPeersEnv, KoletExtTestUnit, kolaExtBinDataEnv, kolaExtBinDataDir, remotepath)
+
+	var nodeConfigs []*conf.UserData
+	for i, role := range roles {
+		name, src := "config.ign", ignition
+		if roleSrc, ok := roleIgnition[role]; ok {
+			name, src = fmt.Sprintf("config-%s", role), roleSrc
+		}
+		config, err := conf.Ignition(src).Render(IsIgnitionV2())
+		if err != nil {
+			return errors.Wrapf(err, "Parsing %s", name)
+		}
+		config.AddSystemdUnit(KoletExtTestUnit, unit, conf.NoState)
+		config.AddFile(kolaExtNodeEnv, "root", nodeEnvContents(i, role, len(roles)), 0644)
+		nodeConfigs = append(nodeConfigs, conf.Ignition(config.String()))
+	}
 
 	// Architectures using 64k pages use slightly more memory, ask for more than requested
 	// to make sure that we don't run out of it. Currently only ppc64le uses 64k pages.
@@ -674,62 +741,56 @@ ExecStart=%s
 
 	t := &register.Test{
 		Name:          testname,
-		ClusterSize:   1, // Hardcoded for now
+		ClusterSize:   len(roles),
 		ExternalTest:  executable,
 		DependencyDir: dependencydir,
 		Tags:          []string{"external"},
 
 		AdditionalDisks: targetMeta.AdditionalDisks,
 		MinMemory:       targetMeta.MinMemory,
+		CollectPaths:    targetMeta.CollectPaths,
+		AllowedAVCs:     targetMeta.AllowedAVCs,
 
-		Run: func(c cluster.TestCluster) {
-			mach := c.Machines()[0]
-			plog.Debugf("Running kolet")
+		Run: runExternalTestNodes,
+	}
+	if len(nodeConfigs) == 1 {
+		t.UserDataV3 = nodeConfigs[0]
+	} else {
+		t.NodeUserData = nodeConfigs
+	}
 
-			err := runExternalTest(c, mach)
-			if err != nil {
-				out, stderr, suberr := mach.SSH(fmt.Sprintf("sudo systemctl status --lines=40 %s", shellquote.Join(KoletExtTestUnit)))
-				if len(out) > 0 {
-					fmt.Printf("systemctl status %s:\n%s\n", KoletExtTestUnit, string(out))
-				} else {
-					fmt.Printf("Fetching status failed: %v\n", suberr)
-				}
-				if Options.SSHOnTestFailure {
-					plog.Errorf("dropping to shell: kolet failed: %v: %s", err, stderr)
-					platform.Manhole(mach)
-				}
-				c.Fatalf(errors.Wrapf(err, "kolet failed: %s", stderr).Error())
-			}
-		},
+	setTestFilters(t, targetMeta.Architectures, targetMeta.Platforms, targetMeta.Distros)
+	t.Tags = append(t.Tags, strings.Fields(targetMeta.Tags)...)
+	t.Packages = strings.Fields(targetMeta.Packages)
 
-		UserDataV3: conf.Ignition(config.String()),
-	}
+	register.RegisterTest(t)
 
-	// To avoid doubling the duplication here with register.Test, we support
-	// a ! prefix (inspired by systemd unit syntax), like:
-	//
-	// architectures: !ppc64le s390x
-	// platforms: aws qemu
-	if strings.HasPrefix(targetMeta.Architectures, "!") {
-		t.ExcludeArchitectures = strings.Fields(targetMeta.Architectures[1:])
+	return nil
+}
+
+// setTestFilters sets the architecture, platform and distro filters of t
+// from whitespace-separated lists. To avoid doubling the duplication here
+// with register.Test, we support a ! prefix (inspired by systemd unit
+// syntax), like:
+//
+// architectures: !ppc64le s390x
+// platforms: aws qemu
+func setTestFilters(t *register.Test, architectures, platforms, distros string) {
+	if strings.HasPrefix(architectures, "!") {
+		t.ExcludeArchitectures = strings.Fields(architectures[1:])
 	} else {
-		t.Architectures = strings.Fields(targetMeta.Architectures)
+		t.Architectures = strings.Fields(architectures)
 	}
-	if strings.HasPrefix(targetMeta.Platforms, "!") {
-		t.ExcludePlatforms = strings.Fields(targetMeta.Platforms[1:])
+	if strings.HasPrefix(platforms, "!") {
+		t.ExcludePlatforms = strings.Fields(platforms[1:])
 	} else {
-		t.Platforms = strings.Fields(targetMeta.Platforms)
+		t.Platforms = strings.Fields(platforms)
 	}
-	if strings.HasPrefix(targetMeta.Distros, "!") {
-		t.ExcludeDistros = strings.Fields(targetMeta.Distros[1:])
+	if strings.HasPrefix(distros, "!") {
+		t.ExcludeDistros = strings.Fields(distros[1:])
 	} else {
-		t.Distros = strings.Fields(targetMeta.Distros)
+		t.Distros = strings.Fields(distros)
 	}
-	t.Tags = append(t.Tags, strings.Fields(targetMeta.Tags)...)
-
-	register.RegisterTest(t)
-
-	return nil
 }
 
 // testIsDenyListed returns true if the test was denied on the CLI. This is
@@ -745,11 +806,26 @@ func testIsDenyListed(testname string) (bool, error) {
 	return false, nil
 }
 
+// translateButaneFile translates the Butane config at path to Ignition,
+// embedding local files relative to its directory.
+func translateButaneFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ign, err := conf.TranslateButane(data, filepath.Dir(path), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "translating %s", path)
+	}
+	return ign, nil
+}
+
 // registerTestDir parses one test directory and registers it as a test
 func registerTestDir(dir, testprefix string, children []os.FileInfo) error {
 	var dependencydir string
 	var meta externalTestMeta
 	ignition := `{ "ignition": { "version": "3.0.0" } }`
+	roleIgnition := map[string]string{}
 	executables := []string{}
 	for _, c := range children {
 		fpath := filepath.Join(dir, c.Name())
@@ -763,11 +839,23 @@ func registerTestDir(dir, testprefix string, children []os.FileInfo) error {
 			}
 			ignition = string(v)
 		} else if isreg && c.Name() == "config.fcc" {
-			b, err := exec.Command("fcct", fpath).Output()
+			b, err := translateButaneFile(fpath)
 			if err != nil {
-				return errors.Wrapf(err, "failed to fcct %s", fpath)
+				return err
 			}
 			ignition = string(b)
+		} else if isreg && strings.HasPrefix(c.Name(), "config-") && filepath.Ext(c.Name()) == ".ign" {
+			v, err := ioutil.ReadFile(fpath)
+			if err != nil {
+				return errors.Wrapf(err, "reading %s", c.Name())
+			}
+			roleIgnition[strings.TrimSuffix(strings.TrimPrefix(c.Name(), "config-"), ".ign")] = string(v)
+		} else if isreg && strings.HasPrefix(c.Name(), "config-") && filepath.Ext(c.Name()) == ".fcc" {
+			b, err := translateButaneFile(fpath)
+			if err != nil {
+				return err
+			}
+			roleIgnition[strings.TrimSuffix(strings.TrimPrefix(c.Name(), "config-"), ".fcc")] = string(b)
 		} else if isreg && c.Name() == "kola.json" {
 			f, err := os.Open(fpath)
 			if err != nil {
@@ -816,16 +904,22 @@ func registerTestDir(dir, testprefix string, children []os.FileInfo) error {
 			testname = fmt.Sprintf("%s.%s", testname, filepath.Base(executable))
 		}
 
-		// don't even register the test if it's denied; this allows us to avoid
-		// erroring on Ignition config versions which we can't parse
+		// don't parse the test if it's denied; this allows us to avoid
+		// erroring on Ignition config versions which we can't parse. A
+		// placeholder is registered so that it is reported as skipped.
 		if denied, err := testIsDenyListed(testname); err != nil {
 			return err
 		} else if denied {
 			plog.Debugf("Skipping denylisted external test %s", testname)
+			register.RegisterTest(&register.Test{
+				Name:         testname,
+				ExternalTest: executable,
+				Tags:         []string{"external"},
+			})
 			continue
 		}
 
-		err := registerExternalTest(testname, executable, dependencydir, ignition, meta)
+		err := registerExternalTest(testname, executable, dependencydir, ignition, roleIgnition, meta)
 		if err != nil {
 			return err
 		}
@@ -850,6 +944,10 @@ func RegisterExternalTestsWithPrefix(dir, prefix string) error {
 		return err
 	}
 
+	if err := registerYAMLTests(testsdir, prefix); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -912,14 +1010,76 @@ func parseCLVersion(input string) (*semver.Version, error) {
 // runTest is a harness for running a single test.
 // outputDir is where various test logs and data will be written for
 // analysis after the test run. It should already exist.
-func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flight) {
+// If pool accepts the test, it runs on a pooled machine instead of a new
+// cluster. Otherwise, if budget is not nil, the test waits until its
+// machines fit in it.
+func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flight, pool *machinePool, budget *resourceBudget, baseline metricBaseline) {
 	h.Parallel()
 
+	if pool.accepts(t) {
+		userdata := t.UserDataV3
+		if IsIgnitionV2() && t.UserData != nil {
+			userdata = t.UserData
+		}
+		runPooledTest(h, t, pltfrm, pool, userdata, baseline)
+		return
+	}
+
+	if budget != nil {
+		r, err := testResources(t, budget.mainDisk)
+		if err != nil {
+			h.Fatalf("Estimating resources: %v", err)
+		}
+		if !r.fits(hostResources{}, budget.total) {
+			plog.Warningf("%s needs %s, more than the host budget; running it alone", t.Name, r)
+		}
+		// let other tests run while waiting
+		var release func()
+		h.Wait(func() {
+			release = budget.reserve(r)
+		})
+		defer release()
+	}
+
 	rconf := &platform.RuntimeConfig{
 		OutputDir:          h.OutputDir(),
 		NoSSHKeyInUserData: t.HasFlag(register.NoSSHKeyInUserData),
 		NoSSHKeyInMetadata: t.HasFlag(register.NoSSHKeyInMetadata),
 		InternetAccess:     testRequiresInternet(t),
+		AllowFailedUnits:   AllowFailedUnits || t.ExpectedFailedUnits != nil,
+	}
+	var fx *fixtures.Fixtures
+	if t.HasFlag(register.RequiresFixtures) {
+		if pltfrm != "qemu-unpriv" {
+			h.Skipf("Fixtures are not supported on %s", pltfrm)
+		}
+		var err error
+		fx, err = fixtures.Start(h.TempDir("fixtures-"))
+		if err != nil {
+			h.Fatalf("Starting fixtures: %v", err)
+		}
+		defer fx.Close()
+		rconf.GuestForwardPorts, err = fx.GuestForwardPorts()
+		if err != nil {
+			h.Fatalf("Forwarding fixtures: %v", err)
+		}
+		rconf.TFTPDir = fx.TFTPDir
+	}
+	if t.HasFlag(register.InjectsNetworkFaults) || len(t.NetworkFaults) > 0 {
+		if pltfrm != "qemu-unpriv" {
+			h.Skipf("Network faults are not supported on %s", pltfrm)
+		}
+		rconf.ShapeNetwork = true
+		rconf.NetworkFaults = t.NetworkFaults
+	}
+	if len(t.DiskFaults) > 0 && pltfrm != "qemu-unpriv" {
+		h.Skipf("Disk faults are not supported on %s", pltfrm)
+	}
+	if t.EmulatePlatform != "" && pltfrm == "qemu-unpriv" {
+		if fx != nil {
+			h.Fatalf("Fixtures and emulated platforms cannot be combined")
+		}
+		rconf.EmulatedPlatform = t.EmulatePlatform
 	}
 	c, err := flight.NewCluster(rconf)
 	if err != nil {
@@ -927,15 +1087,17 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 	}
 	defer func() {
 		c.Destroy()
+		for id := range c.ConsoleOutput() {
+			attachMachineArtifacts(h, filepath.Join(rconf.OutputDir, id), id)
+		}
 		for id, output := range c.ConsoleOutput() {
-			for _, badness := range CheckConsole([]byte(output), t) {
-				h.Errorf("Found %s on machine %s console", badness, id)
-			}
+			reportConsoleMatches(h, CheckConsole([]byte(output), t, pltfrm), id, "console")
 		}
 		for id, output := range c.JournalOutput() {
-			for _, badness := range CheckConsole([]byte(output), t) {
-				h.Errorf("Found %s on machine %s journal", badness, id)
-			}
+			reportConsoleMatches(h, CheckConsole([]byte(output), t, pltfrm), id, "journal")
+		}
+		for id := range c.ConsoleOutput() {
+			checkAVCDenials(h, t, pltfrm, id, filepath.Join(rconf.OutputDir, id, "journal-raw.txt.gz"))
 		}
 	}()
 
@@ -946,16 +1108,40 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 		} else {
 			userdata = t.UserDataV3
 		}
+		nodeUserData := t.NodeUserData
+		if fx != nil {
+			userdata = fx.Subst(userdata)
+			nodeUserData = nil
+			for _, ud := range t.NodeUserData {
+				nodeUserData = append(nodeUserData, fx.Subst(ud))
+			}
+		}
 
 		options := platform.MachineOptions{
 			AdditionalDisks: t.AdditionalDisks,
 			MinMemory:       t.MinMemory,
+			DiskFaults:      t.DiskFaults,
 		}
-		if _, err := platform.NewMachines(c, userdata, t.ClusterSize, options); err != nil {
+		if nodeUserData != nil {
+			if len(nodeUserData) != t.ClusterSize {
+				h.Fatalf("Test has %d node configs for %d machines", len(nodeUserData), t.ClusterSize)
+			}
+			if _, err := platform.NewMachinesWithUserData(c, nodeUserData, options); err != nil {
+				h.Fatalf("Cluster failed starting machines: %v", err)
+			}
+		} else if _, err := platform.NewMachines(c, userdata, t.ClusterSize, options); err != nil {
 			h.Fatalf("Cluster failed starting machines: %v", err)
 		}
 	}
 
+	// check the machines and copy declared guest paths and diagnostics
+	// back before they are destroyed
+	defer func() {
+		checkUnits(h, t, c.Machines(), "")
+		collectDiagnostics(h, pltfrm, c.Machines())
+		collectPaths(h, t, c.Machines())
+	}()
+
 	// pass along all registered native functions
 	var names []string
 	for k := range t.NativeFuncs {
@@ -968,6 +1154,7 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 		Cluster:     c,
 		NativeFuncs: names,
 		FailFast:    t.FailFast,
+		Fixtures:    fx,
 	}
 
 	// drop kolet binary on machines
@@ -986,6 +1173,9 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 		for _, mach := range tcluster.Machines() {
 			unit := fmt.Sprintf("kola-runext-%s", filepath.Base(t.ExternalTest))
 			remotepath := fmt.Sprintf("/usr/local/bin/%s", unit)
+			if _, err := in.Seek(0, 0); err != nil {
+				h.Fatal(err)
+			}
 			if err := platform.InstallFile(in, mach, remotepath); err != nil {
 				h.Fatal(errors.Wrapf(err, "uploading %s", t.ExternalTest))
 			}
@@ -1001,6 +1191,7 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 				defer f.Close()
 				out := tcluster.MustSSHf(mach, "journalctl -t %s", unit)
 				f.WriteString(string(out))
+				h.Attach(fmt.Sprintf("%s/%s", mach.ID(), unit), path, "text/plain")
 			}(mach)
 		}
 	}
@@ -1021,6 +1212,26 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 
 	// run test
 	t.Run(tcluster)
+
+	checkMetrics(h, t, pltfrm, baseline)
+}
+
+// attachMachineArtifacts attaches the console log and journal export of a
+// destroyed machine, if the platform produced them, to the test report.
+func attachMachineArtifacts(h *harness.H, dir, id string) {
+	for _, a := range []struct {
+		name      string
+		file      string
+		mediaType string
+	}{
+		{"console", "console.txt", "text/plain"},
+		{"journal", "journal-raw.txt.gz", "application/gzip"},
+	} {
+		path := filepath.Join(dir, a.file)
+		if _, err := os.Stat(path); err == nil {
+			h.Attach(fmt.Sprintf("%s/%s", id, a.name), path, a.mediaType)
+		}
+	}
 }
 
 // scpKolet searches for a kolet binary and copies it to the machine.
@@ -1043,28 +1254,6 @@ func scpKolet(machines []platform.Machine) error {
 	return fmt.Errorf("Unable to locate kolet binary for %s", mArch)
 }
 
-// CheckConsole checks some console output for badness and returns short
-// descriptions of any badness it finds. If t is specified, its flags are
-// respected.
-func CheckConsole(output []byte, t *register.Test) []string {
-	var ret []string
-	for _, check := range consoleChecks {
-		if check.skipFlag != nil && t != nil && t.HasFlag(*check.skipFlag) {
-			continue
-		}
-		match := check.match.FindSubmatch(output)
-		if match != nil {
-			badness := check.desc
-			if len(match) > 1 {
-				// include first subexpression
-				badness += fmt.Sprintf(" (%s)", match[1])
-			}
-			ret = append(ret, badness)
-		}
-	}
-	return ret
-}
-
 func SetupOutputDir(outputDir, platform string) (string, error) {
 	defaulted := outputDir == ""
 
@@ -0,0 +1,221 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package history keeps a local record of kola test results across runs,
+// stored as one JSON object per line, and derives flakiness and duration
+// statistics from it.
+package history
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// Record is the result of a single test in a single run.
+type Record struct {
+	Test     string                `json:"test"`
+	Platform string                `json:"platform"`
+	Arch     string                `json:"arch"`
+	Build    string                `json:"build,omitempty"`
+	Time     time.Time             `json:"time"`
+	Duration time.Duration         `json:"duration"`
+	Result   testresult.TestResult `json:"result"`
+	// Fingerprint identifies the failure message independently of
+	// addresses, counters and other run-specific details.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Append adds records to the history file at path, creating it if needed.
+func Append(path string, records []Record) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads all records from the history file at path.
+func Load(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+var (
+	sourceLocation = regexp.MustCompile(`^\s*[\w.-]+\.go:\d+: `)
+	hexNumber      = regexp.MustCompile(`\b(0x)?[0-9a-fA-F]{8,}\b`)
+	decNumber      = regexp.MustCompile(`\d+`)
+)
+
+// Fingerprint returns a short hash of the last message in a failed test's
+// output, with source locations and numbers masked so that the same
+// failure on different runs hashes identically. It returns "" if the
+// output has no message.
+func Fingerprint(output string) string {
+	var msg string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		msg = line
+	}
+	if msg == "" {
+		return ""
+	}
+	msg = sourceLocation.ReplaceAllString(msg, "")
+	msg = hexNumber.ReplaceAllString(msg, "X")
+	msg = decNumber.ReplaceAllString(msg, "N")
+	sum := sha256.Sum256([]byte(msg))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Stats summarizes the history of one test on one platform and arch.
+type Stats struct {
+	Test     string `json:"test"`
+	Platform string `json:"platform"`
+	Arch     string `json:"arch"`
+	Runs     int    `json:"runs"`
+	Failures int    `json:"failures"`
+	// FlakeRate is the fraction of consecutive runs whose results
+	// alternate between pass and fail.
+	FlakeRate    float64       `json:"flakeRate"`
+	MeanDuration time.Duration `json:"meanDuration"`
+	// RecentDuration is the mean duration of the last trendWindow runs.
+	RecentDuration time.Duration         `json:"recentDuration"`
+	LastResult     testresult.TestResult `json:"lastResult"`
+	// FirstFailingBuild is the build on which the current run of
+	// failures started, if the test is failing now.
+	FirstFailingBuild string `json:"firstFailingBuild,omitempty"`
+}
+
+// FailureRate returns the fraction of runs which failed.
+func (s Stats) FailureRate() float64 {
+	if s.Runs == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Runs)
+}
+
+// trendWindow is the number of latest runs compared to the overall mean.
+const trendWindow = 5
+
+// Summarize groups records by test, platform and arch and computes their
+// statistics. Skipped runs are ignored. The result is sorted by test name.
+func Summarize(records []Record) []Stats {
+	type key struct{ test, platform, arch string }
+	groups := map[key][]Record{}
+	for _, r := range records {
+		if r.Result != testresult.Pass && r.Result != testresult.Fail {
+			continue
+		}
+		k := key{r.Test, r.Platform, r.Arch}
+		groups[k] = append(groups[k], r)
+	}
+
+	var stats []Stats
+	for k, runs := range groups {
+		sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.Before(runs[j].Time) })
+		s := Stats{
+			Test:       k.test,
+			Platform:   k.platform,
+			Arch:       k.arch,
+			Runs:       len(runs),
+			LastResult: runs[len(runs)-1].Result,
+		}
+		var total, recent time.Duration
+		flips := 0
+		for i, r := range runs {
+			total += r.Duration
+			if i >= len(runs)-trendWindow {
+				recent += r.Duration
+			}
+			if r.Result == testresult.Fail {
+				s.Failures++
+			}
+			if i > 0 && r.Result != runs[i-1].Result {
+				flips++
+			}
+		}
+		s.MeanDuration = total / time.Duration(len(runs))
+		window := trendWindow
+		if len(runs) < window {
+			window = len(runs)
+		}
+		s.RecentDuration = recent / time.Duration(window)
+		if len(runs) > 1 {
+			s.FlakeRate = float64(flips) / float64(len(runs)-1)
+		}
+		if s.LastResult == testresult.Fail {
+			i := len(runs) - 1
+			for i > 0 && runs[i-1].Result == testresult.Fail {
+				i--
+			}
+			s.FirstFailingBuild = runs[i].Build
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Test != stats[j].Test {
+			return stats[i].Test < stats[j].Test
+		}
+		if stats[i].Platform != stats[j].Platform {
+			return stats[i].Platform < stats[j].Platform
+		}
+		return stats[i].Arch < stats[j].Arch
+	})
+	return stats
+}
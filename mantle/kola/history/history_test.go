@@ -0,0 +1,100 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+func TestAppendLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	first := []Record{{Test: "a", Platform: "qemu-unpriv", Arch: "x86_64", Time: now, Result: testresult.Pass}}
+	second := []Record{{Test: "b", Platform: "qemu-unpriv", Arch: "x86_64", Time: now, Result: testresult.Fail, Fingerprint: "abc"}}
+	if err := Append(path, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(path, second); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(first, second...); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint("--- FAIL: basic (12.3s)\n    harness.go:101: dial tcp 127.0.0.1:45123: connection refused\n")
+	b := Fingerprint("--- FAIL: basic (40.1s)\n    cluster.go:77: dial tcp 127.0.0.1:38001: connection refused\n")
+	c := Fingerprint("    harness.go:101: unit foo.service failed\n")
+	if a == "" || a != b {
+		t.Errorf("expected equal fingerprints, got %q and %q", a, b)
+	}
+	if a == c {
+		t.Errorf("expected different fingerprints for different failures")
+	}
+	if Fingerprint("--- FAIL: basic (1s)\n") != "" {
+		t.Errorf("expected empty fingerprint without a message")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Now()
+	var records []Record
+	for i, result := range []testresult.TestResult{testresult.Pass, testresult.Fail, testresult.Pass, testresult.Skip, testresult.Fail, testresult.Fail} {
+		records = append(records, Record{
+			Test:     "a",
+			Platform: "qemu-unpriv",
+			Arch:     "x86_64",
+			Build:    string('0' + rune(i)),
+			Time:     start.Add(time.Duration(i) * time.Hour),
+			Duration: time.Duration(i+1) * time.Minute,
+			Result:   result,
+		})
+	}
+	stats := Summarize(records)
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 group, got %d", len(stats))
+	}
+	s := stats[0]
+	if s.Runs != 5 || s.Failures != 3 {
+		t.Errorf("got %d runs and %d failures, want 5 and 3", s.Runs, s.Failures)
+	}
+	if s.FlakeRate != 0.75 {
+		t.Errorf("got flake rate %v, want 0.75", s.FlakeRate)
+	}
+	if s.FirstFailingBuild != "4" {
+		t.Errorf("got first failing build %q, want \"4\"", s.FirstFailingBuild)
+	}
+	if s.MeanDuration != 17*time.Minute/5 {
+		t.Errorf("got mean duration %v", s.MeanDuration)
+	}
+}
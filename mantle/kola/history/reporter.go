@@ -0,0 +1,73 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/reporters"
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+type historyReporter struct {
+	mu      sync.Mutex
+	records []Record
+	path    string
+
+	platform string
+	arch     string
+	build    string
+}
+
+// NewReporter returns a harness reporter which appends the results of the
+// run to the history file at path when the suite finishes.
+func NewReporter(path, platform, arch, build string) reporters.Reporter {
+	return &historyReporter{
+		path:     path,
+		platform: platform,
+		arch:     arch,
+		build:    build,
+	}
+}
+
+func (r *historyReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, attachments []reporters.Attachment, metrics []reporters.Metric) {
+	rec := Record{
+		Test:     name,
+		Platform: r.platform,
+		Arch:     r.arch,
+		Build:    r.build,
+		Time:     time.Now().UTC(),
+		Duration: duration,
+		Result:   result,
+	}
+	if result == testresult.Fail {
+		rec.Fingerprint = Fingerprint(string(b))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+}
+
+// Output appends the collected records to the history file; the suite
+// output directory is not used.
+func (r *historyReporter) Output(string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Append(r.path, r.records)
+}
+
+func (r *historyReporter) SetResult(testresult.TestResult) {}
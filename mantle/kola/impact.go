@@ -0,0 +1,102 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/sdk"
+)
+
+// ChangedPackagesSince returns the packages changed between the build
+// since and the build under test, from the pkgdiff of each build in
+// between. If since is "parent", the pkgdiff against the parent release is
+// used instead.
+func ChangedPackagesSince(since string) ([]string, error) {
+	if CosaBuild == nil {
+		return nil, fmt.Errorf("no coreos-assembler build to compare to %s", since)
+	}
+	if since == "parent" {
+		return uniqueSorted(CosaBuild.Meta.PkgdiffAgainstParent.Packages()), nil
+	}
+
+	ids, err := sdk.GetLocalBuildIDs(Options.CosaWorkdir)
+	if err != nil {
+		return nil, err
+	}
+	current, previous := -1, -1
+	for i, id := range ids {
+		if id == CosaBuild.Meta.BuildID {
+			current = i
+		}
+		if id == since {
+			previous = i
+		}
+	}
+	if current < 0 {
+		return nil, fmt.Errorf("build %s not found in builds.json", CosaBuild.Meta.BuildID)
+	}
+	if previous < 0 {
+		return nil, fmt.Errorf("build %s not found in builds.json", since)
+	}
+	if previous < current {
+		return nil, fmt.Errorf("build %s is newer than the build under test %s", since, CosaBuild.Meta.BuildID)
+	}
+
+	// builds are newest first, and each pkgdiff is against the previous one
+	var pkgs []string
+	for _, id := range ids[current:previous] {
+		build, err := sdk.GetLocalBuild(Options.CosaWorkdir, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading build %s", id)
+		}
+		pkgs = append(pkgs, build.Meta.PkgdiffBetweenBuilds.Packages()...)
+	}
+	return uniqueSorted(pkgs), nil
+}
+
+func uniqueSorted(s []string) []string {
+	seen := make(map[string]struct{})
+	ret := []string{}
+	for _, e := range s {
+		if _, ok := seen[e]; !ok {
+			seen[e] = struct{}{}
+			ret = append(ret, e)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// testImpacted returns whether t covers one of ChangedPackages. Tests which
+// declare no packages may cover anything, so they are always impacted.
+func testImpacted(t *register.Test) bool {
+	if ChangedPackages == nil || len(t.Packages) == 0 {
+		return true
+	}
+	for _, pattern := range t.Packages {
+		for _, pkg := range ChangedPackages {
+			if match, _ := filepath.Match(pattern, pkg); match {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/cosa"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/sdk"
+	"github.com/coreos/mantle/system"
+)
+
+// pkgdiff returns a pkgdiff changing pkgs.
+func pkgdiff(pkgs ...string) cosa.PackageSetDifferences {
+	var diff cosa.PackageSetDifferences
+	for _, pkg := range pkgs {
+		diff = append(diff, []interface{}{pkg, 2, map[string]interface{}{}})
+	}
+	return diff
+}
+
+// writeWorkdir writes a coreos-assembler workdir to dir with the builds
+// ids, newest first, each changing the packages in pkgs from the previous
+// one.
+func writeWorkdir(t *testing.T, dir string, ids []string, pkgs map[string][]string) {
+	fixture, err := ioutil.ReadFile("../../fixtures/fcos.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index struct {
+		Builds []map[string]string `json:"builds"`
+	}
+	for _, id := range ids {
+		index.Builds = append(index.Builds, map[string]string{"id": id})
+
+		var meta map[string]interface{}
+		if err := json.Unmarshal(fixture, &meta); err != nil {
+			t.Fatal(err)
+		}
+		meta["buildid"] = id
+		meta["pkgdiff"] = pkgdiff(pkgs[id]...)
+		data, err := json.Marshal(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builddir := filepath.Join(dir, "builds", id, system.RpmArch())
+		if err := os.MkdirAll(builddir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(builddir, "meta.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "builds", "builds.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangedPackagesSince(t *testing.T) {
+	defer func(build *sdk.LocalBuild, workdir string) {
+		CosaBuild = build
+		Options.CosaWorkdir = workdir
+	}(CosaBuild, Options.CosaWorkdir)
+
+	dir, err := ioutil.TempDir("", "kola-impact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeWorkdir(t, dir, []string{"33.3", "33.2", "33.1", "33.0"}, map[string][]string{
+		"33.3": {"kernel", "kernel-core"},
+		"33.2": {"podman", "kernel"},
+		"33.1": {"ignition"},
+		"33.0": {"systemd"},
+	})
+	Options.CosaWorkdir = dir
+
+	tests := []struct {
+		current, since string
+		pkgs           []string
+		err            string
+	}{
+		{"33.3", "33.2", []string{"kernel", "kernel-core"}, ""},
+		{"33.3", "33.1", []string{"kernel", "kernel-core", "podman"}, ""},
+		{"33.2", "33.0", []string{"ignition", "kernel", "podman"}, ""},
+		{"33.3", "33.3", []string{}, ""},
+		{"33.2", "parent", []string{"moby-engine"}, ""},
+		{"33.1", "33.2", nil, "build 33.2 is newer than the build under test 33.1"},
+		{"33.3", "32.9", nil, "build 32.9 not found"},
+		{"34.0", "33.0", nil, "build 34.0 not found"},
+	}
+	for _, tt := range tests {
+		CosaBuild = &sdk.LocalBuild{Meta: &cosa.Build{
+			BuildID:              tt.current,
+			PkgdiffAgainstParent: pkgdiff("moby-engine", "moby-engine"),
+		}}
+		pkgs, err := ChangedPackagesSince(tt.since)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s since %s: got error %v, expected %q", tt.current, tt.since, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s since %s: %v", tt.current, tt.since, err)
+		} else if !reflect.DeepEqual(pkgs, tt.pkgs) {
+			t.Errorf("%s since %s: got %q, expected %q", tt.current, tt.since, pkgs, tt.pkgs)
+		}
+	}
+
+	CosaBuild = nil
+	if _, err := ChangedPackagesSince("33.0"); err == nil {
+		t.Errorf("no build: no error")
+	}
+}
+
+func TestUniqueSorted(t *testing.T) {
+	for _, tt := range []struct {
+		in, out []string
+	}{
+		{nil, []string{}},
+		{[]string{"podman"}, []string{"podman"}},
+		{[]string{"podman", "kernel", "podman", "ignition", "kernel"}, []string{"ignition", "kernel", "podman"}},
+	} {
+		if out := uniqueSorted(tt.in); !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%q: got %q, expected %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestTestImpacted(t *testing.T) {
+	defer func(pkgs []string) { ChangedPackages = pkgs }(ChangedPackages)
+
+	tests := []struct {
+		name     string
+		changed  []string
+		packages []string
+		impacted bool
+	}{
+		{"no changes known", nil, []string{"podman"}, true},
+		{"nothing changed", []string{}, []string{"podman"}, false},
+		{"no packages declared", []string{"kernel"}, nil, true},
+		{"package changed", []string{"ignition", "podman"}, []string{"podman"}, true},
+		{"other package changed", []string{"kernel"}, []string{"podman"}, false},
+		{"glob", []string{"kernel-core"}, []string{"kernel*"}, true},
+		{"glob mismatch", []string{"kernel-core"}, []string{"kernel-*-devel"}, false},
+		{"any package", []string{"systemd"}, []string{"podman", "systemd*"}, true},
+	}
+	for _, tt := range tests {
+		ChangedPackages = tt.changed
+		if impacted := testImpacted(&register.Test{Name: "test", Packages: tt.packages}); impacted != tt.impacted {
+			t.Errorf("%s: impacted %v, expected %v", tt.name, impacted, tt.impacted)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/harness/reporters"
+	"github.com/coreos/mantle/kola/register"
+)
+
+// metricBaseline maps test names to the metrics they recorded in a
+// previous report.
+type metricBaseline map[string]map[string]float64
+
+// loadMetricBaseline reads the metrics from a report.json written by a
+// previous run. An empty path returns a nil baseline.
+func loadMetricBaseline(path string) (metricBaseline, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening metrics baseline")
+	}
+	defer f.Close()
+
+	var report struct {
+		Tests []struct {
+			Name    string             `json:"name"`
+			Metrics []reporters.Metric `json:"metrics"`
+		} `json:"tests"`
+	}
+	if err := json.NewDecoder(f).Decode(&report); err != nil {
+		return nil, errors.Wrapf(err, "parsing metrics baseline %s", path)
+	}
+	baseline := metricBaseline{}
+	for _, t := range report.Tests {
+		for _, m := range t.Metrics {
+			if baseline[t.Name] == nil {
+				baseline[t.Name] = map[string]float64{}
+			}
+			baseline[t.Name][m.Name] = m.Value
+		}
+	}
+	return baseline, nil
+}
+
+// checkMetrics fails the test if a metric it recorded is outside the
+// thresholds declared for pltfrm, and compares the metrics to the baseline.
+func checkMetrics(h *harness.H, t *register.Test, pltfrm string, baseline metricBaseline) {
+	for _, m := range h.Metrics() {
+		old, ok := baseline[h.Name()][m.Name]
+		problems, note := checkMetric(t, pltfrm, m, old, ok)
+		for _, problem := range problems {
+			h.Error(problem)
+		}
+		if note != "" {
+			h.Log(note)
+		}
+	}
+}
+
+// checkMetric returns the thresholds of t on pltfrm that metric m is
+// outside of, and whether it regressed from its baseline value old if ok,
+// along with a note comparing them otherwise. Metrics are assumed to be
+// better when lower, so only increases beyond MetricsTolerance are
+// regressions.
+func checkMetric(t *register.Test, pltfrm string, m reporters.Metric, old float64, ok bool) ([]string, string) {
+	var problems []string
+	for _, th := range t.Thresholds {
+		if th.Metric != m.Name || (len(th.Platforms) > 0 && !hasString(pltfrm, th.Platforms)) {
+			continue
+		}
+		if th.Max != 0 && m.Value > th.Max {
+			problems = append(problems, fmt.Sprintf("metric %s is %g %s, above the threshold of %g", m.Name, m.Value, m.Unit, th.Max))
+		}
+		if th.Min != 0 && m.Value < th.Min {
+			problems = append(problems, fmt.Sprintf("metric %s is %g %s, below the threshold of %g", m.Name, m.Value, m.Unit, th.Min))
+		}
+	}
+
+	if !ok || old == 0 {
+		return problems, ""
+	}
+	change := 100 * (m.Value - old) / old
+	if MetricsTolerance > 0 && change > MetricsTolerance {
+		problems = append(problems, fmt.Sprintf("metric %s regressed by %.1f%%: %g %s, baseline %g %s", m.Name, change, m.Value, m.Unit, old, m.Unit))
+		return problems, ""
+	}
+	return problems, fmt.Sprintf("metric %s: %g %s, %+.1f%% from baseline", m.Name, m.Value, m.Unit, change)
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/harness/reporters"
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestLoadMetricBaseline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+	report := `{"tests": [
+	  {"name": "coreos.metrics.boot", "result": "PASS", "metrics": [
+	    {"name": "boot-time", "value": 12.5, "unit": "s"},
+	    {"name": "memory-used", "value": 300, "unit": "MiB"}]},
+	  {"name": "basic", "result": "PASS"}]}`
+	if err := ioutil.WriteFile(path, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	baseline, err := loadMetricBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := metricBaseline{
+		"coreos.metrics.boot": {"boot-time": 12.5, "memory-used": 300},
+	}
+	if !reflect.DeepEqual(baseline, expected) {
+		t.Errorf("got baseline %v", baseline)
+	}
+
+	if baseline, err := loadMetricBaseline(""); err != nil || baseline != nil {
+		t.Errorf("no path: got %v, %v", baseline, err)
+	}
+	if _, err := loadMetricBaseline(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("missing baseline: no error")
+	}
+}
+
+func TestCheckMetric(t *testing.T) {
+	defer func(tolerance float64) { MetricsTolerance = tolerance }(MetricsTolerance)
+	MetricsTolerance = 10
+
+	test := &register.Test{
+		Thresholds: []register.Threshold{
+			{Metric: "boot-time", Max: 60},
+			{Metric: "boot-time", Max: 30, Platforms: []string{"qemu"}},
+			{Metric: "free", Min: 100},
+		},
+	}
+	tests := []struct {
+		name     string
+		pltfrm   string
+		metric   reporters.Metric
+		old      float64
+		ok       bool
+		problems []string
+		note     string
+	}{
+		{
+			"within thresholds",
+			"aws", reporters.Metric{Name: "boot-time", Value: 40, Unit: "s"}, 0, false,
+			nil, "",
+		},
+		{
+			"above platform threshold",
+			"qemu", reporters.Metric{Name: "boot-time", Value: 40, Unit: "s"}, 0, false,
+			[]string{"above the threshold of 30"}, "",
+		},
+		{
+			"above thresholds",
+			"qemu", reporters.Metric{Name: "boot-time", Value: 70, Unit: "s"}, 0, false,
+			[]string{"above the threshold of 60", "above the threshold of 30"}, "",
+		},
+		{
+			"below threshold",
+			"aws", reporters.Metric{Name: "free", Value: 50, Unit: "MiB"}, 0, false,
+			[]string{"below the threshold of 100"}, "",
+		},
+		{
+			"within tolerance",
+			"aws", reporters.Metric{Name: "boot-time", Value: 21, Unit: "s"}, 20, true,
+			nil, "+5.0% from baseline",
+		},
+		{
+			"improved",
+			"aws", reporters.Metric{Name: "boot-time", Value: 10, Unit: "s"}, 20, true,
+			nil, "-50.0% from baseline",
+		},
+		{
+			"regressed",
+			"aws", reporters.Metric{Name: "boot-time", Value: 25, Unit: "s"}, 20, true,
+			[]string{"regressed by 25.0%"}, "",
+		},
+		{
+			"zero baseline",
+			"aws", reporters.Metric{Name: "boot-time", Value: 25, Unit: "s"}, 0, true,
+			nil, "",
+		},
+	}
+	for _, tt := range tests {
+		problems, note := checkMetric(test, tt.pltfrm, tt.metric, tt.old, tt.ok)
+		if len(problems) != len(tt.problems) {
+			t.Errorf("%s: got problems %q", tt.name, problems)
+			continue
+		}
+		for i := range problems {
+			if !strings.Contains(problems[i], tt.problems[i]) {
+				t.Errorf("%s: got problem %q, expected %q", tt.name, problems[i], tt.problems[i])
+			}
+		}
+		if (note == "") != (tt.note == "") || !strings.Contains(note, tt.note) {
+			t.Errorf("%s: got note %q, expected %q", tt.name, note, tt.note)
+		}
+	}
+
+	MetricsTolerance = 0
+	if problems, _ := checkMetric(test, "aws", reporters.Metric{Name: "boot-time", Value: 40}, 20, true); problems != nil {
+		t.Errorf("no tolerance: got problems %q", problems)
+	}
+}
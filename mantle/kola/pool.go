@@ -0,0 +1,462 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// poolKey identifies machines which are interchangeable for pooled tests.
+// Tests share machines booted from identical rendered configs, however
+// the configs were built.
+type poolKey struct {
+	config             string
+	noSSHKeyInUserData bool
+	noSSHKeyInMetadata bool
+	internetAccess     bool
+}
+
+// poolSnapshotName is the snapshot pooled machines are reset to
+const poolSnapshotName = "kola-pool"
+
+// pooledMachine is a booted machine shared by the non-destructive tests
+// using the same configuration. Tests run on it one at a time, so that the
+// journal written while a test runs is its own. A machine on which a test
+// failed is tainted: later tests get a fresh machine, and once its current
+// users are done it is restored to its snapshot taken after booting, or
+// destroyed if it has none.
+type pooledMachine struct {
+	key      poolKey
+	cluster  platform.Cluster
+	machine  platform.Machine
+	snapshot bool
+	ready    chan struct{}
+	err      error
+	// unreserve returns the machine's share of the host budget
+	unreserve func()
+
+	// held by the test running on the machine
+	running sync.Mutex
+
+	// protected by machinePool.mu
+	users     int
+	tainted   bool
+	destroyed bool
+}
+
+// machinePool hands out pooledMachines to tests flagged NonDestructive,
+// booting one machine per configuration on first use. Each machine is
+// charged to budget, if any, for as long as it is booted, and idle
+// machines are destroyed when other reservations are waiting.
+type machinePool struct {
+	flight    platform.Flight
+	pltfrm    string
+	outputDir string
+	budget    *resourceBudget
+
+	mu       sync.Mutex
+	machines map[poolKey]*pooledMachine
+	all      []*pooledMachine
+}
+
+// newMachinePool returns a pool for tests run on pltfrm, or nil if pooling
+// is disabled or not supported on pltfrm.
+func newMachinePool(flight platform.Flight, pltfrm, outputDir string, budget *resourceBudget) *machinePool {
+	if NoMachinePool {
+		return nil
+	}
+	switch pltfrm {
+	case "qemu", "qemu-unpriv":
+	default:
+		return nil
+	}
+	p := &machinePool{
+		flight:    flight,
+		pltfrm:    pltfrm,
+		outputDir: outputDir,
+		budget:    budget,
+		machines:  make(map[poolKey]*pooledMachine),
+	}
+	if budget != nil {
+		budget.reclaim = p.reclaimIdle
+	}
+	return p
+}
+
+// accepts returns whether t can run on a pooled machine.
+func (p *machinePool) accepts(t *register.Test) bool {
+	return p != nil &&
+		t.HasFlag(register.NonDestructive) &&
+		!t.HasFlag(register.RequiresFixtures) &&
+		!t.HasFlag(register.InjectsNetworkFaults) &&
+		t.NetworkFaults == nil &&
+		t.EmulatePlatform == "" &&
+		t.ClusterSize == 1 &&
+		t.NodeUserData == nil &&
+		t.AdditionalDisks == nil &&
+		t.MinMemory == 0 &&
+		t.ExternalTest == "" &&
+		t.DependencyDir == "" &&
+		t.ExpectedFailedUnits == nil &&
+		t.ExpectedCoredumps == nil
+}
+
+// lease returns a booted machine for key, booting it from userdata if
+// needed, once no other test is running on it. It must be returned with
+// release. Waits for the machine or the budget happen inside wait.
+func (p *machinePool) lease(key poolKey, userdata *conf.UserData, wait func(func())) (*pooledMachine, error) {
+	for {
+		p.mu.Lock()
+		pm := p.machines[key]
+		boot := pm == nil || pm.tainted
+		if boot {
+			pm = &pooledMachine{key: key, ready: make(chan struct{})}
+			p.machines[key] = pm
+			p.all = append(p.all, pm)
+		}
+		pm.users++
+		dir := filepath.Join(p.outputDir, "machine-pool", strconv.Itoa(len(p.all)))
+		p.mu.Unlock()
+
+		if boot {
+			pm.err = p.boot(pm, key, userdata, dir, wait)
+			close(pm.ready)
+		} else {
+			wait(func() { <-pm.ready })
+		}
+		if pm.err != nil {
+			p.drop(pm, true)
+			return nil, pm.err
+		}
+
+		wait(pm.running.Lock)
+		p.mu.Lock()
+		tainted := pm.tainted
+		p.mu.Unlock()
+		if !tainted {
+			return pm, nil
+		}
+		// a test failed on it while we waited
+		pm.running.Unlock()
+		p.drop(pm, false)
+	}
+}
+
+func (p *machinePool) boot(pm *pooledMachine, key poolKey, userdata *conf.UserData, dir string, wait func(func())) error {
+	if p.budget != nil {
+		r, err := testResources(&register.Test{ClusterSize: 1}, p.budget.mainDisk)
+		if err != nil {
+			return err
+		}
+		wait(func() {
+			pm.unreserve = p.budget.reserve(r)
+		})
+	}
+	rconf := &platform.RuntimeConfig{
+		OutputDir:          dir,
+		NoSSHKeyInUserData: key.noSSHKeyInUserData,
+		NoSSHKeyInMetadata: key.noSSHKeyInMetadata,
+		InternetAccess:     key.internetAccess,
+	}
+	c, err := p.flight.NewCluster(rconf)
+	if err != nil {
+		return err
+	}
+	machs, err := platform.NewMachines(c, userdata, 1, platform.MachineOptions{})
+	if err != nil {
+		c.Destroy()
+		return err
+	}
+	pm.cluster = c
+	pm.machine = machs[0]
+	plog.Infof("Booted pooled machine %s, output in %s", pm.machine.ID(), dir)
+
+	if sm, ok := pm.machine.(platform.SnapshotMachine); ok {
+		if err := sm.Snapshot(poolSnapshotName); err != nil {
+			plog.Warningf("Snapshotting pooled machine %s failed, it will be replaced if a test fails on it: %v", pm.machine.ID(), err)
+		} else {
+			pm.snapshot = true
+		}
+	}
+	return nil
+}
+
+// release returns a leased machine to the pool, tainting it if the test
+// using it failed.
+func (p *machinePool) release(pm *pooledMachine, failed bool) {
+	pm.running.Unlock()
+	p.drop(pm, failed)
+}
+
+// drop removes a user of pm, restoring or destroying it if it is tainted
+// and no longer used.
+func (p *machinePool) drop(pm *pooledMachine, failed bool) {
+	p.mu.Lock()
+	pm.users--
+	if failed {
+		pm.tainted = true
+	}
+	reset := pm.tainted && pm.users == 0 && !pm.destroyed
+	if reset {
+		pm.destroyed = true
+	}
+	p.mu.Unlock()
+
+	if !reset {
+		p.reclaimIdle()
+		return
+	}
+	if pm.snapshot {
+		err := pm.machine.(platform.SnapshotMachine).Restore(poolSnapshotName)
+		if err == nil {
+			p.mu.Lock()
+			// tests arriving while it was tainted got a new machine
+			reused := p.machines[pm.key] == pm
+			if reused {
+				pm.tainted = false
+				pm.destroyed = false
+			}
+			p.mu.Unlock()
+			if reused {
+				plog.Infof("Restored pooled machine %s", pm.machine.ID())
+				p.reclaimIdle()
+				return
+			}
+		} else {
+			plog.Warningf("Restoring pooled machine %s failed: %v", pm.machine.ID(), err)
+		}
+	}
+	p.destroyMachine(pm)
+}
+
+// reclaimIdle destroys the machines no test is using if reservations are
+// waiting for the budget.
+func (p *machinePool) reclaimIdle() {
+	if p.budget == nil || !p.budget.blocked() {
+		return
+	}
+	p.mu.Lock()
+	var idle []*pooledMachine
+	for _, pm := range p.all {
+		if pm.users == 0 && !pm.destroyed {
+			pm.destroyed = true
+			if p.machines[pm.key] == pm {
+				delete(p.machines, pm.key)
+			}
+			idle = append(idle, pm)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, pm := range idle {
+		plog.Infof("Destroying idle pooled machine %s to free the host budget", pm.machine.ID())
+		p.destroyMachine(pm)
+	}
+}
+
+func (p *machinePool) destroyMachine(pm *pooledMachine) {
+	if pm.unreserve != nil {
+		defer pm.unreserve()
+	}
+	if pm.cluster == nil {
+		return
+	}
+	pm.cluster.Destroy()
+	for id, output := range pm.cluster.ConsoleOutput() {
+		for _, m := range CheckConsole([]byte(output), nil, p.pltfrm) {
+			plog.Warningf("Found %s on pooled machine %s console line %d [%s]", m, id, m.Line, m.Rule.ID)
+		}
+	}
+}
+
+// Destroy destroys all pooled machines.
+func (p *machinePool) Destroy() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	var machines []*pooledMachine
+	for _, pm := range p.all {
+		if !pm.destroyed {
+			pm.destroyed = true
+			machines = append(machines, pm)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, pm := range machines {
+		p.destroyMachine(pm)
+	}
+}
+
+// pooledCluster presents the single leased machine of a pooledMachine to a
+// test. Tests cannot add machines to it, and it outlives the test.
+type pooledCluster struct {
+	platform.Cluster
+	machine platform.Machine
+}
+
+func (pc *pooledCluster) Machines() []platform.Machine {
+	return []platform.Machine{pc.machine}
+}
+
+func (pc *pooledCluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	return nil, errors.New("tests using pooled machines cannot create machines")
+}
+
+func (pc *pooledCluster) NewMachineWithOptions(userdata *conf.UserData, options platform.MachineOptions) (platform.Machine, error) {
+	return pc.NewMachine(userdata)
+}
+
+func (pc *pooledCluster) Destroy() {}
+
+func (pc *pooledCluster) ConsoleOutput() map[string]string {
+	return map[string]string{}
+}
+
+func (pc *pooledCluster) JournalOutput() map[string]string {
+	return map[string]string{}
+}
+
+// runPooledTest runs t on a machine leased from pool. Only the journal
+// written while the test ran is checked for badness.
+func runPooledTest(h *harness.H, t *register.Test, pltfrm string, pool *machinePool, userdata *conf.UserData, baseline metricBaseline) {
+	key := poolKey{
+		noSSHKeyInUserData: t.HasFlag(register.NoSSHKeyInUserData),
+		noSSHKeyInMetadata: t.HasFlag(register.NoSSHKeyInMetadata),
+		internetAccess:     testRequiresInternet(t),
+	}
+	if userdata != nil {
+		config, err := userdata.Render(IsIgnitionV2())
+		if err != nil {
+			h.Fatalf("Rendering config: %v", err)
+		}
+		key.config = config.String()
+	}
+	pm, err := pool.lease(key, userdata, h.Wait)
+	if err != nil {
+		h.Fatalf("Pooled machine failed: %v", err)
+	}
+	m := pm.machine
+
+	cursor, err := journalCursor(m)
+	if err != nil {
+		pool.release(pm, true)
+		h.Fatalf("Reading journal cursor of pooled machine %s: %v", m.ID(), err)
+	}
+	defer func() {
+		checkPooledJournal(h, t, pltfrm, m, cursor)
+		pool.release(pm, h.Failed())
+	}()
+	h.Logf("Running on pooled machine %s", m.ID())
+	defer func() {
+		checkUnits(h, t, []platform.Machine{m}, cursor)
+		collectDiagnostics(h, pltfrm, []platform.Machine{m})
+		collectPaths(h, t, []platform.Machine{m})
+	}()
+
+	var names []string
+	for k := range t.NativeFuncs {
+		names = append(names, k)
+	}
+	tcluster := cluster.TestCluster{
+		H:           h,
+		Cluster:     &pooledCluster{Cluster: pm.cluster, machine: m},
+		NativeFuncs: names,
+		FailFast:    t.FailFast,
+	}
+	if t.NativeFuncs != nil {
+		if err := scpKolet(tcluster.Machines()); err != nil {
+			h.Fatal(err)
+		}
+	}
+
+	t.Run(tcluster)
+
+	checkMetrics(h, t, pltfrm, baseline)
+}
+
+// journalCursor returns the cursor of the last journal entry of m.
+func journalCursor(m platform.Machine) (string, error) {
+	out, stderr, err := m.SSH("journalctl -q -n 0 --show-cursor --no-pager")
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, stderr)
+	}
+	cursor := strings.TrimPrefix(strings.TrimSpace(string(out)), "-- cursor: ")
+	if cursor == "" {
+		return "", errors.New("empty journal cursor")
+	}
+	return cursor, nil
+}
+
+// checkPooledJournal saves the journal of m written after cursor to the
+// test's output directory and checks it for badness.
+func checkPooledJournal(h *harness.H, t *register.Test, pltfrm string, m platform.Machine, cursor string) {
+	out, stderr, err := m.SSH(fmt.Sprintf("journalctl -q --no-pager -o short-monotonic --after-cursor '%s'", cursor))
+	if err != nil {
+		h.Errorf("Reading journal of pooled machine %s: %v: %s", m.ID(), err, stderr)
+		return
+	}
+	dir := filepath.Join(h.OutputDir(), m.ID())
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		h.Errorf("Creating %s: %v", dir, err)
+		return
+	}
+	path := filepath.Join(dir, "journal.txt")
+	if err := ioutil.WriteFile(path, out, 0644); err != nil {
+		h.Errorf("Writing %s: %v", path, err)
+		return
+	}
+	h.Attach(fmt.Sprintf("%s/journal", m.ID()), path, "text/plain")
+	reportConsoleMatches(h, CheckConsole(out, t, pltfrm), m.ID(), "journal")
+
+	if AVCSeverity == SeverityIgnore {
+		return
+	}
+	var export bytes.Buffer
+	res, err := m.Exec(context.Background(), platform.ExecRequest{
+		Argv:   []string{"journalctl", "-q", "--no-pager", "-o", "export", "--after-cursor", cursor},
+		Stdout: &export,
+	})
+	if err == nil && !res.Success() {
+		err = errors.New(res.String())
+	}
+	if err != nil {
+		h.Errorf("Reading journal of pooled machine %s: %v", m.ID(), err)
+		return
+	}
+	denials, err := ParseAVCDenials(&export)
+	if err != nil {
+		h.Errorf("Checking SELinux denials on machine %s: %v", m.ID(), err)
+		return
+	}
+	reportAVCDenials(h, t, pltfrm, m.ID(), dir, denials)
+}
@@ -0,0 +1,327 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// fakeFlight boots fakeClusters of machines which can be snapshotted
+// unless noSnapshot is set.
+type fakeFlight struct {
+	platform.Flight
+	noSnapshot bool
+	restoreErr error
+
+	mu       sync.Mutex
+	clusters []*fakeCluster
+}
+
+func (f *fakeFlight) NewCluster(rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := &fakeCluster{flight: f, id: fmt.Sprintf("m%d", len(f.clusters))}
+	f.clusters = append(f.clusters, c)
+	return c, nil
+}
+
+func (f *fakeFlight) booted() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.clusters)
+}
+
+type fakeCluster struct {
+	platform.Cluster
+	flight    *fakeFlight
+	id        string
+	destroyed bool
+	machine   *fakeMachine
+}
+
+func (c *fakeCluster) NewMachineWithOptions(userdata *conf.UserData, options platform.MachineOptions) (platform.Machine, error) {
+	if c.flight.noSnapshot {
+		return &plainMachine{id: c.id}, nil
+	}
+	c.machine = &fakeMachine{id: c.id, restoreErr: c.flight.restoreErr}
+	return c.machine, nil
+}
+
+func (c *fakeCluster) Destroy() {
+	c.destroyed = true
+}
+
+func (c *fakeCluster) ConsoleOutput() map[string]string {
+	return nil
+}
+
+// fakeMachine is a SnapshotMachine recording its snapshots and restores.
+type fakeMachine struct {
+	platform.Machine
+	id         string
+	restoreErr error
+	snapshots  []string
+	restores   []string
+}
+
+func (m *fakeMachine) ID() string {
+	return m.id
+}
+
+func (m *fakeMachine) Snapshot(name string) error {
+	m.snapshots = append(m.snapshots, name)
+	return nil
+}
+
+func (m *fakeMachine) Restore(name string) error {
+	m.restores = append(m.restores, name)
+	return m.restoreErr
+}
+
+// plainMachine is a Machine which cannot be snapshotted.
+type plainMachine struct {
+	platform.Machine
+	id string
+}
+
+func (m *plainMachine) ID() string {
+	return m.id
+}
+
+func newFakePool(flight *fakeFlight) *machinePool {
+	return &machinePool{
+		flight:   flight,
+		pltfrm:   "qemu-unpriv",
+		machines: make(map[poolKey]*pooledMachine),
+	}
+}
+
+// wait stands in for harness.H.Wait.
+func wait(f func()) {
+	f()
+}
+
+func leaseFake(t *testing.T, p *machinePool) *pooledMachine {
+	pm, err := p.lease(poolKey{config: "{}"}, conf.Empty(), wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm
+}
+
+func TestPoolReuse(t *testing.T) {
+	flight := &fakeFlight{}
+	p := newFakePool(flight)
+
+	pm := leaseFake(t, p)
+	p.release(pm, false)
+	if again := leaseFake(t, p); again != pm {
+		t.Errorf("machine not reused")
+	} else {
+		p.release(again, false)
+	}
+	if n := flight.booted(); n != 1 {
+		t.Errorf("booted %d machines, expected 1", n)
+	}
+	if m := flight.clusters[0].machine; len(m.snapshots) != 1 || m.snapshots[0] != poolSnapshotName {
+		t.Errorf("got snapshots %q", m.snapshots)
+	}
+
+	p.Destroy()
+	if !flight.clusters[0].destroyed {
+		t.Errorf("machine not destroyed with the pool")
+	}
+}
+
+func TestPoolRestore(t *testing.T) {
+	flight := &fakeFlight{}
+	p := newFakePool(flight)
+
+	pm := leaseFake(t, p)
+	p.release(pm, true)
+	c := flight.clusters[0]
+	if len(c.machine.restores) != 1 || c.machine.restores[0] != poolSnapshotName {
+		t.Errorf("got restores %q", c.machine.restores)
+	}
+	if c.destroyed {
+		t.Errorf("restored machine destroyed")
+	}
+
+	// the restored machine is reused
+	if again := leaseFake(t, p); again != pm {
+		t.Errorf("restored machine not reused")
+	} else {
+		p.release(again, false)
+	}
+	if n := flight.booted(); n != 1 {
+		t.Errorf("booted %d machines, expected 1", n)
+	}
+}
+
+func TestPoolReplace(t *testing.T) {
+	for name, flight := range map[string]*fakeFlight{
+		"no snapshot":    {noSnapshot: true},
+		"restore failed": {restoreErr: errors.New("savevm failed")},
+	} {
+		p := newFakePool(flight)
+		pm := leaseFake(t, p)
+		p.release(pm, true)
+		if !flight.clusters[0].destroyed {
+			t.Errorf("%s: failed machine not destroyed", name)
+		}
+		if again := leaseFake(t, p); again == pm {
+			t.Errorf("%s: failed machine reused", name)
+		} else {
+			p.release(again, false)
+		}
+		if n := flight.booted(); n != 2 {
+			t.Errorf("%s: booted %d machines, expected 2", name, n)
+		}
+		p.Destroy()
+	}
+}
+
+func TestPoolFailedWhileWaiting(t *testing.T) {
+	flight := &fakeFlight{}
+	p := newFakePool(flight)
+
+	pm := leaseFake(t, p)
+	leased := make(chan *pooledMachine)
+	go func() {
+		other, err := p.lease(poolKey{config: "{}"}, conf.Empty(), wait)
+		if err != nil {
+			t.Error(err)
+		}
+		leased <- other
+	}()
+	// wait for the second test to queue on the machine
+	for i := 0; ; i++ {
+		p.mu.Lock()
+		users := pm.users
+		p.mu.Unlock()
+		if users == 2 {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("second lease never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	p.release(pm, true)
+	var other *pooledMachine
+	select {
+	case other = <-leased:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lease never granted")
+	}
+	if other == nil {
+		t.FailNow()
+	}
+	defer p.release(other, false)
+
+	// the waiting test moved on, so the machine was restored for it
+	// rather than replaced
+	if other != pm {
+		t.Errorf("restored machine not reused")
+	}
+	c := flight.clusters[0]
+	if len(c.machine.restores) != 1 || c.destroyed {
+		t.Errorf("failed machine restored %d times, destroyed %v", len(c.machine.restores), c.destroyed)
+	}
+	if n := flight.booted(); n != 1 {
+		t.Errorf("booted %d machines, expected 1", n)
+	}
+}
+
+func TestPoolBudget(t *testing.T) {
+	defer func(memory string) { QEMUOptions.Memory = memory }(QEMUOptions.Memory)
+	QEMUOptions.Memory = "1024"
+
+	budget := newResourceBudget(hostResources{memory: 2048})
+	flight := &fakeFlight{}
+	p := newFakePool(flight)
+	p.budget = budget
+	budget.reclaim = p.reclaimIdle
+
+	// the machine is charged once, however many tests lease it
+	pm := leaseFake(t, p)
+	p.release(pm, false)
+	pm = leaseFake(t, p)
+	p.release(pm, false)
+	budget.mu.Lock()
+	used := budget.used
+	budget.mu.Unlock()
+	if used != (hostResources{memory: 1024, cpus: 1}) {
+		t.Errorf("pooled machine charged %s", used)
+	}
+
+	// an idle machine is given back to a waiting reservation
+	granted := make(chan func())
+	go func() {
+		granted <- budget.reserve(hostResources{memory: 2048})
+	}()
+	var release func()
+	select {
+	case release = <-granted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle pooled machine never reclaimed")
+	}
+	if !flight.clusters[0].destroyed {
+		t.Errorf("idle pooled machine not destroyed")
+	}
+
+	// the next lease boots a machine once the budget is free
+	leased := make(chan *pooledMachine)
+	go func() {
+		other, err := p.lease(poolKey{config: "{}"}, conf.Empty(), wait)
+		if err != nil {
+			t.Error(err)
+		}
+		leased <- other
+	}()
+	select {
+	case <-leased:
+		t.Fatal("pooled machine booted past the budget")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	select {
+	case other := <-leased:
+		if other == nil {
+			t.FailNow()
+		}
+		if other == pm {
+			t.Errorf("destroyed machine leased")
+		}
+		p.release(other, false)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pooled machine never booted")
+	}
+
+	p.Destroy()
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if budget.used != (hostResources{}) || budget.running != 0 {
+		t.Errorf("budget not released: %s used by %d", budget.used, budget.running)
+	}
+}
@@ -20,7 +20,9 @@ import (
 	"github.com/coreos/go-semver/semver"
 
 	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/conf"
+	"github.com/coreos/mantle/platform/metadata"
 )
 
 type Flag int
@@ -30,6 +32,9 @@ const (
 	NoSSHKeyInMetadata                 // don't add SSH key to platform metadata
 	NoEmergencyShellCheck              // don't check console output for emergency shell invocation
 	RequiresInternetAccess             // run the test only if the platform supports Internet access
+	NonDestructive                     // test doesn't change the machine, so it may share a pooled machine with other tests
+	RequiresFixtures                   // start local fixture servers for Ignition remote resources; QEMU only
+	InjectsNetworkFaults               // make machines NetworkFaultMachines; QEMU only
 )
 
 // NativeFuncWrap is a wrapper for the NativeFunc which includes an optional string of arches and/or distributions to
@@ -45,6 +50,15 @@ func CreateNativeFuncWrap(f func() error, exclusions ...string) NativeFuncWrap {
 	return NativeFuncWrap{f, exclusions}
 }
 
+// Threshold bounds a metric recorded by a test with RecordMetric. A zero
+// Min or Max leaves that side unbounded.
+type Threshold struct {
+	Metric    string
+	Min       float64
+	Max       float64
+	Platforms []string // platforms the threshold applies to -- defaults to all
+}
+
 // Test provides the main test abstraction for kola. The run function is
 // the actual testing function while the other fields provide ways to
 // statically declare state of the platform.TestCluster before the test
@@ -56,22 +70,67 @@ type Test struct {
 	UserData             *conf.UserData
 	UserDataV3           *conf.UserData
 	ClusterSize          int
-	Platforms            []string // allowlist of platforms to run test against -- defaults to all
-	ExcludePlatforms     []string // denylist of platforms to ignore -- defaults to none
-	Distros              []string // allowlist of distributions to run test against -- defaults to all
-	ExcludeDistros       []string // denylist of distributions to ignore -- defaults to none
-	Architectures        []string // allowlist of machine architectures supported -- defaults to all
-	ExcludeArchitectures []string // denylist of architectures to ignore -- defaults to none
-	Flags                []Flag   // special-case options for this test
-	Tags                 []string // list of tags that can be matched against -- defaults to none
+	NodeUserData         []*conf.UserData // per-machine userdata, overriding UserData and UserDataV3 -- must have ClusterSize entries
+	Platforms            []string         // allowlist of platforms to run test against -- defaults to all
+	ExcludePlatforms     []string         // denylist of platforms to ignore -- defaults to none
+	Distros              []string         // allowlist of distributions to run test against -- defaults to all
+	ExcludeDistros       []string         // denylist of distributions to ignore -- defaults to none
+	Architectures        []string         // allowlist of machine architectures supported -- defaults to all
+	ExcludeArchitectures []string         // denylist of architectures to ignore -- defaults to none
+	Flags                []Flag           // special-case options for this test
+	Tags                 []string         // list of tags that can be matched against -- defaults to none
 
 	// Sizes of additional empty disks to attach to the node (e.g. ["1G",
-	// "5G"]) -- defaults to none.
+	// "5G"]), with ":mpath" to present one over multiple paths on QEMU
+	// (e.g. "1G:mpath") -- defaults to none.
 	AdditionalDisks []string
 
+	// Faults injected into the additional disks, by index into
+	// AdditionalDisks (e.g. EIO on writes to the first disk after 100
+	// of them); qemu-unpriv only -- defaults to none.
+	DiskFaults map[int][]platform.DiskFault
+
+	// Console and journal rule ids (e.g. "segfault") not checked for this
+	// test -- defaults to none.
+	SuppressConsoleRules []string
+
+	// SELinux denials expected from the test, as audit2allow-like rules
+	// such as "init_t container_file_t:file { read open }", whose types
+	// and class may be glob patterns -- defaults to none.
+	AllowedAVCs []string
+
+	// Systemd units (e.g. "kdump.service") and crashing executables
+	// (e.g. "/usr/bin/false" or "false") the test expects to find failed
+	// afterwards, as globs -- defaults to none.
+	ExpectedFailedUnits []string
+	ExpectedCoredumps   []string
+
+	// Packages covered by the test, as globs (e.g. "podman", "kernel*").
+	// With --changed-since, the test only runs if one of them changed --
+	// defaults to none, which means the test always runs.
+	Packages []string
+
+	// EmulatePlatform is the ignition.platform.id (e.g. "aws") that
+	// machines boot as on qemu-unpriv, against a local emulation of that
+	// platform's metadata service -- defaults to none.
+	EmulatePlatform string
+
+	// Absolute guest paths (e.g. "/var/log/audit") copied from each
+	// machine to its directory in the test's output directory after the
+	// test runs -- defaults to none.
+	CollectPaths []string
+
+	// Network faults injected into each machine on schedule after it
+	// starts (e.g. the link going down at 10s and back up at 20s), which
+	// imply the InjectsNetworkFaults flag -- defaults to none.
+	NetworkFaults []platform.NetworkFault
+
 	// Minimum amount of memory required for test.
 	MinMemory int
 
+	// Thresholds fail the test if a metric it recorded is out of bounds.
+	Thresholds []Threshold
+
 	// ExternalTest is a path to a binary that will be uploaded
 	ExternalTest string
 	// DependencyDir is a path to directory that will be uploaded, normally used by external tests
@@ -113,6 +172,16 @@ func Register(m map[string]*Test, t *Test) {
 		panic(fmt.Sprintf("test %v has an invalid version range", t.Name))
 	}
 
+	for i := range t.DiskFaults {
+		if i < 0 || i >= len(t.AdditionalDisks) {
+			panic(fmt.Sprintf("test %v has faults for missing additional disk %d", t.Name, i))
+		}
+	}
+
+	if t.EmulatePlatform != "" && !metadata.Supported(t.EmulatePlatform) {
+		panic(fmt.Sprintf("test %v emulates unsupported platform %v", t.Name, t.EmulatePlatform))
+	}
+
 	m[t.Name] = t
 }
 
@@ -0,0 +1,258 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/util"
+)
+
+// hostResources is an amount of host memory (MiB), vCPUs and disk (MiB)
+// consumed by the machines of a test on a local platform.
+type hostResources struct {
+	memory int
+	cpus   int
+	disk   int
+}
+
+func (r hostResources) String() string {
+	return fmt.Sprintf("%d MiB memory, %d vCPUs, %d MiB disk", r.memory, r.cpus, r.disk)
+}
+
+// fits reports whether r can be added to used without exceeding total.
+// A zero dimension in total is unlimited.
+func (r hostResources) fits(used, total hostResources) bool {
+	fit := func(want, used, total int) bool {
+		return total == 0 || used+want <= total
+	}
+	return fit(r.memory, used.memory, total.memory) &&
+		fit(r.cpus, used.cpus, total.cpus) &&
+		fit(r.disk, used.disk, total.disk)
+}
+
+// resourceBudget hands out reservations against a fixed host budget. Tests
+// block in reserve until their whole cluster fits alongside the tests
+// already running, in the order they asked, so that large clusters are not
+// starved by a stream of small ones.
+type resourceBudget struct {
+	total hostResources
+	// mainDisk is the size of the primary disk of each machine, in MiB
+	mainDisk int
+
+	mu   sync.Mutex
+	used hostResources
+	// running is the number of outstanding reservations
+	running int
+	// waiting are the reservations blocked in reserve, oldest first
+	waiting []*budgetWaiter
+
+	// reclaim, if set, is called without locks held when a reservation
+	// has to wait, to give back resources which are held but idle
+	reclaim func()
+}
+
+// budgetWaiter is a reservation waiting to be granted.
+type budgetWaiter struct {
+	r       hostResources
+	granted chan struct{}
+}
+
+func newResourceBudget(total hostResources) *resourceBudget {
+	return &resourceBudget{total: total}
+}
+
+// admits reports whether r can be granted now. A request larger than the
+// whole budget is granted once nothing else is running, so that oversized
+// tests run alone instead of never.
+func (b *resourceBudget) admits(r hostResources) bool {
+	return r.fits(b.used, b.total) || b.running == 0
+}
+
+func (b *resourceBudget) grant(r hostResources) {
+	b.used.memory += r.memory
+	b.used.cpus += r.cpus
+	b.used.disk += r.disk
+	b.running++
+}
+
+// reserve blocks until r is available and returns a function releasing
+// it.
+func (b *resourceBudget) reserve(r hostResources) func() {
+	b.mu.Lock()
+	if len(b.waiting) == 0 && b.admits(r) {
+		b.grant(r)
+		b.mu.Unlock()
+	} else {
+		w := &budgetWaiter{r: r, granted: make(chan struct{})}
+		b.waiting = append(b.waiting, w)
+		b.mu.Unlock()
+		if b.reclaim != nil {
+			b.reclaim()
+		}
+		<-w.granted
+	}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.used.memory -= r.memory
+		b.used.cpus -= r.cpus
+		b.used.disk -= r.disk
+		b.running--
+		for len(b.waiting) > 0 && b.admits(b.waiting[0].r) {
+			w := b.waiting[0]
+			b.waiting = b.waiting[1:]
+			b.grant(w.r)
+			close(w.granted)
+		}
+	}
+}
+
+// blocked reports whether reservations are waiting.
+func (b *resourceBudget) blocked() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.waiting) > 0
+}
+
+// newHostBudget returns the resource budget configured on the command line
+// for pltfrm, or nil if tests should only be limited by TestParallelism.
+func newHostBudget(pltfrm string) (*resourceBudget, error) {
+	switch pltfrm {
+	case "qemu-unpriv", "qemu-iso":
+	default:
+		return nil, nil
+	}
+	var disk int
+	if HostDisk != "" {
+		var err error
+		if disk, err = parseSizeMiB(HostDisk); err != nil {
+			return nil, err
+		}
+	}
+	total := hostResources{memory: HostMemory, cpus: HostCPUs, disk: disk}
+	if total == (hostResources{}) {
+		return nil, nil
+	}
+	b := newResourceBudget(total)
+	if pltfrm == "qemu-unpriv" && total.disk != 0 {
+		var err error
+		if b.mainDisk, err = mainDiskMiB(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// mainDiskMiB returns the size of the primary disk of qemu-unpriv
+// machines: the size of the image, as resized by QEMUOptions.DiskSize.
+func mainDiskMiB() (int, error) {
+	size := QEMUOptions.DiskSize
+	if size != "" && !strings.HasPrefix(size, "+") {
+		return parseSizeMiB(size)
+	}
+	if QEMUOptions.DiskImage == "" {
+		return 0, nil
+	}
+	info, err := util.GetImageInfo(QEMUOptions.DiskImage)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading size of %s", QEMUOptions.DiskImage)
+	}
+	disk := int((info.VirtualSize + (1 << 20) - 1) >> 20)
+	if size != "" {
+		grow, err := parseSizeMiB(size[1:])
+		if err != nil {
+			return 0, err
+		}
+		disk += grow
+	}
+	return disk, nil
+}
+
+// testResources estimates the host resources used by the machines of t,
+// each with a primary disk of mainDisk MiB. Tests which start their own
+// machines are counted as one machine.
+func testResources(t *register.Test, mainDisk int) (hostResources, error) {
+	machines := t.ClusterSize
+	if machines < 1 {
+		machines = 1
+	}
+
+	// This mirrors the memory selection in platform/machine/unprivqemu
+	memory := platform.DefaultQemuMemory()
+	if QEMUOptions.Memory != "" {
+		m, err := strconv.Atoi(QEMUOptions.Memory)
+		if err != nil {
+			return hostResources{}, fmt.Errorf("parsing memory option: %v", err)
+		}
+		memory = m
+	} else if t.MinMemory != 0 {
+		memory = t.MinMemory
+	}
+
+	disk := mainDisk
+	for _, spec := range t.AdditionalDisks {
+		s, _, err := platform.ParseDiskSpec(spec)
+		if err != nil {
+			return hostResources{}, err
+		}
+		d, err := parseSizeMiB(s)
+		if err != nil {
+			return hostResources{}, err
+		}
+		disk += d
+	}
+
+	return hostResources{
+		memory: machines * memory,
+		cpus:   machines, // unprivqemu machines use a single vCPU
+		disk:   machines * disk,
+	}, nil
+}
+
+// parseSizeMiB parses a disk size in bytes with an optional K, M, G or T
+// suffix, as accepted by qemu-img, and returns it in MiB rounded up.
+func parseSizeMiB(s string) (int, error) {
+	size := strings.TrimSpace(s)
+	var shift uint
+	if n := len(size); n > 0 {
+		switch strings.ToUpper(size[n-1:]) {
+		case "K":
+			shift = 10
+		case "M":
+			shift = 20
+		case "G":
+			shift = 30
+		case "T":
+			shift = 40
+		}
+		if shift != 0 {
+			size = size[:n-1]
+		}
+	}
+	v, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	bytes := v << shift
+	return int((bytes + (1 << 20) - 1) >> 20), nil
+}
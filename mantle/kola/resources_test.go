@@ -0,0 +1,214 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestParseSizeMiB(t *testing.T) {
+	tests := []struct {
+		size string
+		mib  int
+	}{
+		{"0", 0},
+		{"1", 1},
+		{"1048576", 1},
+		{"1048577", 2},
+		{"512K", 1},
+		{"10M", 10},
+		{"10m", 10},
+		{"5G", 5120},
+		{" 2G ", 2048},
+		{"1T", 1 << 20},
+	}
+	for _, tt := range tests {
+		mib, err := parseSizeMiB(tt.size)
+		if err != nil {
+			t.Errorf("%q: %v", tt.size, err)
+		} else if mib != tt.mib {
+			t.Errorf("%q: got %d MiB, expected %d", tt.size, mib, tt.mib)
+		}
+	}
+	for _, size := range []string{"", "G", "-1G", "1.5G", "10X", "+5G"} {
+		if _, err := parseSizeMiB(size); err == nil {
+			t.Errorf("%q: no error", size)
+		}
+	}
+}
+
+func TestResourcesFit(t *testing.T) {
+	total := hostResources{memory: 4096, cpus: 4}
+	tests := []struct {
+		r, used hostResources
+		fits    bool
+	}{
+		{hostResources{memory: 1024, cpus: 1}, hostResources{}, true},
+		{hostResources{memory: 4096, cpus: 4}, hostResources{}, true},
+		{hostResources{memory: 1024, cpus: 1}, hostResources{memory: 3072, cpus: 3}, true},
+		{hostResources{memory: 1024, cpus: 1}, hostResources{memory: 3584, cpus: 1}, false},
+		{hostResources{memory: 1024, cpus: 2}, hostResources{memory: 1024, cpus: 3}, false},
+		// disk is unlimited
+		{hostResources{memory: 1024, cpus: 1, disk: 1 << 30}, hostResources{}, true},
+	}
+	for _, tt := range tests {
+		if fits := tt.r.fits(tt.used, total); fits != tt.fits {
+			t.Errorf("%s with %s used: fits %v, expected %v", tt.r, tt.used, fits, tt.fits)
+		}
+	}
+}
+
+func TestTestResources(t *testing.T) {
+	defer func(memory string) { QEMUOptions.Memory = memory }(QEMUOptions.Memory)
+	QEMUOptions.Memory = ""
+
+	r, err := testResources(&register.Test{
+		ClusterSize:     2,
+		MinMemory:       4096,
+		AdditionalDisks: []string{"1G", "512M:mpath"},
+	}, 10240)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (hostResources{memory: 8192, cpus: 2, disk: 2 * (10240 + 1536)}); r != expected {
+		t.Errorf("got %s, expected %s", r, expected)
+	}
+
+	// tests starting their own machines count as one
+	r, err = testResources(&register.Test{MinMemory: 2048}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (hostResources{memory: 2048, cpus: 1}); r != expected {
+		t.Errorf("got %s, expected %s", r, expected)
+	}
+
+	if _, err := testResources(&register.Test{AdditionalDisks: []string{"lots"}}, 0); err == nil {
+		t.Errorf("invalid disk size: no error")
+	}
+	if _, err := testResources(&register.Test{AdditionalDisks: []string{"1G:raid"}}, 0); err == nil {
+		t.Errorf("invalid disk option: no error")
+	}
+}
+
+// waitQueued waits for n reservations to be waiting in b.
+func waitQueued(t *testing.T, b *resourceBudget, n int) {
+	for i := 0; i < 1000; i++ {
+		b.mu.Lock()
+		queued := len(b.waiting)
+		b.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d reservations never queued", n)
+}
+
+func TestReserve(t *testing.T) {
+	b := newResourceBudget(hostResources{memory: 10})
+
+	releaseA := b.reserve(hostResources{memory: 6})
+
+	granted := make(chan string, 2)
+	releases := make(chan func(), 2)
+	reserve := func(name string, memory int) {
+		release := b.reserve(hostResources{memory: memory})
+		granted <- name
+		releases <- release
+	}
+	go reserve("B", 6)
+	waitQueued(t, b, 1)
+	// C would fit, but B asked first
+	go reserve("C", 2)
+	waitQueued(t, b, 2)
+
+	select {
+	case name := <-granted:
+		t.Fatalf("%s granted while A holds the budget", name)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// both fit once A is done
+	releaseA()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-granted:
+		case <-time.After(5 * time.Second):
+			t.Fatal("B and C never granted")
+		}
+	}
+	(<-releases)()
+	(<-releases)()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used != (hostResources{}) || b.running != 0 {
+		t.Errorf("budget not released: %s used by %d", b.used, b.running)
+	}
+}
+
+func TestReserveOversized(t *testing.T) {
+	b := newResourceBudget(hostResources{memory: 10})
+
+	// granted alone
+	release := b.reserve(hostResources{memory: 20})
+
+	granted := make(chan struct{})
+	go func() {
+		b.reserve(hostResources{memory: 1})()
+		close(granted)
+	}()
+	waitQueued(t, b, 1)
+	release()
+	select {
+	case <-granted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reservation after an oversized one never granted")
+	}
+}
+
+func TestReserveReclaim(t *testing.T) {
+	b := newResourceBudget(hostResources{memory: 10})
+
+	var idle func()
+	b.reclaim = func() {
+		if !b.blocked() {
+			t.Errorf("reclaim called without waiting reservations")
+		}
+		if idle != nil {
+			idle()
+			idle = nil
+		}
+	}
+	idle = b.reserve(hostResources{memory: 8})
+
+	granted := make(chan struct{})
+	go func() {
+		b.reserve(hostResources{memory: 4})()
+		close(granted)
+	}()
+	select {
+	case <-granted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle reservation never reclaimed")
+	}
+	if b.blocked() {
+		t.Errorf("reservations still waiting")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/kola/selector"
+)
+
+// selectionFlags maps the flag names usable in selection expressions to
+// test flags. "needs-internet" also matches the NeedsInternetTag.
+var selectionFlags = map[string]register.Flag{
+	"NoSSHKeyInUserData":     register.NoSSHKeyInUserData,
+	"NoSSHKeyInMetadata":     register.NoSSHKeyInMetadata,
+	"NoEmergencyShellCheck":  register.NoEmergencyShellCheck,
+	"RequiresInternetAccess": register.RequiresInternetAccess,
+	"NonDestructive":         register.NonDestructive,
+	"RequiresFixtures":       register.RequiresFixtures,
+	"InjectsNetworkFaults":   register.InjectsNetworkFaults,
+	"needs-internet":         register.RequiresInternetAccess,
+}
+
+// parseSelection parses Selection, returning nil if it is empty.
+func parseSelection() (selector.Expr, error) {
+	if Selection == "" {
+		return nil, nil
+	}
+	expr, err := selector.Parse(Selection)
+	if err != nil {
+		return nil, err
+	}
+	for _, atom := range selector.Atoms(expr) {
+		if atom.Key == "flag" {
+			if _, ok := selectionFlags[atom.Value]; !ok {
+				return nil, fmt.Errorf("selector: unknown flag %q", atom.Value)
+			}
+		}
+	}
+	return expr, nil
+}
+
+// selectionMatcher matches the atoms of selection expressions against t.
+func selectionMatcher(t *register.Test) selector.Matcher {
+	return func(a selector.Atom) bool {
+		switch a.Key {
+		case "tag":
+			return hasString(a.Value, t.Tags)
+		case "name":
+			match, _ := filepath.Match(a.Value, t.Name)
+			return match
+		case "platform":
+			allowed, _ := platformAllowed(t, a.Value)
+			return allowed
+		case "arch":
+			allowed, _ := isAllowed(a.Value, t.Architectures, t.ExcludeArchitectures)
+			return allowed
+		case "distro":
+			allowed, _ := isAllowed(a.Value, t.Distros, t.ExcludeDistros)
+			return allowed
+		case "flag":
+			flag := selectionFlags[a.Value]
+			if flag == register.RequiresInternetAccess {
+				return testRequiresInternet(t)
+			}
+			if flag == register.InjectsNetworkFaults {
+				return t.HasFlag(flag) || len(t.NetworkFaults) > 0
+			}
+			return t.HasFlag(flag)
+		}
+		return false
+	}
+}
+
+// SelectionMatches reports whether t matches the Selection expression,
+// which all tests match if it is empty.
+func SelectionMatches(t *register.Test) (bool, error) {
+	selection, err := parseSelection()
+	if err != nil || selection == nil {
+		return true, err
+	}
+	return selection.Match(selectionMatcher(t)), nil
+}
+
+// Explanation records whether a test would be run and why.
+type Explanation struct {
+	Name     string `json:"name"`
+	Selected bool   `json:"selected"`
+	Denied   bool   `json:"denylisted,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ExplainTests returns, for each of tests, whether it would be run with
+// patterns on pltfrm, or why not. The version of the OS is not known, so
+// version ranges are not considered.
+func ExplainTests(tests map[string]*register.Test, patterns []string, pltfrm string) ([]Explanation, error) {
+	selection, err := parseSelection()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Explanation
+	for name, t := range tests {
+		e := Explanation{Name: name}
+		reason, err := filterReason(t, patterns, pltfrm, semver.Version{}, selection)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			e.Reason = reason
+		} else {
+			pattern, err := denylistPattern(t)
+			if err != nil {
+				return nil, err
+			}
+			if pattern != "" {
+				e.Denied = true
+				e.Reason = denylistReason(pattern)
+			} else {
+				e.Selected = true
+			}
+		}
+		ret = append(ret, e)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selector parses boolean expressions selecting kola tests, such as
+//
+//	ignition and not (slow or flag:needs-internet) and platform:qemu
+//
+// Terms are KEY:VALUE atoms, or bare words which are tags. Expressions
+// combine them with "and", "or" and "not" (or "&&", "||" and "!") and
+// parentheses; "not" binds tightest and "or" loosest.
+package selector
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Keys are the atom keys an expression may use.
+var Keys = []string{"tag", "name", "platform", "arch", "distro", "flag"}
+
+// Atom is a KEY:VALUE term of an expression.
+type Atom struct {
+	Key   string
+	Value string
+}
+
+func (a Atom) String() string {
+	return a.Key + ":" + a.Value
+}
+
+// Matcher reports whether a test matches an atom.
+type Matcher func(a Atom) bool
+
+// Expr is a parsed selection expression.
+type Expr interface {
+	// Match evaluates the expression, using m for its atoms.
+	Match(m Matcher) bool
+	String() string
+	atoms() []Atom
+}
+
+type atomExpr struct {
+	a Atom
+}
+
+func (e atomExpr) Match(m Matcher) bool { return m(e.a) }
+func (e atomExpr) String() string       { return e.a.String() }
+func (e atomExpr) atoms() []Atom        { return []Atom{e.a} }
+
+type notExpr struct {
+	e Expr
+}
+
+func (e notExpr) Match(m Matcher) bool { return !e.e.Match(m) }
+func (e notExpr) String() string       { return "not " + e.e.String() }
+func (e notExpr) atoms() []Atom        { return e.e.atoms() }
+
+type binaryExpr struct {
+	op   string
+	l, r Expr
+}
+
+func (e binaryExpr) Match(m Matcher) bool {
+	if e.op == "and" {
+		return e.l.Match(m) && e.r.Match(m)
+	}
+	return e.l.Match(m) || e.r.Match(m)
+}
+
+func (e binaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.l, e.op, e.r)
+}
+
+func (e binaryExpr) atoms() []Atom {
+	return append(e.l.atoms(), e.r.atoms()...)
+}
+
+// Atoms returns the atoms of e, in order.
+func Atoms(e Expr) []Atom {
+	return e.atoms()
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+func tokenize(s string) []token {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch {
+		case unicode.IsSpace(rune(s[i])):
+			i++
+		case s[i] == '(' || s[i] == ')' || s[i] == '!':
+			tokens = append(tokens, token{s[i : i+1], i})
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, token{s[i : i+2], i})
+			i += 2
+		default:
+			start := i
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) && !strings.ContainsRune("()!&|", rune(s[i])) {
+				i++
+			}
+			if i == start {
+				// a lone '&' or '|'
+				i++
+			}
+			tokens = append(tokens, token{s[start:i], start})
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	end    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.next >= len(p.tokens) {
+		return token{pos: p.end}, false
+	}
+	return p.tokens[p.next], true
+}
+
+func (p *parser) accept(texts ...string) bool {
+	tok, ok := p.peek()
+	if !ok {
+		return false
+	}
+	for _, text := range texts {
+		if tok.text == text {
+			p.next++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	tok, _ := p.peek()
+	return fmt.Errorf("selector: offset %d: %s", tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or", "||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{"or", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and", "&&") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{"and", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.accept("not", "!") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	if p.accept("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected \")\"")
+		}
+		return e, nil
+	}
+	switch tok.text {
+	case ")", "and", "or", "&&", "||", "&", "|":
+		return nil, p.errorf("unexpected %q", tok.text)
+	}
+	p.next++
+
+	a := Atom{Key: "tag", Value: tok.text}
+	if i := strings.Index(tok.text, ":"); i >= 0 {
+		a = Atom{Key: tok.text[:i], Value: tok.text[i+1:]}
+		known := false
+		for _, key := range Keys {
+			known = known || key == a.Key
+		}
+		if !known {
+			return nil, fmt.Errorf("selector: offset %d: unknown key %q (expected one of %s)", tok.pos, a.Key, strings.Join(Keys, ", "))
+		}
+	}
+	if a.Value == "" {
+		return nil, fmt.Errorf("selector: offset %d: empty value for %q", tok.pos, a.Key)
+	}
+	return atomExpr{a}, nil
+}
+
+// Parse parses a selection expression.
+func Parse(s string) (Expr, error) {
+	p := &parser{tokens: tokenize(s), end: len(s)}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.peek(); ok {
+		return nil, p.errorf("unexpected %q", p.tokens[p.next].text)
+	}
+	return e, nil
+}
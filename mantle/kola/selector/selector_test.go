@@ -0,0 +1,95 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func matcher(name string, tags ...string) Matcher {
+	return func(a Atom) bool {
+		switch a.Key {
+		case "tag":
+			for _, tag := range tags {
+				if tag == a.Value {
+					return true
+				}
+			}
+		case "name":
+			match, _ := filepath.Match(a.Value, name)
+			return match
+		}
+		return false
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"ignition", true},
+		{"slow", false},
+		{"ignition and not slow", true},
+		{"ignition && !reprovision", false},
+		{"slow or name:ext.*", true},
+		{"not (slow or reprovision)", false},
+		{"slow or ignition and not reprovision", false},
+		{"(slow or ignition) and name:ext.config.*", true},
+		{"tag:ignition and not not reprovision", true},
+	}
+	m := matcher("ext.config.ignition.resource", "ignition", "reprovision")
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if got := e.Match(m); got != tt.match {
+			t.Errorf("%q (%s): got %v, expected %v", tt.expr, e, got, tt.match)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"ignition and",
+		"(ignition",
+		"ignition)",
+		"ignition slow",
+		"color:blue",
+		"tag:",
+		"ignition & slow",
+		"or slow",
+	} {
+		if e, err := Parse(expr); err == nil {
+			t.Errorf("%q: parsed as %s, expected an error", expr, e)
+		}
+	}
+}
+
+func TestAtoms(t *testing.T) {
+	e, err := Parse("ignition and not (platform:qemu or flag:needs-internet)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Atom{{"tag", "ignition"}, {"platform", "qemu"}, {"flag", "needs-internet"}}
+	if atoms := Atoms(e); !reflect.DeepEqual(atoms, expected) {
+		t.Errorf("got %v, expected %v", atoms, expected)
+	}
+}
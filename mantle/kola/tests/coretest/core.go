@@ -45,6 +45,7 @@ func init() {
 		Name:        "basic",
 		Run:         LocalTests,
 		ClusterSize: 1,
+		Flags:       []register.Flag{register.NonDestructive},
 		NativeFuncs: map[string]register.NativeFuncWrap{
 			"PortSSH":        register.CreateNativeFuncWrap(TestPortSsh),
 			"DbusPerms":      register.CreateNativeFuncWrap(TestDbusPerms),
@@ -369,6 +370,13 @@ func TestUseradd() error {
 		return fmt.Errorf("id %s: %v", u, err)
 	}
 
+	// leave the machine as we found it
+	c = exec.Command("sudo", "userdel", "-r", u)
+	err = c.Run()
+	if err != nil {
+		return fmt.Errorf("userdel: %v", err)
+	}
+
 	return nil
 }
 
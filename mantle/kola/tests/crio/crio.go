@@ -186,6 +186,7 @@ func init() {
 		Distros:    []string{"rhcos"},
 		UserDataV3: enableCrioIgn,
 		Tags:       []string{"crio"},
+		Packages:   []string{"cri-o", "cri-tools"},
 	})
 	register.RegisterTest(&register.Test{
 		Run:         crioNetwork,
@@ -195,8 +196,7 @@ func init() {
 		Distros:     []string{"rhcos"},
 		UserDataV3:  enableCrioIgn,
 		Tags:        []string{"crio"},
-		// qemu-unpriv machines cannot communicate between each other
-		ExcludePlatforms: []string{"qemu-unpriv"},
+		Packages:    []string{"cri-o", "cri-tools"},
 	})
 }
 
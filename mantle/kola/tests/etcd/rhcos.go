@@ -53,8 +53,6 @@ func init() {
 }`),
 		Flags:   []register.Flag{register.RequiresInternetAccess}, // fetching etcd requires networking
 		Distros: []string{"rhcos"},
-		// qemu-unpriv machines cannot communicate between each other
-		ExcludePlatforms: []string{"qemu-unpriv"},
 	})
 	register.RegisterTest(&register.Test{
 		Run:         rhcosClusterTLS,
@@ -87,8 +85,6 @@ func init() {
 }`),
 		Flags:   []register.Flag{register.RequiresInternetAccess}, // fetching etcd requires networking
 		Distros: []string{"rhcos"},
-		// qemu-unpriv machines cannot communicate between each other
-		ExcludePlatforms: []string{"qemu-unpriv"},
 	})
 }
 
@@ -12,6 +12,7 @@ func init() {
 		Run:         fipsEnableTest,
 		ClusterSize: 1,
 		Name:        `fips.enable`,
+		Packages:    []string{"kernel*"},
 		Flags:       []register.Flag{},
 		Distros:     []string{"rhcos"},
 		UserData: conf.Ignition(`{
@@ -83,6 +84,7 @@ func init() {
 		Run:         fipsEnableTest,
 		ClusterSize: 1,
 		Name:        `fips.enable.partitions`,
+		Packages:    []string{"kernel*"},
 		Flags:       []register.Flag{},
 		Distros:     []string{"rhcos"},
 		Platforms:   []string{"qemu", "qemu-unpriv"},
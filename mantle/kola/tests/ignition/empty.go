@@ -26,6 +26,7 @@ import (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:             "fcos.ignition.misc.empty",
+		Packages:         []string{"ignition"},
 		Run:              empty,
 		ClusterSize:      1,
 		ExcludePlatforms: []string{"qemu", "esx"},
@@ -35,6 +36,7 @@ func init() {
 	})
 	register.RegisterTest(&register.Test{
 		Name:             "fcos.ignition.v3.noop",
+		Packages:         []string{"ignition"},
 		Run:              empty,
 		ClusterSize:      1,
 		ExcludePlatforms: []string{"qemu", "esx", "openstack"},
@@ -23,6 +23,7 @@ import (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.once",
+		Packages:    []string{"ignition"},
 		Run:         runsOnce,
 		ClusterSize: 1,
 		Tags:        []string{"ignition"},
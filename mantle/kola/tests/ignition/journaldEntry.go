@@ -27,6 +27,7 @@ const ignitionJournalMsgId = "57124006b5c94805b77ce473e92a8aeb"
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.journald-log",
+		Packages:    []string{"ignition"},
 		Run:         sendJournaldLog,
 		ClusterSize: 1,
 		// Since RHCOS uses the 2x spec and not 3x.
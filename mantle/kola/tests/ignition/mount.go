@@ -35,6 +35,7 @@ func init() {
 	// mount disks to `/var/log` and `/var/lib/containers`
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.mount.disks",
+		Packages:    []string{"ignition"},
 		Run:         testMountDisks,
 		ClusterSize: 0,
 		Platforms:   []string{"qemu"},
@@ -43,6 +44,7 @@ func init() {
 	// create new partiitons with disk `vda`
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.mount.partitions",
+		Packages:    []string{"ignition"},
 		Run:         testMountPartitions,
 		ClusterSize: 0,
 		Platforms:   []string{"qemu"},
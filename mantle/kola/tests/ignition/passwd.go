@@ -27,6 +27,7 @@ import (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.groups",
+		Packages:    []string{"ignition"},
 		Run:         groups,
 		ClusterSize: 1,
 		Tags:        []string{"ignition"},
@@ -77,6 +78,7 @@ func init() {
 	})
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.v2.users",
+		Packages:    []string{"ignition"},
 		Run:         users,
 		ClusterSize: 1,
 		Tags:        []string{"ignition"},
@@ -19,7 +19,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
+	"github.com/kballard/go-shellquote"
 	"github.com/pin/tftp"
 
 	"github.com/coreos/mantle/kola/cluster"
@@ -98,6 +100,7 @@ var (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.resource.local",
+		Packages:    []string{"ignition"},
 		Run:         resourceLocal,
 		ClusterSize: 1,
 		NativeFuncs: map[string]register.NativeFuncWrap{
@@ -109,6 +112,7 @@ func init() {
 	})
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.resource.remote",
+		Packages:    []string{"ignition"},
 		Run:         resourceRemote,
 		ClusterSize: 1,
 		Flags:       []register.Flag{register.RequiresInternetAccess},
@@ -181,6 +185,7 @@ func init() {
 	})
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.resource.s3",
+		Packages:    []string{"ignition"},
 		Run:         resourceS3,
 		ClusterSize: 1,
 		Platforms:   []string{"aws"},
@@ -229,11 +234,188 @@ func init() {
 		  }
 	      }`),
 	})
+	// Ignition retries fetches while the link flaps during boot
+	register.RegisterTest(&register.Test{
+		Name:        "coreos.ignition.resource.link-flap",
+		Packages:    []string{"ignition"},
+		Run:         resourceLinkFlap,
+		ClusterSize: 1,
+		Flags:       []register.Flag{register.RequiresFixtures},
+		Tags:        []string{"ignition"},
+		Platforms:   []string{"qemu-unpriv"},
+		NetworkFaults: []platform.NetworkFault{
+			{At: 5 * time.Second, LinkDown: true},
+			{At: 15 * time.Second},
+			{At: 20 * time.Second, LinkDown: true},
+			{At: 40 * time.Second},
+		},
+		UserData: conf.Ignition(`{
+		  "ignition": {
+		      "version": "2.2.0"
+		  },
+		  "storage": {
+		      "files": [
+			  {
+			      "filesystem": "root",
+			      "path": "/var/resource/http",
+			      "contents": {
+				  "source": "http://10.0.2.100/resources/anonymous"
+			      },
+			      "mode": 420
+			  }
+		      ]
+		  }
+	      }`),
+	})
+	// Same as the remote tests, but against the local fixtures; see
+	// kola/fixtures for the addresses
+	register.RegisterTest(&register.Test{
+		Name:        "coreos.ignition.resource.fixtures",
+		Packages:    []string{"ignition"},
+		Run:         resourceFixtures,
+		ClusterSize: 1,
+		Flags:       []register.Flag{register.RequiresFixtures},
+		Tags:        []string{"ignition"},
+		Platforms:   []string{"qemu-unpriv"},
+		UserData: conf.Ignition(`{
+		  "ignition": {
+		      "version": "2.2.0",
+		      "config": {
+		          "append": [{
+		              "source": "https://10.0.2.100/resources/anonymous-var.ign"
+		          }]
+		      },
+		      "security": {
+		          "tls": {
+		              "certificateAuthorities": [{
+		                  "source": "$FIXTURES_CA"
+		              }]
+		          }
+		      }
+		  },
+		  "storage": {
+		      "files": [
+			  {
+			      "filesystem": "root",
+			      "path": "/var/resource/http",
+			      "contents": {
+				  "source": "http://10.0.2.100/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "filesystem": "root",
+			      "path": "/var/resource/https",
+			      "contents": {
+				  "source": "https://10.0.2.100/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "filesystem": "root",
+			      "path": "/var/resource/tftp",
+			      "contents": {
+				  "source": "tftp://10.0.2.2/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "filesystem": "root",
+			      "path": "/var/resource/s3-path",
+			      "contents": {
+				  "source": "http://10.0.2.100:9000/rh-kola-fixtures/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "filesystem": "root",
+			      "path": "/var/resource/original",
+			      "contents": {
+				  "source": "https://10.0.2.100/resources/versioned?versionId=Ym98GTx0npVaJznSAd0I1eUjFoZMP8Zo"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "filesystem": "root",
+			      "path": "/var/resource/latest",
+			      "contents": {
+				  "source": "https://10.0.2.100/resources/versioned"
+			      },
+			      "mode": 420
+			  }
+		      ]
+		  }
+	      }`),
+		UserDataV3: conf.Ignition(`{
+		  "ignition": {
+		      "version": "3.0.0",
+		      "config": {
+		          "merge": [{
+		              "source": "https://10.0.2.100/resources/anonymous-var-v3.ign"
+		          }]
+		      },
+		      "security": {
+		          "tls": {
+		              "certificateAuthorities": [{
+		                  "source": "$FIXTURES_CA"
+		              }]
+		          }
+		      }
+		  },
+		  "storage": {
+		      "files": [
+			  {
+			      "path": "/var/resource/http",
+			      "contents": {
+				  "source": "http://10.0.2.100/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "path": "/var/resource/https",
+			      "contents": {
+				  "source": "https://10.0.2.100/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "path": "/var/resource/tftp",
+			      "contents": {
+				  "source": "tftp://10.0.2.2/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "path": "/var/resource/s3-path",
+			      "contents": {
+				  "source": "http://10.0.2.100:9000/rh-kola-fixtures/resources/anonymous"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "path": "/var/resource/original",
+			      "contents": {
+				  "source": "https://10.0.2.100/resources/versioned?versionId=Ym98GTx0npVaJznSAd0I1eUjFoZMP8Zo"
+			      },
+			      "mode": 420
+			  },
+			  {
+			      "path": "/var/resource/latest",
+			      "contents": {
+				  "source": "https://10.0.2.100/resources/versioned"
+			      },
+			      "mode": 420
+			  }
+		      ]
+		  }
+	      }`),
+	})
 	// TODO: once Ignition supports this on all channels/distros
 	//       this test should be rolled into coreos.ignition.resources.remote
 	// Test specifically for versioned s3 objects
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.resource.s3.versioned",
+		Packages:    []string{"ignition"},
 		Run:         resourceS3Versioned,
 		ClusterSize: 1,
 		Flags:       []register.Flag{register.RequiresInternetAccess},
@@ -368,6 +550,57 @@ func resourceS3Versioned(c cluster.TestCluster) {
 	})
 }
 
+func resourceLinkFlap(c cluster.TestCluster) {
+	m := c.Machines()[0]
+
+	checkResources(c, m, map[string]string{
+		"http": "kola-anonymous",
+	})
+}
+
+func resourceFixtures(c cluster.TestCluster) {
+	m := c.Machines()[0]
+
+	checkResources(c, m, map[string]string{
+		"http":     "kola-anonymous",
+		"https":    "kola-anonymous",
+		"tftp":     "kola-anonymous",
+		"s3-path":  "kola-anonymous",
+		"original": "original",
+		"latest":   "updated",
+		"config":   "kola-config",
+	})
+
+	// verify that the authenticated object is inaccessible anonymously
+	for _, url := range []string{
+		"http://10.0.2.100/resources/authenticated",
+		"http://10.0.2.100:9000/rh-kola-fixtures/resources/authenticated",
+	} {
+		_, _, err := m.SSH("curl -sf " + url)
+		if err == nil {
+			c.Fatalf("anonymously fetching %s should have failed, but did not", url)
+		}
+	}
+
+	// ...but that it is accessible with a signed request, as for s3://
+	// URLs on AWS
+	url, header, err := c.Fixtures.SignGuestRequest("resources/authenticated")
+	if err != nil {
+		c.Fatalf("signing request: %v", err)
+	}
+	args := []string{"curl", "-sf"}
+	for name, values := range header {
+		for _, value := range values {
+			args = append(args, "-H", name+": "+value)
+		}
+	}
+	args = append(args, url)
+	contents := c.MustSSH(m, shellquote.Join(args...))
+	if string(contents) != "kola-authenticated" {
+		c.Fatalf("authenticated: %q != %q", "kola-authenticated", contents)
+	}
+}
+
 func checkResources(c cluster.TestCluster, m platform.Machine, resources map[string]string) {
 	for filename, expectedContents := range resources {
 		contents := c.MustSSH(m, fmt.Sprintf("sudo cat /var/resource/%s", filename))
@@ -71,6 +71,7 @@ var (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.security.tls",
+		Packages:    []string{"ignition"},
 		Run:         securityTLS,
 		ClusterSize: 1,
 		NativeFuncs: map[string]register.NativeFuncWrap{
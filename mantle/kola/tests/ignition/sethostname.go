@@ -64,6 +64,7 @@ func init() {
 	// after the machine has booted.
 	register.RegisterTest(&register.Test{
 		Name:             "coreos.ignition.sethostname",
+		Packages:         []string{"ignition"},
 		Run:              setHostname,
 		ClusterSize:      1,
 		UserData:         configV2,
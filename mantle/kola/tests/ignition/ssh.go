@@ -24,6 +24,7 @@ func init() {
 	// without injecting via platform metadata
 	register.RegisterTest(&register.Test{
 		Name:             "coreos.ignition.ssh.key",
+		Packages:         []string{"ignition"},
 		Run:              empty,
 		ClusterSize:      1,
 		ExcludePlatforms: []string{"qemu"}, // redundant on qemu
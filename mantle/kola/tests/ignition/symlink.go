@@ -25,6 +25,7 @@ import (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.symlink",
+		Packages:    []string{"ignition"},
 		Run:         writeAbsoluteSymlink,
 		ClusterSize: 1,
 		Platforms:   []string{"qemu"},
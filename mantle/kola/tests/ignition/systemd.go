@@ -25,6 +25,7 @@ import (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.systemd.enable-service",
+		Packages:    []string{"ignition"},
 		Run:         enableSystemdService,
 		ClusterSize: 1,
 		Tags:        []string{"ignition"},
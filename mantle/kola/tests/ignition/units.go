@@ -23,6 +23,7 @@ import (
 func init() {
 	register.RegisterTest(&register.Test{
 		Name:        "coreos.ignition.instantiated.enable-unit",
+		Packages:    []string{"ignition"},
 		Run:         enableSystemdInstantiatedService,
 		ClusterSize: 1,
 		Tags:        []string{"ignition"},
@@ -37,24 +37,68 @@ func init() {
 		}
 	}`)
 
+	// On qemu-unpriv, the cloud tests run against emulated metadata
+	// services
 	register.RegisterTest(&register.Test{
-		Name:        "fcos.metadata.aws",
-		Run:         verifyAWS,
-		ClusterSize: 1,
-		Platforms:   []string{"aws"},
-		UserDataV3:  enableMetadataService,
-		Distros:     []string{"fcos"},
+		Name:            "fcos.metadata.aws",
+		Run:             verifyAWS,
+		ClusterSize:     1,
+		Platforms:       []string{"aws", "qemu-unpriv"},
+		EmulatePlatform: "aws",
+		UserDataV3:      enableMetadataService,
+		Distros:         []string{"fcos"},
 	})
 
 	register.RegisterTest(&register.Test{
-		Name:        "fcos.metadata.azure",
-		Run:         verifyAzure,
-		ClusterSize: 1,
-		Platforms:   []string{"azure"},
-		UserDataV3:  enableMetadataService,
-		Distros:     []string{"fcos"},
+		Name:            "fcos.metadata.azure",
+		Run:             verifyAzure,
+		ClusterSize:     1,
+		Platforms:       []string{"azure", "qemu-unpriv"},
+		EmulatePlatform: "azure",
+		UserDataV3:      enableMetadataService,
+		Distros:         []string{"fcos"},
+		// Ignition only finds the Azure provisioning disk on ATA
+		Architectures: []string{"x86_64"},
 	})
 
+	register.RegisterTest(&register.Test{
+		Name:            "fcos.metadata.gcp",
+		Run:             verifyGCP,
+		ClusterSize:     1,
+		Platforms:       []string{"gce", "qemu-unpriv"},
+		EmulatePlatform: "gcp",
+		UserDataV3:      enableMetadataService,
+		Distros:         []string{"fcos"},
+	})
+
+	register.RegisterTest(&register.Test{
+		Name:            "fcos.metadata.openstack",
+		Run:             verifyOpenStack,
+		ClusterSize:     1,
+		Platforms:       []string{"openstack", "qemu-unpriv"},
+		EmulatePlatform: "openstack",
+		UserDataV3:      enableMetadataService,
+		Distros:         []string{"fcos"},
+	})
+
+	// The SSH keys only come from the metadata service, so the harness
+	// can only log in if Afterburn installed them
+	for platform, emulated := range map[string]string{
+		"aws":       "aws",
+		"gce":       "gcp",
+		"openstack": "openstack",
+	} {
+		register.RegisterTest(&register.Test{
+			Name:            "fcos.metadata.sshkeys." + emulated,
+			Run:             verifySSHKeys,
+			ClusterSize:     1,
+			Platforms:       []string{platform, "qemu-unpriv"},
+			EmulatePlatform: emulated,
+			Flags:           []register.Flag{register.NoSSHKeyInUserData},
+			Distros:         []string{"fcos"},
+		})
+	}
+
 	register.RegisterTest(&register.Test{
 		Name:        "fcos.metadata.packet",
 		Run:         verifyPacket,
@@ -75,6 +119,14 @@ func verifyAzure(c cluster.TestCluster) {
 	// which is required for AFTERBURN_AZURE_IPV4_VIRTUAL to be present
 }
 
+func verifyGCP(c cluster.TestCluster) {
+	verify(c, "AFTERBURN_GCP_HOSTNAME", "AFTERBURN_GCP_IP_EXTERNAL_0", "AFTERBURN_GCP_IP_LOCAL_0", "AFTERBURN_GCP_MACHINE_TYPE")
+}
+
+func verifyOpenStack(c cluster.TestCluster) {
+	verify(c, "AFTERBURN_OPENSTACK_HOSTNAME", "AFTERBURN_OPENSTACK_INSTANCE_ID", "AFTERBURN_OPENSTACK_IPV4_LOCAL")
+}
+
 func verifyPacket(c cluster.TestCluster) {
 	verify(c, "AFTERBURN_PACKET_HOSTNAME", "AFTERBURN_PACKET_PHONE_HOME_URL", "AFTERBURN_PACKET_IPV4_PUBLIC_0", "AFTERBURN_PACKET_IPV4_PRIVATE_0", "AFTERBURN_PACKET_IPV6_PUBLIC_0")
 }
@@ -90,3 +142,12 @@ func verify(c cluster.TestCluster, keys ...string) {
 		}
 	}
 }
+
+func verifySSHKeys(c cluster.TestCluster) {
+	m := c.Machines()[0]
+
+	out := c.MustSSH(m, "cat /home/core/.ssh/authorized_keys.d/afterburn")
+	if len(strings.TrimSpace(string(out))) == 0 {
+		c.Errorf("Afterburn installed no SSH keys")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+	"github.com/coreos/mantle/util"
+)
+
+func init() {
+	register.RegisterTest(&register.Test{
+		Name:            "coreos.disk.faults.eio",
+		Run:             diskFaultEIO,
+		ClusterSize:     1,
+		Platforms:       []string{"qemu-unpriv"},
+		AdditionalDisks: []string{"1G"},
+		DiskFaults: map[int][]platform.DiskFault{
+			// writes covering the second MiB fail
+			0: {{Offsets: []int64{1 << 20}}},
+		},
+		Packages: []string{"kernel*"},
+	})
+	register.RegisterTest(&register.Test{
+		Name:        "coreos.multipath.path-flap",
+		Run:         multipathPathFlap,
+		ClusterSize: 1,
+		Platforms:   []string{"qemu-unpriv"},
+		// multipath-tools is only in the Ignition v3 distros
+		ExcludeDistros:  []string{"cl"},
+		AdditionalDisks: []string{"1G:mpath"},
+		UserDataV3: conf.Ignition(`{
+			"ignition": {"version": "3.0.0"},
+			"storage": {
+				"files": [{
+					"path": "/etc/multipath.conf",
+					"contents": {"source": "data:,defaults%20%7B%0A%20%20user_friendly_names%20yes%0A%20%20find_multipaths%20yes%0A%7D%0A"},
+					"mode": 420
+				}]
+			},
+			"systemd": {
+				"units": [{
+					"name": "multipathd.service",
+					"enabled": true
+				}]
+			}
+		}`),
+		// I/O in flight on an unplugged path is rejected before it is
+		// retried on the other one
+		SuppressConsoleRules: []string{"offline-device-io"},
+		Packages:             []string{"device-mapper-multipath", "kernel*"},
+	})
+}
+
+// diskFaultEIO checks that the faults injected into a disk fail the
+// guest's requests at the offsets they were set at, and no others.
+func diskFaultEIO(c cluster.TestCluster) {
+	m := c.Machines()[0]
+	dev := strings.TrimSpace(string(c.MustSSH(m, "readlink -f /dev/disk/by-id/virtio-disk1")))
+
+	c.MustSSHf(m, "sudo dd if=/dev/zero of=%s bs=4096 count=1 oflag=direct", dev)
+	if out, err := c.SSH(m, fmt.Sprintf("sudo dd if=/dev/zero of=%s bs=4096 seek=256 count=1 oflag=direct", dev)); err == nil {
+		c.Fatalf("write at the faulty offset succeeded: %s", out)
+	}
+	// only writes were made to fail
+	c.MustSSHf(m, "sudo dd if=%s of=/dev/null bs=4096 skip=256 count=1 iflag=direct", dev)
+
+	dmesg := c.MustSSH(m, "sudo dmesg")
+	if !regexp.MustCompile(fmt.Sprintf(`I/O error, dev %s, sector 2048\b`, regexp.QuoteMeta(filepath.Base(dev)))).Match(dmesg) {
+		c.Fatalf("no I/O error reported for sector 2048 of %s", dev)
+	}
+}
+
+// multipathPathFlap unplugs each path of a multipath disk in turn while a
+// filesystem on it is in use, and checks that the data written through
+// either path survives.
+func multipathPathFlap(c cluster.TestCluster) {
+	m := c.Machines()[0]
+
+	var mpath string
+	if err := util.Retry(30, 2*time.Second, func() error {
+		// the map holding the paths of the disk with serial disk1
+		out, err := c.SSH(m, `for dev in $(lsblk -dnro NAME,SERIAL | awk '$2 == "disk1" {print $1}'); do sudo lsblk -nro NAME,TYPE /dev/$dev | awk '$2 == "mpath" {print $1}'; done | sort -u`)
+		if err != nil {
+			return err
+		}
+		mpath = strings.TrimSpace(string(out))
+		if mpath == "" || strings.Contains(mpath, "\n") {
+			return fmt.Errorf("got multipath maps %q", mpath)
+		}
+		return nil
+	}); err != nil {
+		c.Fatalf("Finding the multipath map of disk1: %v", err)
+	}
+	waitForPaths(c, m, mpath, 2)
+
+	c.MustSSHf(m, "sudo mkfs.xfs -q /dev/mapper/%[1]s && sudo mkdir -p /var/mnt/mpath && sudo mount /dev/mapper/%[1]s /var/mnt/mpath", mpath)
+	c.MustSSH(m, "echo before | sudo tee /var/mnt/mpath/before >/dev/null && sudo sync -f /var/mnt/mpath/before")
+
+	c.MustUnplugDiskPath(m, "disk1", 0)
+	waitForPaths(c, m, mpath, 1)
+	c.MustSSH(m, "echo during | sudo tee /var/mnt/mpath/during >/dev/null && sudo sync -f /var/mnt/mpath/during")
+	c.MustPlugDiskPath(m, "disk1", 0)
+	waitForPaths(c, m, mpath, 2)
+
+	// read everything back through the path which was unplugged
+	c.MustUnplugDiskPath(m, "disk1", 1)
+	waitForPaths(c, m, mpath, 1)
+	out := c.MustSSH(m, "sudo sh -c 'echo 3 > /proc/sys/vm/drop_caches' && cat /var/mnt/mpath/before /var/mnt/mpath/during")
+	if string(out) != "before\nduring" {
+		c.Errorf("read back %q, expected \"before\\nduring\"", out)
+	}
+	c.MustPlugDiskPath(m, "disk1", 1)
+	waitForPaths(c, m, mpath, 2)
+}
+
+// waitForPaths waits for the multipath map mpath to have n active paths.
+func waitForPaths(c cluster.TestCluster, m platform.Machine, mpath string, n int) {
+	if err := util.Retry(30, 2*time.Second, func() error {
+		out, err := c.SSH(m, fmt.Sprintf("sudo multipath -ll %s | grep -c 'active ready running' || true", mpath))
+		if err != nil {
+			return err
+		}
+		active, err := strconv.Atoi(strings.TrimSpace(string(out)))
+		if err != nil {
+			return err
+		}
+		if active != n {
+			return fmt.Errorf("%d active paths", active)
+		}
+		return nil
+	}); err != nil {
+		c.Fatalf("Waiting for %d active paths of %s: %v", n, mpath, err)
+	}
+}
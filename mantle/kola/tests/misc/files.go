@@ -28,6 +28,7 @@ func init() {
 		ClusterSize: 1,
 		Name:        "fcos.filesystem",
 		Distros:     []string{"fcos"},
+		Flags:       []register.Flag{register.NonDestructive},
 	})
 }
 
@@ -0,0 +1,40 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+)
+
+func init() {
+	register.RegisterTest(&register.Test{
+		Run:         BootMetrics,
+		ClusterSize: 1,
+		Name:        "coreos.metrics.boot",
+		// Catch boots hanging on a timeout, not slow ones; those are
+		// for --metrics-baseline
+		Thresholds: []register.Threshold{
+			{Metric: "boot-time", Max: 300},
+		},
+	})
+}
+
+// BootMetrics records the boot time, Ignition duration, memory usage and
+// /sysroot usage of a freshly provisioned machine so that they can be
+// tracked across builds with --metrics-baseline.
+func BootMetrics(c cluster.TestCluster) {
+	c.RecordBootMetrics(c.Machines()[0])
+}
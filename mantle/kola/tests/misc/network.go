@@ -17,6 +17,7 @@ package misc
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -46,6 +47,13 @@ func init() {
 		ExcludePlatforms: []string{"do"},
 		ExcludeDistros:   []string{"fcos", "rhcos"},
 	})
+	register.RegisterTest(&register.Test{
+		Run:         NetworkLinkFlap,
+		ClusterSize: 1,
+		Name:        "coreos.network.link-flap",
+		Flags:       []register.Flag{register.InjectsNetworkFaults},
+		Platforms:   []string{"qemu-unpriv"},
+	})
 }
 
 type listener struct {
@@ -170,3 +178,34 @@ func NetworkInitramfsSecondBoot(c cluster.TestCluster) {
 		c.Fatal("networkd started in initramfs")
 	}
 }
+
+// NetworkLinkFlap takes the link of the machine down and back up, and
+// checks that the guest noticed and is reachable again.
+func NetworkLinkFlap(c cluster.TestCluster) {
+	m := c.Machines()[0]
+
+	iface := strings.TrimSpace(string(c.MustSSH(m, `ip -o route get 10.0.2.2 | awk '{for (i = 1; i < NF; i++) if ($i == "dev") print $(i+1)}'`)))
+	carrierDowns := func() int {
+		out := c.MustSSHf(m, "cat /sys/class/net/%s/carrier_down_count", iface)
+		n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+		if err != nil {
+			c.Fatalf("parsing carrier_down_count of %s: %v", iface, err)
+		}
+		return n
+	}
+	before := carrierDowns()
+
+	c.MustSetLinkUp(m, false)
+	time.Sleep(5 * time.Second)
+	c.MustSetLinkUp(m, true)
+
+	if err := util.Retry(12, 5*time.Second, func() error {
+		_, err := c.SSH(m, "true")
+		return err
+	}); err != nil {
+		c.Fatalf("machine unreachable after the link came back up: %v", err)
+	}
+	if after := carrierDowns(); after <= before {
+		c.Errorf("%s lost its carrier %d times, expected more than %d", iface, after, before)
+	}
+}
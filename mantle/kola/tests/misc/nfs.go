@@ -54,11 +54,11 @@ func init() {
 		ClusterSize:    0,
 		Name:           "linux.nfs.v4",
 		ExcludeDistros: []string{"fcos"},
+		Packages:       []string{"nfs-utils", "kernel*"},
 
 		// Disabled on Azure because setting hostname
 		// is required at the instance creation level
-		// qemu-unpriv machines cannot communicate
-		ExcludePlatforms: []string{"azure", "qemu-unpriv"},
+		ExcludePlatforms: []string{"azure"},
 	})
 }
 
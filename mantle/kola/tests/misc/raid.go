@@ -68,12 +68,14 @@ func init() {
 		ClusterSize: 0,
 		Platforms:   []string{"qemu"},
 		Name:        "cl.disk.raid.root",
+		Packages:    []string{"mdadm", "kernel*"},
 		Distros:     []string{"cl"},
 	})
 	register.RegisterTest(&register.Test{
 		Run:         DataOnRaid,
 		ClusterSize: 1,
 		Name:        "cl.disk.raid.data",
+		Packages:    []string{"mdadm", "kernel*"},
 		UserData: conf.ContainerLinuxConfig(`storage:
   raid:
     - name: "DATA"
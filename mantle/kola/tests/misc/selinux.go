@@ -29,11 +29,13 @@ func init() {
 		Run:         SelinuxEnforce,
 		ClusterSize: 1,
 		Name:        "coreos.selinux.enforce",
+		Flags:       []register.Flag{register.NonDestructive},
 	})
 	register.RegisterTest(&register.Test{
 		Run:         SelinuxBoolean,
 		ClusterSize: 1,
 		Name:        "coreos.selinux.boolean",
+		Flags:       []register.Flag{register.NonDestructive},
 	})
 	register.RegisterTest(&register.Test{
 		Run:         SelinuxBooleanPersist,
@@ -129,6 +131,7 @@ func SelinuxEnforce(c cluster.TestCluster) {
 		{"systemctl --no-pager is-active system.slice", true, "active"},
 		{"sudo cp /etc/selinux/config{,.old}", false, ""},
 		{"sudo sed -i 's/SELINUX=permissive/SELINUX=enforcing/' /etc/selinux/config", false, ""},
+		{"sudo mv /etc/selinux/config{.old,}", false, ""},
 	}
 
 	m := c.Machines()[0]
@@ -39,6 +39,7 @@ func init() {
 		Run:         podmanBaseTest,
 		ClusterSize: 1,
 		Name:        `podman.base`,
+		Packages:    []string{"podman"},
 	})
 	// These remaining tests use networking, and hence don't work reliably on RHCOS
 	// right now due to due to https://bugzilla.redhat.com/show_bug.cgi?id=1757572
@@ -46,6 +47,7 @@ func init() {
 		Run:         podmanWorkflow,
 		ClusterSize: 1,
 		Name:        `podman.workflow`,
+		Packages:    []string{"podman"},
 		Flags:       []register.Flag{register.RequiresInternetAccess}, // For pulling nginx
 		Distros:     []string{"fcos"},
 		FailFast:    true,
@@ -54,6 +56,7 @@ func init() {
 		Run:         podmanNetworksReliably,
 		ClusterSize: 1,
 		Name:        `podman.network-single`,
+		Packages:    []string{"podman"},
 		// Not really but podman blows up if there's no /etc/resolv.conf
 		Tags:    []string{kola.NeedsInternetTag},
 		Distros: []string{"fcos"},
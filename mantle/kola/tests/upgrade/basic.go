@@ -42,29 +42,35 @@ func init() {
 	register.RegisterUpgradeTest(&register.Test{
 		Run:         fcosUpgradeBasic,
 		ClusterSize: 1,
-		// if renaming this, also rename the command in kolet-httpd.service below
-		Name:     "fcos.upgrade.basic",
-		FailFast: true,
+		Name:        "fcos.upgrade.basic",
+		FailFast:    true,
 		NativeFuncs: map[string]register.NativeFuncWrap{
 			"httpd": register.CreateNativeFuncWrap(httpd),
 		},
-		Tags:    []string{"upgrade"},
-		Distros: []string{"fcos"},
-		// This Ignition does a few things:
-		// 1. bumps Zincati verbosity
-		// 2. auto-runs httpd once kolet is scp'ed
-		// 3. changes the Zincati config to point to localhost:8080 so we'll be
-		//    able to feed the update graph we want
-		// 4. always start with Zincati updates disabled so we can finish
-		//    setting it up here before enabling it again without risking race
-		//    conditions
-		// 5. change the OSTree remote to localhost:8080
-		// We could use file:/// to simplify things though using a URL at least
-		// exercises the ostree/libcurl stack.
-		// We use strings.Replace here because fmt.Sprintf would try to
-		// interpret the percent signs and there's too many of them to be worth
-		// escaping.
-		UserDataV3: conf.Ignition(strings.Replace(`{
+		Tags:       []string{"upgrade"},
+		Distros:    []string{"fcos"},
+		UserDataV3: upgradeUserData("fcos.upgrade.basic"),
+	})
+}
+
+// upgradeUserData returns the config of the machine of the upgrade test
+// name, which must have the httpd native function.
+func upgradeUserData(name string) *conf.UserData {
+	// This Ignition does a few things:
+	// 1. bumps Zincati verbosity
+	// 2. auto-runs httpd once kolet is scp'ed
+	// 3. changes the Zincati config to point to localhost:8080 so we'll be
+	//    able to feed the update graph we want
+	// 4. always start with Zincati updates disabled so we can finish
+	//    setting it up here before enabling it again without risking race
+	//    conditions
+	// 5. change the OSTree remote to localhost:8080
+	// We could use file:/// to simplify things though using a URL at least
+	// exercises the ostree/libcurl stack.
+	// We use a strings.Replacer here because fmt.Sprintf would try to
+	// interpret the percent signs and there's too many of them to be worth
+	// escaping.
+	return conf.Ignition(strings.NewReplacer("OSTREE_REPO", ostreeRepo, "TEST_NAME", name).Replace(`{
   "ignition": { "version": "3.0.0" },
   "systemd": {
     "units": [
@@ -82,7 +88,7 @@ func init() {
       },
       {
         "name": "kolet-httpd.service",
-        "contents": "[Service]\nExecStart=/var/home/core/kolet run fcos.upgrade.basic httpd -v\n[Install]\nWantedBy=multi-user.target"
+        "contents": "[Service]\nExecStart=/var/home/core/kolet run TEST_NAME httpd -v\n[Install]\nWantedBy=multi-user.target"
       }
     ]
   },
@@ -115,8 +121,7 @@ func init() {
       }
     ]
   }
-}`, "OSTREE_REPO", ostreeRepo, -1)),
-	})
+}`))
 }
 
 // upgradeFromPrevious verifies that the previous build is capable of upgrading
@@ -126,47 +131,11 @@ func fcosUpgradeBasic(c cluster.TestCluster) {
 	graph := new(Graph)
 
 	c.Run("setup", func(c cluster.TestCluster) {
-		// this is the only heavy-weight part, though remember this test is
-		// optimized for qemu testing locally where this won't leave localhost at
-		// all. cloud testing should mostly be a pipeline thing, where the infra
-		// connection should be much faster
-		ostreeTarPath := filepath.Join(kola.CosaBuild.Dir, kola.CosaBuild.Meta.BuildArtifacts.Ostree.Path)
-		if err := cluster.DropFile(c.Machines(), ostreeTarPath); err != nil {
-			c.Fatal(err)
-		}
-
-		// XXX: Note the '&& sync' here; this is to work around sysroot
-		// remounting in libostree forcing a cache flush and blocking D-Bus.
-		// Should drop this once we fix it more properly in {rpm-,}ostree.
-		// https://github.com/coreos/coreos-assembler/issues/1301
-		c.MustSSHf(m, "tar -xf %s -C %s && sync", kola.CosaBuild.Meta.BuildArtifacts.Ostree.Path, ostreeRepo)
-
-		// disable zincati; from now on, we'll start it manually whenenever we
-		// want to upgrade via Zincati
-		c.MustSSH(m, "sudo systemctl disable --now --quiet zincati.service")
-		c.MustSSH(m, "sudo rm /etc/zincati/config.d/99-updates.toml")
-		// delete what mantle adds (XXX: should just opt out of this upfront)
-		c.MustSSH(m, "sudo rm /etc/zincati/config.d/90-disable-auto-updates.toml")
-
+		setupRepo(c, m)
 	})
 
 	c.Run("upgrade-from-previous", func(c cluster.TestCluster) {
-		// We need to check now whether this is a within-stream update or a
-		// cross-stream rebase.
-		d, err := util.GetBootedDeployment(c, m)
-		if err != nil {
-			c.Fatal(err)
-		}
-		if strings.HasSuffix(d.Origin, ":"+kola.CosaBuild.Meta.BuildRef) {
-			// same stream; let's use Zincati
-			graph.seedFromMachine(c, m)
-			graph.addUpdate(c, m, kola.CosaBuild.Meta.OstreeVersion, kola.CosaBuild.Meta.OstreeCommit)
-			waitForUpgradeToVersion(c, m, kola.CosaBuild.Meta.OstreeVersion)
-		} else {
-			rebaseToStream(c, m, kola.CosaBuild.Meta.BuildRef, kola.CosaBuild.Meta.OstreeVersion)
-			// and from now on we can use Zincati, so seed the graph with the new node
-			graph.seedFromMachine(c, m)
-		}
+		upgradeToBuild(c, m, graph)
 	})
 
 	// Now, synthesize an update and serve that -- this is similar to
@@ -185,6 +154,53 @@ func fcosUpgradeBasic(c cluster.TestCluster) {
 	})
 }
 
+// setupRepo serves the OSTree content of the build under test from the
+// machine and stops Zincati, which is started manually to upgrade.
+func setupRepo(c cluster.TestCluster, m platform.Machine) {
+	// this is the only heavy-weight part, though remember this test is
+	// optimized for qemu testing locally where this won't leave localhost at
+	// all. cloud testing should mostly be a pipeline thing, where the infra
+	// connection should be much faster
+	ostreeTarPath := filepath.Join(kola.CosaBuild.Dir, kola.CosaBuild.Meta.BuildArtifacts.Ostree.Path)
+	if err := cluster.DropFile(c.Machines(), ostreeTarPath); err != nil {
+		c.Fatal(err)
+	}
+
+	// XXX: Note the '&& sync' here; this is to work around sysroot
+	// remounting in libostree forcing a cache flush and blocking D-Bus.
+	// Should drop this once we fix it more properly in {rpm-,}ostree.
+	// https://github.com/coreos/coreos-assembler/issues/1301
+	c.MustSSHf(m, "tar -xf %s -C %s && sync", kola.CosaBuild.Meta.BuildArtifacts.Ostree.Path, ostreeRepo)
+
+	// disable zincati; from now on, we'll start it manually whenenever we
+	// want to upgrade via Zincati
+	c.MustSSH(m, "sudo systemctl disable --now --quiet zincati.service")
+	c.MustSSH(m, "sudo rm /etc/zincati/config.d/99-updates.toml")
+	// delete what mantle adds (XXX: should just opt out of this upfront)
+	c.MustSSH(m, "sudo rm /etc/zincati/config.d/90-disable-auto-updates.toml")
+}
+
+// upgradeToBuild upgrades the machine to the build under test, through
+// Zincati if it is on the same stream and by rebasing otherwise.
+func upgradeToBuild(c cluster.TestCluster, m platform.Machine, graph *Graph) {
+	// We need to check now whether this is a within-stream update or a
+	// cross-stream rebase.
+	d, err := util.GetBootedDeployment(c, m)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if strings.HasSuffix(d.Origin, ":"+kola.CosaBuild.Meta.BuildRef) {
+		// same stream; let's use Zincati
+		graph.seedFromMachine(c, m)
+		graph.addUpdate(c, m, kola.CosaBuild.Meta.OstreeVersion, kola.CosaBuild.Meta.OstreeCommit)
+		waitForUpgradeToVersion(c, m, kola.CosaBuild.Meta.OstreeVersion)
+	} else {
+		rebaseToStream(c, m, kola.CosaBuild.Meta.BuildRef, kola.CosaBuild.Meta.OstreeVersion)
+		// and from now on we can use Zincati, so seed the graph with the new node
+		graph.seedFromMachine(c, m)
+	}
+}
+
 // Should dedupe this with fedora-coreos-cincinnati -- we just handle the
 // bare minimum here. One question here is: why not use Cincinnati itself for
 // this? We could do this, though it'd somewhat muddle the focus of these tests
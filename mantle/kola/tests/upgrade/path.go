@@ -0,0 +1,119 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package upgrade
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/mantle/kola"
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/kola/tests/util"
+	"github.com/coreos/mantle/platform"
+)
+
+// upgradePathRemote is the remote of the local repo releases of the upgrade
+// path are pulled from
+const upgradePathRemote = "upgrade-path"
+
+func init() {
+	register.RegisterUpgradeTest(&register.Test{
+		Run:         fcosUpgradePath,
+		ClusterSize: 1,
+		Name:        "fcos.upgrade.path",
+		FailFast:    true,
+		NativeFuncs: map[string]register.NativeFuncWrap{
+			"httpd": register.CreateNativeFuncWrap(httpd),
+		},
+		Tags:       []string{"upgrade", kola.NeedsInternetTag},
+		Distros:    []string{"fcos"},
+		UserDataV3: upgradeUserData("fcos.upgrade.path"),
+	})
+}
+
+// hopChecks run after each hop of an upgrade path.
+var hopChecks = []struct {
+	name  string
+	check func(c cluster.TestCluster, m platform.Machine)
+}{
+	{"failed-units", func(c cluster.TestCluster, m platform.Machine) {
+		out := strings.TrimSpace(string(c.MustSSH(m, "systemctl --failed --no-legend --plain")))
+		if out != "" {
+			c.Fatalf("failed units after upgrade:\n%s", out)
+		}
+	}},
+	{"rpm-ostree-status", func(c cluster.TestCluster, m platform.Machine) {
+		if _, err := util.GetBootedDeployment(c, m); err != nil {
+			c.Fatal(err)
+		}
+	}},
+	{"zincati", func(c cluster.TestCluster, m platform.Machine) {
+		// zincati is started to upgrade, and should come back up after reboot
+		c.MustSSH(m, "sudo systemctl start zincati.service")
+		getZincatiMetrics(c, m)
+	}},
+}
+
+// fcosUpgradePath upgrades from the oldest release of kola.UpgradePath
+// through each of the others to the build under test, running the hop
+// checks after every hop. Each hop is a subtest.
+func fcosUpgradePath(c cluster.TestCluster) {
+	if len(kola.UpgradePath) == 0 {
+		c.Skip("no upgrade path given with --upgrade-path or --upgrade-graph")
+	}
+	m := c.Machines()[0]
+	graph := new(Graph)
+
+	c.Run("setup", func(c cluster.TestCluster) {
+		setupRepo(c, m)
+		c.MustSSHf(m, "ostree remote add --repo=%s --no-gpg-verify %s %s", ostreeRepo, upgradePathRemote, kola.UpgradeOstreeURL)
+
+		d, err := util.GetBootedDeployment(c, m)
+		if err != nil {
+			c.Fatal(err)
+		}
+		if d.Version != kola.UpgradePath[0].Version {
+			c.Fatalf("expected to boot %s, the start of the upgrade path, but got %s", kola.UpgradePath[0].Version, d.Version)
+		}
+		graph.seedFromMachine(c, m)
+	})
+
+	from := kola.UpgradePath[0].Version
+	for _, node := range kola.UpgradePath[1:] {
+		node := node
+		c.Run(fmt.Sprintf("%s-to-%s", from, node.Version), func(c cluster.TestCluster) {
+			c.MustSSHf(m, "ostree pull --repo=%s --depth=0 %s %s", ostreeRepo, upgradePathRemote, node.Payload)
+			graph.addUpdate(c, m, node.Version, node.Payload)
+			waitForUpgradeToVersion(c, m, node.Version)
+			runHopChecks(c, m)
+		})
+		from = node.Version
+	}
+
+	c.Run(fmt.Sprintf("%s-to-%s", from, kola.CosaBuild.Meta.OstreeVersion), func(c cluster.TestCluster) {
+		upgradeToBuild(c, m, graph)
+		runHopChecks(c, m)
+	})
+}
+
+func runHopChecks(c cluster.TestCluster, m platform.Machine) {
+	for _, hc := range hopChecks {
+		hc := hc
+		c.Run(hc.name, func(c cluster.TestCluster) {
+			hc.check(c, m)
+		})
+	}
+}
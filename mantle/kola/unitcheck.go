@@ -0,0 +1,247 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/network/journal"
+	"github.com/coreos/mantle/platform"
+)
+
+// coredumpMessageID is the journal MESSAGE_ID of systemd-coredump's
+// reports of crashes.
+const coredumpMessageID = "fc2e22bc6ee647b6b90729ab34a250b1"
+
+// unitLogLines is how many lines of its log a unit failure includes.
+const unitLogLines = 10
+
+// unitCheckTimeout bounds each command of the check.
+const unitCheckTimeout = time.Minute
+
+// UnitFailure is a systemd unit found failed on a machine after a test.
+type UnitFailure struct {
+	Machine string
+	Unit    string
+	Result  string   // e.g. "exit-code", "timeout" or "core-dump"
+	Status  string   // e.g. "1" or "SEGV", if the main process exited
+	Log     []string // the last lines the unit logged in the current boot
+}
+
+func (f *UnitFailure) Error() string {
+	msg := fmt.Sprintf("unit %s failed on machine %s with result %q", f.Unit, f.Machine, f.Result)
+	if f.Status != "" && f.Status != "0" {
+		msg += fmt.Sprintf(", status %s", f.Status)
+	}
+	if len(f.Log) > 0 {
+		msg += ":\n    " + strings.Join(f.Log, "\n    ")
+	}
+	return msg
+}
+
+// Coredump is a crash systemd-coredump recorded on a machine.
+type Coredump struct {
+	Machine string
+	PID     string
+	Exe     string
+	Signal  string // e.g. "SIGSEGV"
+	Unit    string // the unit the process belonged to, if any
+}
+
+func (c *Coredump) Error() string {
+	msg := fmt.Sprintf("%s[%s] dumped core with %s on machine %s", c.Exe, c.PID, c.Signal, c.Machine)
+	if c.Unit != "" {
+		msg += fmt.Sprintf(" (unit %s)", c.Unit)
+	}
+	return msg
+}
+
+// matchesAny reports whether s matches one of the glob patterns.
+func matchesAny(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if match, _ := path.Match(pattern, s); match {
+			return true
+		}
+	}
+	return false
+}
+
+// runCheck runs argv on m and returns its stdout, failing on a nonzero
+// exit status.
+func runCheck(m platform.Machine, argv ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	res, err := m.Exec(context.Background(), platform.ExecRequest{
+		Argv:    argv,
+		Sudo:    true,
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+		Timeout: unitCheckTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !res.Success() {
+		return nil, fmt.Errorf("%s %s: %s", argv[0], res, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// FailedUnits returns the systemd units which are failed on m.
+func FailedUnits(m platform.Machine) ([]*UnitFailure, error) {
+	out, err := runCheck(m, "systemctl", "list-units", "--state=failed", "--no-legend", "--plain", "--all", "--no-pager")
+	if err != nil {
+		return nil, err
+	}
+	var failures []*UnitFailure
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		f := &UnitFailure{
+			Machine: m.ID(),
+			Unit:    fields[0],
+		}
+		show, err := runCheck(m, "systemctl", "show", "--property=Result,ExecMainStatus", f.Unit)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(show), "\n") {
+			switch {
+			case strings.HasPrefix(line, "Result="):
+				f.Result = strings.TrimPrefix(line, "Result=")
+			case strings.HasPrefix(line, "ExecMainStatus="):
+				f.Status = strings.TrimPrefix(line, "ExecMainStatus=")
+			}
+		}
+		log, err := runCheck(m, "journalctl", "-b", "-u", f.Unit, "-n", fmt.Sprint(unitLogLines), "-o", "cat", "--no-pager", "-q")
+		if err != nil {
+			return nil, err
+		}
+		if log := strings.TrimSpace(string(log)); log != "" {
+			f.Log = strings.Split(log, "\n")
+		}
+		failures = append(failures, f)
+	}
+	return failures, scanner.Err()
+}
+
+// Coredumps returns the crashes systemd-coredump recorded on m, after the
+// journal cursor if it is not empty.
+func Coredumps(m platform.Machine, cursor string) ([]*Coredump, error) {
+	argv := []string{"journalctl", "-q", "--no-pager", "-o", "export", "MESSAGE_ID=" + coredumpMessageID}
+	if cursor != "" {
+		argv = append(argv, "--after-cursor", cursor)
+	}
+	out, err := runCheck(m, argv...)
+	if err != nil {
+		return nil, err
+	}
+	return parseCoredumps(m.ID(), out)
+}
+
+// parseCoredumps returns the crashes recorded in the journal export out of
+// machine id.
+func parseCoredumps(id string, out []byte) ([]*Coredump, error) {
+	var dumps []*Coredump
+	reader := journal.NewExportReader(bytes.NewReader(out))
+	for {
+		entry, err := reader.ReadEntry()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		c := &Coredump{
+			Machine: id,
+			PID:     string(entry["COREDUMP_PID"]),
+			Exe:     string(entry["COREDUMP_EXE"]),
+			Signal:  string(entry["COREDUMP_SIGNAL_NAME"]),
+			Unit:    string(entry[journal.FIELD_COREDUMP_UNIT]),
+		}
+		if c.Exe == "" {
+			c.Exe = string(entry["COREDUMP_COMM"])
+		}
+		if c.Signal == "" {
+			c.Signal = "signal " + string(entry["COREDUMP_SIGNAL"])
+		}
+		dumps = append(dumps, c)
+	}
+	return dumps, nil
+}
+
+// expectsFailure reports whether t expects f.
+func expectsFailure(t *register.Test, f *UnitFailure) bool {
+	return matchesAny(f.Unit, t.ExpectedFailedUnits)
+}
+
+// expectsCoredump reports whether t expects c, matching its executable by
+// path or name.
+func expectsCoredump(t *register.Test, c *Coredump) bool {
+	return matchesAny(c.Exe, t.ExpectedCoredumps) || matchesAny(path.Base(c.Exe), t.ExpectedCoredumps)
+}
+
+// checkUnits fails h for each failed systemd unit and crash on the
+// machines which t doesn't expect. cursor is as for Coredumps.
+func checkUnits(h *harness.H, t *register.Test, machines []platform.Machine, cursor string) {
+	if AllowFailedUnits {
+		return
+	}
+	for _, m := range machines {
+		// Tests may leave machines down on purpose
+		client, err := m.SSHClient()
+		if err != nil {
+			h.Logf("Not checking units on unreachable machine %s: %v", m.ID(), err)
+			continue
+		}
+		client.Close()
+
+		failures, err := FailedUnits(m)
+		if err != nil {
+			h.Errorf("Checking failed units on machine %s: %v", m.ID(), err)
+			continue
+		}
+		for _, f := range failures {
+			if expectsFailure(t, f) {
+				h.Logf("Expected failure: %v", f)
+			} else {
+				h.Error(f)
+			}
+		}
+
+		dumps, err := Coredumps(m, cursor)
+		if err != nil {
+			h.Errorf("Checking coredumps on machine %s: %v", m.ID(), err)
+			continue
+		}
+		for _, c := range dumps {
+			if expectsCoredump(t, c) {
+				h.Logf("Expected crash: %v", c)
+			} else {
+				h.Error(c)
+			}
+		}
+	}
+}
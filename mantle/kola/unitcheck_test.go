@@ -0,0 +1,97 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestExpectsFailure(t *testing.T) {
+	test := &register.Test{
+		ExpectedFailedUnits: []string{"zincati.service", "kola-*.service"},
+	}
+	for unit, expected := range map[string]bool{
+		"zincati.service":      true,
+		"kola-runext.service":  true,
+		"kola-runext.socket":   false,
+		"sshd.service":         false,
+		"zincati.service.d":    false,
+		"foo-zincati.service":  false,
+		"kola-/nested.service": false,
+	} {
+		if got := expectsFailure(test, &UnitFailure{Unit: unit}); got != expected {
+			t.Errorf("%s: expected %v", unit, expected)
+		}
+	}
+
+	if expectsFailure(&register.Test{}, &UnitFailure{Unit: "zincati.service"}) {
+		t.Errorf("failure expected without patterns")
+	}
+}
+
+func TestExpectsCoredump(t *testing.T) {
+	test := &register.Test{
+		ExpectedCoredumps: []string{"/usr/bin/rpm-ostree", "bash", "python*"},
+	}
+	for exe, expected := range map[string]bool{
+		"/usr/bin/rpm-ostree":     true,
+		"/usr/bin/bash":           true,
+		"/usr/bin/python3.9":      true,
+		"bash":                    true,
+		"/usr/libexec/rpm-ostree": false,
+		"/usr/bin/zincati":        false,
+	} {
+		if got := expectsCoredump(test, &Coredump{Exe: exe}); got != expected {
+			t.Errorf("%s: expected %v", exe, expected)
+		}
+	}
+}
+
+func TestParseCoredumps(t *testing.T) {
+	out := []byte(`__CURSOR=s=1;i=1
+MESSAGE_ID=fc2e22bc6ee647b6b90729ab34a250b1
+COREDUMP_PID=700
+COREDUMP_EXE=/usr/bin/zincati
+COREDUMP_SIGNAL_NAME=SIGSEGV
+COREDUMP_UNIT=zincati.service
+
+__CURSOR=s=1;i=2
+MESSAGE_ID=fc2e22bc6ee647b6b90729ab34a250b1
+COREDUMP_PID=701
+COREDUMP_COMM=kolet
+COREDUMP_SIGNAL=6
+
+`)
+	dumps, err := parseCoredumps("m1", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Coredump{
+		{Machine: "m1", PID: "700", Exe: "/usr/bin/zincati", Signal: "SIGSEGV", Unit: "zincati.service"},
+		{Machine: "m1", PID: "701", Exe: "kolet", Signal: "signal 6"},
+	}
+	if !reflect.DeepEqual(dumps, expected) {
+		for _, c := range dumps {
+			t.Logf("got %+v", *c)
+		}
+		t.Errorf("unexpected coredumps")
+	}
+	if s := dumps[0].Error(); s != "/usr/bin/zincati[700] dumped core with SIGSEGV on machine m1 (unit zincati.service)" {
+		t.Errorf("got message %q", s)
+	}
+}
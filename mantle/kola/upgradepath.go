@@ -0,0 +1,193 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ageIndexKey is the Cincinnati node metadata key ordering FCOS releases
+const ageIndexKey = "org.fedoraproject.coreos.releases.age_index"
+
+// UpgradeNode is a release on an upgrade path. Payload is its OSTree
+// commit checksum.
+type UpgradeNode struct {
+	Version string
+	Payload string
+}
+
+// UpgradeGraph is a Cincinnati update graph.
+type UpgradeGraph struct {
+	Nodes []struct {
+		Version  string            `json:"version"`
+		Payload  string            `json:"payload"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"nodes"`
+	Edges [][2]int `json:"edges"`
+}
+
+// LoadUpgradeGraph reads a Cincinnati graph from a file or an http(s) URL
+// and returns the shortest upgrade path from its oldest to its newest
+// release, by age index if the nodes have one and by position otherwise.
+func LoadUpgradeGraph(location string) ([]UpgradeNode, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		var resp *http.Response
+		resp, err = http.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: %s", location, resp.Status)
+		}
+		data, err = ioutil.ReadAll(resp.Body)
+	} else {
+		data, err = ioutil.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var graph UpgradeGraph
+	if err := json.Unmarshal(data, &graph); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", location)
+	}
+	path, err := graph.Path()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s", location)
+	}
+	return path, nil
+}
+
+// Path returns the shortest path from the oldest to the newest node of the
+// graph.
+func (g *UpgradeGraph) Path() ([]UpgradeNode, error) {
+	n := len(g.Nodes)
+	if n == 0 {
+		return nil, fmt.Errorf("graph has no nodes")
+	}
+	age := make([]int, n)
+	for i, node := range g.Nodes {
+		age[i] = i
+		if s, ok := node.Metadata[ageIndexKey]; ok {
+			a, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing age index of %s", node.Version)
+			}
+			age[i] = a
+		}
+	}
+	oldest, newest := 0, 0
+	for i := range g.Nodes {
+		if age[i] < age[oldest] {
+			oldest = i
+		}
+		if age[i] > age[newest] {
+			newest = i
+		}
+	}
+
+	// breadth-first search from the oldest node
+	prev := make([]int, n)
+	for i := range prev {
+		prev[i] = -1
+	}
+	prev[oldest] = oldest
+	queue := []int{oldest}
+	for len(queue) > 0 && prev[newest] < 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Edges {
+			if e[0] < 0 || e[0] >= n || e[1] < 0 || e[1] >= n {
+				return nil, fmt.Errorf("edge %v refers to a missing node", e)
+			}
+			if e[0] == cur && prev[e[1]] < 0 {
+				prev[e[1]] = cur
+				queue = append(queue, e[1])
+			}
+		}
+	}
+	if prev[newest] < 0 {
+		return nil, fmt.Errorf("no upgrade path from %s to %s", g.Nodes[oldest].Version, g.Nodes[newest].Version)
+	}
+
+	var path []UpgradeNode
+	for i := newest; ; i = prev[i] {
+		node := g.Nodes[i]
+		path = append([]UpgradeNode{{Version: node.Version, Payload: node.Payload}}, path...)
+		if i == oldest {
+			break
+		}
+	}
+	return path, nil
+}
+
+// TrimUpgradePath drops the build under test, of the given version, from
+// the end of path, and checks that the releases left are older than it.
+func TrimUpgradePath(path []UpgradeNode, version string) ([]UpgradeNode, error) {
+	n := len(path)
+	if n > 0 && path[n-1].Version == version {
+		path = path[:n-1]
+		n--
+	}
+	if n == 0 {
+		return nil, errors.New("upgrade path has no release before the build under test")
+	}
+	if last := path[n-1].Version; compareVersions(last, version) >= 0 {
+		return nil, fmt.Errorf("upgrade path ends with %s, which is not older than the build under test %s", last, version)
+	}
+	return path, nil
+}
+
+// compareVersions compares release versions such as "32.20200715.3.0" by
+// their components, numerically where both are numbers. It returns -1, 0
+// or 1 as a is older than, the same as or newer than b.
+func compareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool {
+			return r == '.' || r == '-'
+		})
+	}
+	as, bs := split(a), split(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aerr != nil || berr != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func parseGraph(t *testing.T, data string) *UpgradeGraph {
+	var g UpgradeGraph
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		t.Fatal(err)
+	}
+	return &g
+}
+
+func versions(path []UpgradeNode) []string {
+	var ret []string
+	for _, node := range path {
+		ret = append(ret, node.Version)
+	}
+	return ret
+}
+
+func TestUpgradeGraphPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph string
+		path  []string
+	}{
+		{
+			"by position",
+			`{"nodes": [{"version": "a"}, {"version": "b"}, {"version": "c"}], "edges": [[0, 1], [1, 2]]}`,
+			[]string{"a", "b", "c"},
+		},
+		{
+			"shortcut",
+			`{"nodes": [{"version": "a"}, {"version": "b"}, {"version": "c"}, {"version": "d"}],
+			  "edges": [[0, 1], [1, 2], [2, 3], [1, 3]]}`,
+			[]string{"a", "b", "d"},
+		},
+		{
+			"by age index",
+			`{"nodes": [
+			  {"version": "c", "payload": "cc", "metadata": {"org.fedoraproject.coreos.releases.age_index": "2"}},
+			  {"version": "a", "payload": "aa", "metadata": {"org.fedoraproject.coreos.releases.age_index": "0"}},
+			  {"version": "b", "payload": "bb", "metadata": {"org.fedoraproject.coreos.releases.age_index": "1"}}],
+			  "edges": [[1, 2], [2, 0], [1, 0]]}`,
+			[]string{"a", "c"},
+		},
+		{
+			"single node",
+			`{"nodes": [{"version": "a"}], "edges": []}`,
+			[]string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		path, err := parseGraph(t, tt.graph).Path()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got := versions(path); !reflect.DeepEqual(got, tt.path) {
+			t.Errorf("%s: got path %v, expected %v", tt.name, got, tt.path)
+		}
+	}
+
+	path, _ := parseGraph(t, tests[2].graph).Path()
+	if path[0].Payload != "aa" || path[1].Payload != "cc" {
+		t.Errorf("got payloads %+v", path)
+	}
+
+	for _, graph := range []string{
+		`{"nodes": [], "edges": []}`,
+		`{"nodes": [{"version": "a"}, {"version": "b"}], "edges": []}`,
+		`{"nodes": [{"version": "a"}, {"version": "b"}], "edges": [[1, 0]]}`,
+		`{"nodes": [{"version": "a"}, {"version": "b"}], "edges": [[0, 2]]}`,
+		`{"nodes": [{"version": "a", "metadata": {"org.fedoraproject.coreos.releases.age_index": "x"}}], "edges": []}`,
+	} {
+		if _, err := parseGraph(t, graph).Path(); err == nil {
+			t.Errorf("%s: no error", graph)
+		}
+	}
+}
+
+func TestTrimUpgradePath(t *testing.T) {
+	path := []UpgradeNode{{Version: "32.20200629.3.0"}, {Version: "32.20200715.3.0"}}
+
+	trimmed, err := TrimUpgradePath(path, "32.20200801.2.0")
+	if err != nil || len(trimmed) != 2 {
+		t.Errorf("newer build: got %v, %v", trimmed, err)
+	}
+	trimmed, err = TrimUpgradePath(path, "32.20200715.3.0")
+	if err != nil || !reflect.DeepEqual(versions(trimmed), []string{"32.20200629.3.0"}) {
+		t.Errorf("build at end of path: got %v, %v", trimmed, err)
+	}
+	if _, err := TrimUpgradePath(path, "32.20200701.3.0"); err == nil {
+		t.Errorf("older build: no error")
+	}
+	if _, err := TrimUpgradePath(path[:1], "32.20200629.3.0"); err == nil {
+		t.Errorf("empty path: no error")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"32.20200715.3.0", "32.20200715.3.0", 0},
+		{"32.20200715.3.0", "32.20200715.3.1", -1},
+		{"32.20200715.3.0", "32.20200801.2.0", -1},
+		{"33.20201201.3.0", "32.20201201.3.0", 1},
+		{"9.1", "10.0", -1},
+		{"46.82.202010011740-0", "46.82.202010011740-1", -1},
+		{"32.20200715.3", "32.20200715.3.0", -1},
+		{"1.0.dev", "1.0.rc", -1},
+	}
+	for _, tt := range tests {
+		if cmp := compareVersions(tt.a, tt.b); cmp != tt.cmp {
+			t.Errorf("%s vs %s: got %d, expected %d", tt.a, tt.b, cmp, tt.cmp)
+		}
+	}
+}
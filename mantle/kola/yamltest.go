@@ -0,0 +1,360 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ajeddeloh/yaml"
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// yamlTest is a test declared in a tests/kola/*.yaml file
+type yamlTest struct {
+	Ignition        string          `yaml:"ignition"`
+	Butane          string          `yaml:"butane"`
+	ClusterSize     int             `yaml:"clusterSize"`
+	Architectures   string          `yaml:"architectures"`
+	Platforms       string          `yaml:"platforms"`
+	Distros         string          `yaml:"distros"`
+	Tags            string          `yaml:"tags"`
+	Packages        string          `yaml:"packages"`
+	AdditionalDisks []string        `yaml:"additionalDisks"`
+	MinMemory       int             `yaml:"minMemory"`
+	CollectPaths    []string        `yaml:"collectPaths"`
+	AllowedAVCs     []string        `yaml:"allowedAVCs"`
+	Assertions      []yamlAssertion `yaml:"assertions"`
+}
+
+// yamlAssertion is a single check of a YAML test. Exactly one of Command,
+// Unit, File and Reboot is set.
+type yamlAssertion struct {
+	// Command is run over SSH; it must exit with Exit (default 0) and
+	// its stdout must match the Stdout regex, if any
+	Command string `yaml:"command"`
+	Exit    *int   `yaml:"exit"`
+	Stdout  string `yaml:"stdout"`
+
+	// Unit must be in the State ActiveState (default "active" unless
+	// Enabled is given) and be enabled or not according to Enabled
+	Unit    string `yaml:"unit"`
+	State   string `yaml:"state"`
+	Enabled *bool  `yaml:"enabled"`
+
+	// File must exist unless Exists is false, its contents must match the
+	// Contents regex and it must have Mode and the SELinux label or type
+	File     string `yaml:"file"`
+	Exists   *bool  `yaml:"exists"`
+	Contents string `yaml:"contents"`
+	Mode     string `yaml:"mode"`
+	SELinux  string `yaml:"selinux"`
+
+	// Reboot reboots the machines; later assertions check the new boot
+	Reboot bool `yaml:"reboot"`
+
+	stdout   *regexp.Regexp
+	contents *regexp.Regexp
+	mode     uint64
+}
+
+// String describes the assertion in failure messages.
+func (a *yamlAssertion) String() string {
+	switch {
+	case a.Command != "":
+		return fmt.Sprintf("command %q", a.Command)
+	case a.Unit != "":
+		return fmt.Sprintf("unit %s", a.Unit)
+	case a.File != "":
+		return fmt.Sprintf("file %s", a.File)
+	default:
+		return "reboot"
+	}
+}
+
+// compile validates the assertion and compiles its patterns.
+func (a *yamlAssertion) compile() error {
+	kinds := 0
+	for _, set := range []bool{a.Command != "", a.Unit != "", a.File != "", a.Reboot} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("exactly one of command, unit, file and reboot must be set")
+	}
+	// catch fields that would be silently ignored
+	var ignored []string
+	for field, set := range map[string]bool{
+		"exit":     a.Command == "" && a.Exit != nil,
+		"stdout":   a.Command == "" && a.Stdout != "",
+		"state":    a.Unit == "" && a.State != "",
+		"enabled":  a.Unit == "" && a.Enabled != nil,
+		"exists":   a.File == "" && a.Exists != nil,
+		"contents": a.File == "" && a.Contents != "",
+		"mode":     a.File == "" && a.Mode != "",
+		"selinux":  a.File == "" && a.SELinux != "",
+	} {
+		if set {
+			ignored = append(ignored, field)
+		}
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		return fmt.Errorf("%s not applicable to %s", strings.Join(ignored, ", "), a)
+	}
+	if a.Exists != nil && !*a.Exists && (a.Contents != "" || a.Mode != "" || a.SELinux != "") {
+		return fmt.Errorf("contents, mode and selinux not applicable to a missing file")
+	}
+	var err error
+	if a.Stdout != "" {
+		if a.stdout, err = regexp.Compile(a.Stdout); err != nil {
+			return errors.Wrapf(err, "parsing stdout")
+		}
+	}
+	if a.Contents != "" {
+		if a.contents, err = regexp.Compile(a.Contents); err != nil {
+			return errors.Wrapf(err, "parsing contents")
+		}
+	}
+	if a.Mode != "" {
+		if a.mode, err = strconv.ParseUint(a.Mode, 8, 32); err != nil {
+			return errors.Wrapf(err, "parsing mode")
+		}
+	}
+	return nil
+}
+
+// registerYAMLTest parses the YAML test at path and registers it as
+// testname.
+func registerYAMLTest(testname, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	t, err := parseYAMLTest(testname, data)
+	if err != nil {
+		return errors.Wrapf(err, "%s", path)
+	}
+	register.RegisterTest(t)
+	return nil
+}
+
+// parseYAMLTest returns the YAML test data as the test testname.
+func parseYAMLTest(testname string, data []byte) (*register.Test, error) {
+	var yt yamlTest
+	if err := yaml.UnmarshalStrict(data, &yt); err != nil {
+		return nil, errors.Wrapf(err, "parsing YAML test")
+	}
+	if len(yt.Assertions) == 0 {
+		return nil, fmt.Errorf("no assertions")
+	}
+	for i := range yt.Assertions {
+		if err := yt.Assertions[i].compile(); err != nil {
+			return nil, errors.Wrapf(err, "assertion %d", i+1)
+		}
+	}
+
+	var userdata *conf.UserData
+	switch {
+	case yt.Ignition != "" && yt.Butane != "":
+		return nil, fmt.Errorf("ignition and butane are exclusive")
+	case yt.Butane != "":
+		userdata = conf.Butane(yt.Butane)
+	default:
+		userdata = conf.Ignition(yt.Ignition)
+	}
+	// catch config errors now rather than when the test runs
+	if _, err := userdata.Render(false); err != nil {
+		return nil, err
+	}
+
+	clusterSize := yt.ClusterSize
+	if clusterSize < 1 {
+		clusterSize = 1
+	}
+	t := &register.Test{
+		Name:            testname,
+		ClusterSize:     clusterSize,
+		UserDataV3:      userdata,
+		Tags:            append([]string{"yaml"}, strings.Fields(yt.Tags)...),
+		Packages:        strings.Fields(yt.Packages),
+		AdditionalDisks: yt.AdditionalDisks,
+		MinMemory:       yt.MinMemory,
+		CollectPaths:    yt.CollectPaths,
+		AllowedAVCs:     yt.AllowedAVCs,
+		Run: func(c cluster.TestCluster) {
+			runYAMLAssertions(c, yt.Assertions)
+		},
+	}
+	setTestFilters(t, yt.Architectures, yt.Platforms, yt.Distros)
+	return t, nil
+}
+
+// registerYAMLTests registers the *.yaml tests in dir.
+func registerYAMLTests(dir, testprefix string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		testname := fmt.Sprintf("%s.%s", testprefix, strings.TrimSuffix(filepath.Base(path), ".yaml"))
+		if denied, err := testIsDenyListed(testname); err != nil {
+			return err
+		} else if denied {
+			plog.Debugf("Skipping denylisted YAML test %s", testname)
+			register.RegisterTest(&register.Test{
+				Name: testname,
+				Tags: []string{"yaml"},
+			})
+			continue
+		}
+		if err := registerYAMLTest(testname, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runYAMLAssertions checks the assertions in order on every machine.
+// Failed checks are reported and the remaining ones still run; a failed
+// reboot stops the test.
+func runYAMLAssertions(c cluster.TestCluster, assertions []yamlAssertion) {
+	for i := range assertions {
+		a := &assertions[i]
+		for _, m := range c.Machines() {
+			if a.Reboot {
+				if err := m.Reboot(); err != nil {
+					c.Fatalf("assertion %d (reboot) on %s: %v", i+1, m.ID(), err)
+				}
+				continue
+			}
+			if err := checkYAMLAssertion(m, a); err != nil {
+				c.Errorf("assertion %d (%s) on %s: %v", i+1, a, m.ID(), err)
+			}
+		}
+	}
+}
+
+func checkYAMLAssertion(m platform.Machine, a *yamlAssertion) error {
+	switch {
+	case a.Command != "":
+		stdout, stderr, err := m.SSH(a.Command)
+		exit := 0
+		if err != nil {
+			exitErr, ok := err.(*ssh.ExitError)
+			if !ok {
+				return err
+			}
+			exit = exitErr.ExitStatus()
+		}
+		want := 0
+		if a.Exit != nil {
+			want = *a.Exit
+		}
+		if exit != want {
+			return fmt.Errorf("exited with %d, expected %d; stdout %q, stderr %q", exit, want, stdout, stderr)
+		}
+		if a.stdout != nil && !a.stdout.Match(stdout) {
+			return fmt.Errorf("stdout %q does not match %q", stdout, a.Stdout)
+		}
+
+	case a.Unit != "":
+		unit := shellquote.Join(a.Unit)
+		state := a.State
+		if state == "" && a.Enabled == nil {
+			state = "active"
+		}
+		if state != "" {
+			out, stderr, err := m.SSH("systemctl show -p ActiveState --value " + unit)
+			if err != nil {
+				return fmt.Errorf("getting state: %v: %s", err, stderr)
+			}
+			if got := strings.TrimSpace(string(out)); got != state {
+				return fmt.Errorf("ActiveState is %q, expected %q", got, state)
+			}
+		}
+		if a.Enabled != nil {
+			// is-enabled exits non-zero for disabled units
+			out, _, _ := m.SSH("systemctl is-enabled " + unit)
+			got := strings.TrimSpace(string(out))
+			if enabled := got == "enabled"; enabled != *a.Enabled {
+				return fmt.Errorf("unit is %s, expected enabled: %v", got, *a.Enabled)
+			}
+		}
+
+	case a.File != "":
+		file := shellquote.Join(a.File)
+		exists := a.Exists == nil || *a.Exists
+		out, _, err := m.SSH(fmt.Sprintf("sudo stat -c '%%a %%C' %s", file))
+		if !exists {
+			if err == nil {
+				return fmt.Errorf("exists but should not")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("does not exist")
+		}
+		fields := strings.Fields(string(out))
+		if len(fields) != 2 {
+			return fmt.Errorf("unexpected stat output %q", out)
+		}
+		if a.Mode != "" {
+			mode, err := strconv.ParseUint(fields[0], 8, 32)
+			if err != nil {
+				return fmt.Errorf("parsing mode %q: %v", fields[0], err)
+			}
+			if mode != a.mode {
+				return fmt.Errorf("mode is %04o, expected %04o", mode, a.mode)
+			}
+		}
+		if a.SELinux != "" {
+			label := fields[1]
+			got := label
+			if !strings.Contains(a.SELinux, ":") {
+				// only the type was given
+				if parts := strings.Split(label, ":"); len(parts) >= 3 {
+					got = parts[2]
+				}
+			}
+			if got != a.SELinux {
+				return fmt.Errorf("SELinux label is %s, expected %s", label, a.SELinux)
+			}
+		}
+		if a.contents != nil {
+			out, stderr, err := m.SSH("sudo cat " + file)
+			if err != nil {
+				return fmt.Errorf("reading: %v: %s", err, stderr)
+			}
+			if !a.contents.Match(out) {
+				return fmt.Errorf("contents do not match %q", a.Contents)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLAssertionCompile(t *testing.T) {
+	yes, no, one := true, false, 1
+	valid := []yamlAssertion{
+		{Command: "true"},
+		{Command: "false", Exit: &one, Stdout: "^$"},
+		{Unit: "chronyd.service"},
+		{Unit: "chronyd.service", State: "inactive", Enabled: &no},
+		{File: "/etc/motd"},
+		{File: "/etc/motd", Exists: &yes, Contents: "hello", Mode: "0644", SELinux: "etc_t"},
+		{File: "/etc/motd", Exists: &no},
+		{Reboot: true},
+	}
+	for _, a := range valid {
+		a := a
+		if err := a.compile(); err != nil {
+			t.Errorf("%s: %v", &a, err)
+		}
+	}
+
+	a := yamlAssertion{Command: "true", Stdout: "^ok$"}
+	if err := a.compile(); err != nil || a.stdout == nil || !a.stdout.MatchString("ok") {
+		t.Errorf("%s: stdout not compiled: %v", &a, err)
+	}
+	a = yamlAssertion{File: "/etc/motd", Mode: "0755"}
+	if err := a.compile(); err != nil || a.mode != 0755 {
+		t.Errorf("%s: got mode %o: %v", &a, a.mode, err)
+	}
+
+	invalid := []struct {
+		assertion yamlAssertion
+		err       string
+	}{
+		{yamlAssertion{}, "exactly one"},
+		{yamlAssertion{Command: "true", Unit: "chronyd.service"}, "exactly one"},
+		{yamlAssertion{File: "/etc/motd", Reboot: true}, "exactly one"},
+		{yamlAssertion{Unit: "chronyd.service", Stdout: "active"}, "stdout not applicable to unit chronyd.service"},
+		{yamlAssertion{Command: "true", State: "active", Enabled: &yes}, "enabled, state not applicable"},
+		{yamlAssertion{Command: "true", Contents: "x", Mode: "0644"}, "contents, mode not applicable"},
+		{yamlAssertion{Reboot: true, Exit: &one, Exists: &yes, SELinux: "etc_t"}, "exists, exit, selinux not applicable to reboot"},
+		{yamlAssertion{File: "/etc/motd", Exists: &no, Contents: "hello"}, "missing file"},
+		{yamlAssertion{Command: "true", Stdout: "("}, "parsing stdout"},
+		{yamlAssertion{File: "/etc/motd", Contents: "["}, "parsing contents"},
+		{yamlAssertion{File: "/etc/motd", Mode: "0899"}, "parsing mode"},
+	}
+	for _, tt := range invalid {
+		if err := tt.assertion.compile(); err == nil {
+			t.Errorf("%s: no error", &tt.assertion)
+		} else if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %q, expected %q", &tt.assertion, err, tt.err)
+		}
+	}
+}
+
+func TestParseYAMLTest(t *testing.T) {
+	test, err := parseYAMLTest("ext.test.yaml", []byte(`
+ignition: '{"ignition": {"version": "3.0.0"}}'
+clusterSize: 2
+platforms: "!aws gcp"
+architectures: x86_64
+tags: slow
+packages: chrony
+additionalDisks: [1G]
+minMemory: 2048
+assertions:
+  - unit: chronyd.service
+  - reboot: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Name != "ext.test.yaml" || test.ClusterSize != 2 || test.MinMemory != 2048 || test.UserDataV3 == nil || test.Run == nil {
+		t.Errorf("got test %+v", test)
+	}
+	for _, tt := range []struct {
+		name          string
+		got, expected []string
+	}{
+		{"tags", test.Tags, []string{"yaml", "slow"}},
+		{"packages", test.Packages, []string{"chrony"}},
+		{"additional disks", test.AdditionalDisks, []string{"1G"}},
+		{"excluded platforms", test.ExcludePlatforms, []string{"aws", "gcp"}},
+		{"architectures", test.Architectures, []string{"x86_64"}},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.expected) {
+			t.Errorf("%s: got %q, expected %q", tt.name, tt.got, tt.expected)
+		}
+	}
+
+	for _, data := range []string{
+		// no assertions
+		"ignition: ''\n",
+		// unknown key
+		"assertions:\n  - reboot: true\nunknown: key\n",
+		// exclusive configs
+		"ignition: '{}'\nbutane: 'variant: fcos'\nassertions:\n  - reboot: true\n",
+		// invalid config
+		"ignition: '{'\nassertions:\n  - reboot: true\n",
+		// inapplicable field
+		"assertions:\n  - reboot: true\n    stdout: foo\n",
+	} {
+		if _, err := parseYAMLTest("ext.test.invalid", []byte(data)); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+
+	fcct "github.com/coreos/fcct/config"
+	"github.com/coreos/fcct/config/common"
+)
+
+// Butane returns a Butane (formerly Fedora CoreOS Config) UserData struct
+// from the provided string. The config is translated to Ignition in
+// strict mode when rendered.
+func Butane(data string) *UserData {
+	return &UserData{
+		kind: kindButane,
+		data: data,
+	}
+}
+
+// TranslateButane translates a Butane config into an Ignition config with
+// the upstream translator. Sugar embedding local files (local, trees)
+// reads them relative to filesDir, and is rejected if it is empty. In
+// strict mode, warnings such as unknown keys are errors. Errors refer to
+// the line of the Butane config they occurred on.
+func TranslateButane(data []byte, filesDir string, strict bool) ([]byte, error) {
+	out, rpt, err := fcct.Translate(data, common.TranslateOptions{
+		Strict: strict,
+		BaseOptions: common.BaseOptions{
+			FilesDir: filesDir,
+		},
+	})
+	var msgs []string
+	for _, e := range rpt.Entries {
+		if !strict && !e.Kind.IsFatal() {
+			plog.Warningf("translating Butane config: %s", e)
+			continue
+		}
+		msgs = append(msgs, e.String())
+	}
+	if len(msgs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
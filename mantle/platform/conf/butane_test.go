@@ -0,0 +1,130 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestButaneRender(t *testing.T) {
+	config := `variant: fcos
+version: 1.1.0
+passwd:
+  users:
+    - name: core
+      ssh_authorized_keys:
+        - ssh-rsa AAAA
+storage:
+  disks:
+    - device: /dev/vdb
+      partitions:
+        - label: data
+          size_mib: 100
+  files:
+    - path: /etc/hostname
+      mode: 0644
+      contents:
+        inline: butane
+`
+	c, err := Butane(config).Render(false)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if c.ignitionV31 == nil {
+		t.Fatalf("expected an Ignition 3.1 config, got %s", c.String())
+	}
+	for _, s := range []string{`"sshAuthorizedKeys"`, `"sizeMiB":100`, `"mode":420`, `data:,butane`} {
+		if !strings.Contains(c.String(), s) {
+			t.Errorf("expected %s in %s", s, c.String())
+		}
+	}
+}
+
+func TestButaneSugar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "butane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "motd"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `variant: fcos
+version: 1.1.0
+storage:
+  filesystems:
+    - device: /dev/vdb
+      path: /var/data
+      format: xfs
+      with_mount_unit: true
+  files:
+    - path: /etc/motd
+      contents:
+        local: motd
+`
+	out, err := TranslateButane([]byte(config), dir, true)
+	if err != nil {
+		t.Fatalf("TranslateButane failed: %v", err)
+	}
+	for _, s := range []string{`"var-data.mount"`, `data:,local`} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("expected %s in %s", s, out)
+		}
+	}
+}
+
+func TestButaneErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		strict bool
+		err    string
+	}{
+		{
+			config: "variant: fcos\nversion: 9.0.0\n",
+			err:    "No translator exists for variant fcos with version 9.0.0",
+		},
+		{
+			config: "variant: fcos\nversion: 1.0.0\nstorage:\n  filez: []\n",
+			strict: true,
+			err:    "line 4: field filez not found",
+		},
+		{
+			// Ignition's names aren't Butane's
+			config: "variant: fcos\nversion: 1.1.0\nstorage:\n  disks:\n    - device: /dev/vdb\n      partitions:\n        - sizeMiB: 100\n",
+			strict: true,
+			err:    "line 7: field sizeMiB not found",
+		},
+		{
+			// Local files need a files directory
+			config: "variant: fcos\nversion: 1.1.0\nstorage:\n  files:\n    - path: /a\n      contents:\n        local: a\n",
+			err:    "line 7",
+		},
+		{
+			config: "variant: fcos\nversion: 1.0.0\nstorage:\n  files:\n    - path: relative\n",
+			err:    "line 5 col 13: path not absolute",
+		},
+	}
+	for _, tt := range tests {
+		_, err := TranslateButane([]byte(tt.config), "", tt.strict)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("expected error containing %q for %q, got %v", tt.err, tt.config, err)
+		}
+	}
+}
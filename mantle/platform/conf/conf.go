@@ -61,6 +61,7 @@ const (
 	kindEmpty kind = iota
 	kindIgnition
 	kindContainerLinuxConfig
+	kindButane
 )
 
 type systemdUnitState int
@@ -175,7 +176,7 @@ func (u *UserData) AddKey(key agent.Key) *UserData {
 }
 
 func (u *UserData) IsIgnitionCompatible() bool {
-	return u.kind == kindIgnition || u.kind == kindContainerLinuxConfig
+	return u.kind == kindIgnition || u.kind == kindContainerLinuxConfig || u.kind == kindButane
 }
 
 // Render parses userdata and returns a new Conf. It returns an error if the
@@ -189,10 +190,10 @@ func (u *UserData) Render(ignv2 bool) (*Conf, error) {
 func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, error) {
 	c := &Conf{}
 
-	renderIgnition := func() error {
+	renderIgnition := func(data []byte) error {
 		// Try each known version in turn.  Newer parsers will
 		// fall back to older ones, so try older versions first.
-		ignc2, report, err := v2.Parse([]byte(u.data))
+		ignc2, report, err := v2.Parse(data)
 		if err == nil {
 			c.ignitionV2 = &ignc2
 			return nil
@@ -201,7 +202,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc21, report, err := v21.Parse([]byte(u.data))
+		ignc21, report, err := v21.Parse(data)
 		if err == nil {
 			c.ignitionV21 = &ignc21
 			return nil
@@ -210,7 +211,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc22, report, err := v22.Parse([]byte(u.data))
+		ignc22, report, err := v22.Parse(data)
 		if err == nil {
 			c.ignitionV22 = &ignc22
 			return nil
@@ -219,7 +220,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc23, report, err := v23.Parse([]byte(u.data))
+		ignc23, report, err := v23.Parse(data)
 		if err == nil {
 			c.ignitionV23 = &ignc23
 			return nil
@@ -228,7 +229,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc24, report, err := v24.Parse([]byte(u.data))
+		ignc24, report, err := v24.Parse(data)
 		if err == nil {
 			c.ignitionV24 = &ignc24
 			return nil
@@ -237,7 +238,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc3, report3, err := v3.Parse([]byte(u.data))
+		ignc3, report3, err := v3.Parse(data)
 		if err == nil {
 			c.ignitionV3 = &ignc3
 
@@ -255,7 +256,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc31, report31, err := v31.Parse([]byte(u.data))
+		ignc31, report31, err := v31.Parse(data)
 		if err == nil {
 			c.ignitionV31 = &ignc31
 
@@ -273,7 +274,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc32, report32, err := v32.Parse([]byte(u.data))
+		ignc32, report32, err := v32.Parse(data)
 		if err == nil {
 			c.ignitionV32 = &ignc32
 
@@ -287,7 +288,7 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 			return err
 		}
 
-		ignc33exp, report33exp, err := v33exp.Parse([]byte(u.data))
+		ignc33exp, report33exp, err := v33exp.Parse(data)
 		if err == nil {
 			c.ignitionV33exp = &ignc33exp
 			if ignv2 {
@@ -307,10 +308,18 @@ func (u *UserData) RenderForCtPlatform(ignv2 bool, ctPlatform string) (*Conf, er
 	case kindEmpty:
 		// empty, noop
 	case kindIgnition:
-		err := renderIgnition()
+		err := renderIgnition([]byte(u.data))
 		if err != nil {
 			return nil, err
 		}
+	case kindButane:
+		data, err := TranslateButane([]byte(u.data), "", true)
+		if err != nil {
+			return nil, fmt.Errorf("translating Butane config: %v", err)
+		}
+		if err := renderIgnition(data); err != nil {
+			return nil, err
+		}
 	case kindContainerLinuxConfig:
 		clc, ast, report := ct.Parse([]byte(u.data))
 		if report.IsFatal() {
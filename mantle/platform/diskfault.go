@@ -0,0 +1,119 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// blkdebugSectorSize is the unit of blkdebug sector numbers, regardless
+// of the sector size of the disk.
+const blkdebugSectorSize = 512
+
+// DiskFault makes requests to a Disk fail, through QEMU's blkdebug driver.
+// Requests are counted and matched as the guest issues them, before the
+// image format maps them to the image file.
+type DiskFault struct {
+	Read    bool          // fail reads rather than writes
+	After   int           // let this many reads or writes through before failing
+	Offsets []int64       // if set, fail only requests covering one of these byte offsets
+	Errno   syscall.Errno // the error to fail with, EIO by default
+	Once    bool          // fail a single request rather than every matching one
+}
+
+// blkdebugConfig renders faults as a blkdebug config file. blkdebug has a
+// single state variable, so only one of the faults can wait for requests:
+// each of them moves the state on, and the fault applies in the last one.
+func blkdebugConfig(faults []DiskFault) (string, error) {
+	var buf strings.Builder
+	counted := false
+	for _, f := range faults {
+		event := "write_aio"
+		if f.Read {
+			event = "read_aio"
+		}
+		errno := f.Errno
+		if errno == 0 {
+			errno = syscall.EIO
+		}
+		state := 0 // any state
+		if f.After < 0 {
+			return "", fmt.Errorf("disk fault after %d requests", f.After)
+		} else if f.After > 0 {
+			if counted {
+				return "", fmt.Errorf("only one disk fault can wait for requests")
+			}
+			counted = true
+			for i := 1; i <= f.After; i++ {
+				fmt.Fprintf(&buf, "[set-state]\nevent = %q\nstate = \"%d\"\nnew_state = \"%d\"\n\n", event, i, i+1)
+			}
+			state = f.After + 1
+		}
+		offsets := f.Offsets
+		if len(offsets) == 0 {
+			offsets = []int64{-1}
+		}
+		for _, offset := range offsets {
+			fmt.Fprintf(&buf, "[inject-error]\nevent = %q\nerrno = \"%d\"\n", event, errno)
+			if offset >= 0 {
+				fmt.Fprintf(&buf, "sector = \"%d\"\n", offset/blkdebugSectorSize)
+			} else if len(f.Offsets) > 0 {
+				return "", fmt.Errorf("negative disk fault offset %d", offset)
+			}
+			if state != 0 {
+				fmt.Fprintf(&buf, "state = \"%d\"\n", state)
+			}
+			if f.Once {
+				buf.WriteString("once = \"on\"\n")
+			}
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String(), nil
+}
+
+// faultyDrive returns the -drive options opening file for the disk with
+// the given id, injecting its faults, and writes the blkdebug config to the
+// builder's tempdir. NBD exports are raw. blkdebug only sees the read and
+// write events a format driver raises on its file child, so a raw format
+// node goes above it; for qcow2 images blkdebug goes above the qcow2 node,
+// so that requests are matched at the offsets the guest sees.
+func (builder *QemuBuilder) faultyDrive(disk *Disk, id, file string, qcow2 bool) (string, error) {
+	if len(disk.Faults) == 0 {
+		if qcow2 {
+			return "file=" + file, nil
+		}
+		return "format=raw,file=" + file, nil
+	}
+	config, err := blkdebugConfig(disk.Faults)
+	if err != nil {
+		return "", err
+	}
+	if err := builder.ensureTempdir(); err != nil {
+		return "", err
+	}
+	path := filepath.Join(builder.tempdir, id+".blkdebug")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		return "", err
+	}
+	if qcow2 {
+		return fmt.Sprintf("driver=raw,file.driver=blkdebug,file.config=%s,file.image.driver=qcow2,file.image.file.filename=%s", path, file), nil
+	}
+	return fmt.Sprintf("format=raw,file=blkdebug:%s:%s", path, file), nil
+}
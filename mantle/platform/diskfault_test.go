@@ -0,0 +1,180 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestBlkdebugConfig(t *testing.T) {
+	tests := []struct {
+		faults []DiskFault
+		config string
+	}{
+		{
+			[]DiskFault{{}},
+			"[inject-error]\nevent = \"write_aio\"\nerrno = \"5\"\n\n",
+		},
+		{
+			[]DiskFault{{After: 2, Once: true}},
+			"[set-state]\nevent = \"write_aio\"\nstate = \"1\"\nnew_state = \"2\"\n\n" +
+				"[set-state]\nevent = \"write_aio\"\nstate = \"2\"\nnew_state = \"3\"\n\n" +
+				"[inject-error]\nevent = \"write_aio\"\nerrno = \"5\"\nstate = \"3\"\nonce = \"on\"\n\n",
+		},
+		{
+			[]DiskFault{{Read: true, Offsets: []int64{0, 1 << 20}, Errno: syscall.ENOSPC}},
+			"[inject-error]\nevent = \"read_aio\"\nerrno = \"28\"\nsector = \"0\"\n\n" +
+				"[inject-error]\nevent = \"read_aio\"\nerrno = \"28\"\nsector = \"2048\"\n\n",
+		},
+	}
+	for _, tt := range tests {
+		config, err := blkdebugConfig(tt.faults)
+		if err != nil {
+			t.Errorf("%+v: %v", tt.faults, err)
+		} else if config != tt.config {
+			t.Errorf("%+v: got config\n%s\nexpected\n%s", tt.faults, config, tt.config)
+		}
+	}
+
+	invalid := [][]DiskFault{
+		{{After: -1}},
+		{{Offsets: []int64{-512}}},
+		{{After: 1}, {Read: true, After: 1}},
+	}
+	for _, faults := range invalid {
+		if _, err := blkdebugConfig(faults); err == nil {
+			t.Errorf("%+v: no error", faults)
+		}
+	}
+}
+
+func TestFaultyDrive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskfault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	builder := &QemuBuilder{tempdir: dir}
+	config := filepath.Join(dir, "d0.blkdebug")
+
+	disk := &Disk{}
+	if opts, err := builder.faultyDrive(disk, "d0", "/dev/fdset/1", true); err != nil || opts != "file=/dev/fdset/1" {
+		t.Errorf("qcow2 without faults: got %q, %v", opts, err)
+	}
+	if opts, err := builder.faultyDrive(disk, "d0", "nbd:unix:/tmp/disk.socket", false); err != nil || opts != "format=raw,file=nbd:unix:/tmp/disk.socket" {
+		t.Errorf("nbd without faults: got %q, %v", opts, err)
+	}
+
+	disk.Faults = []DiskFault{{Offsets: []int64{4096}}}
+	opts, err := builder.faultyDrive(disk, "d0", "/dev/fdset/1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// blkdebug must sit between a format node raising its events and
+	// qcow2, to see them at guest offsets
+	if expected := "driver=raw,file.driver=blkdebug,file.config=" + config + ",file.image.driver=qcow2,file.image.file.filename=/dev/fdset/1"; opts != expected {
+		t.Errorf("qcow2: got %q, expected %q", opts, expected)
+	}
+	if data, err := ioutil.ReadFile(config); err != nil || !strings.Contains(string(data), "sector = \"8\"") {
+		t.Errorf("got config %q, %v", data, err)
+	}
+
+	opts, err = builder.faultyDrive(disk, "d0", "nbd:unix:/tmp/disk.socket", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "format=raw,file=blkdebug:" + config + ":nbd:unix:/tmp/disk.socket"; opts != expected {
+		t.Errorf("nbd: got %q, expected %q", opts, expected)
+	}
+}
+
+// TestFaultyDriveQemuIO checks that the faults of a qcow2 disk fire at the
+// offsets the guest sees, by opening it as QEMU would with qemu-io.
+func TestFaultyDriveQemuIO(t *testing.T) {
+	for _, tool := range []string{"qemu-img", "qemu-io"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	dir, err := ioutil.TempDir("", "diskfault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "disk.qcow2")
+	if out, err := exec.Command("qemu-img", "create", "-f", "qcow2", image, "4M").CombinedOutput(); err != nil {
+		t.Fatalf("creating image: %v: %s", err, out)
+	}
+
+	builder := &QemuBuilder{tempdir: dir}
+	disk := &Disk{Faults: []DiskFault{
+		{Offsets: []int64{1 << 20}},
+		{Read: true, Offsets: []int64{2 << 20}},
+	}}
+	opts, err := builder.faultyDrive(disk, "d0", image, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		fails   bool
+	}{
+		{"write 0 4k", false},
+		{"read 0 4k", false},
+		{"write 1M 4k", true},
+		{"write 1020k 8k", true},
+		{"read 1M 4k", false},
+		{"read 2M 4k", true},
+		{"write 2M 4k", false},
+	}
+	for _, tt := range tests {
+		out, _ := exec.Command("qemu-io", "--image-opts", opts, "-c", tt.command).CombinedOutput()
+		if fails := strings.Contains(string(out), "Input/output error"); fails != tt.fails {
+			t.Errorf("%s: failed %v, expected %v: %s", tt.command, fails, tt.fails, out)
+		}
+	}
+}
+
+func TestParseDiskSpec(t *testing.T) {
+	tests := []struct {
+		spec      string
+		size      string
+		multipath bool
+	}{
+		{"1G", "1G", false},
+		{"512M:mpath", "512M", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		size, multipath, err := ParseDiskSpec(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+		} else if size != tt.size || multipath != tt.multipath {
+			t.Errorf("%q: got %q, %v, expected %q, %v", tt.spec, size, multipath, tt.size, tt.multipath)
+		}
+	}
+	for _, spec := range []string{"1G:", "1G:raid", "1G:mpath:mpath"} {
+		if _, _, err := ParseDiskSpec(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
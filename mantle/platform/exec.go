@@ -0,0 +1,172 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecRequest is a command to run on a machine with Machine.Exec.
+type ExecRequest struct {
+	// Argv is the command and its arguments, which are quoted for the
+	// remote shell.
+	Argv []string
+	// Env holds extra environment variables, as "NAME=value".
+	Env []string
+	// Dir is the working directory; defaults to the home directory.
+	Dir string
+	// Sudo runs the command as root.
+	Sudo bool
+
+	// Stdin if set is the command's standard input.
+	Stdin io.Reader
+	// Stdout and Stderr receive the output while the command runs;
+	// it is discarded if they are nil.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Timeout if set kills the command once it has run that long.
+	Timeout time.Duration
+}
+
+// ExecResult is the outcome of a command which ran to completion or was
+// killed.
+type ExecResult struct {
+	// ExitCode is the exit status of the command, or -1 if it was killed
+	// by a signal or the status is unknown.
+	ExitCode int
+	// Signal is the name of the signal which killed the command, without
+	// the SIG prefix (e.g. "KILL"), if any.
+	Signal string
+	// Duration is how long the command ran.
+	Duration time.Duration
+}
+
+// Success returns whether the command exited with status 0.
+func (r *ExecResult) Success() bool {
+	return r.ExitCode == 0
+}
+
+func (r *ExecResult) String() string {
+	switch {
+	case r.Signal != "":
+		return fmt.Sprintf("killed by signal %s after %v", r.Signal, r.Duration)
+	case r.ExitCode < 0:
+		return fmt.Sprintf("exited without a status after %v", r.Duration)
+	default:
+		return fmt.Sprintf("exited with status %d after %v", r.ExitCode, r.Duration)
+	}
+}
+
+// Command returns the shell command line running req.
+func (req *ExecRequest) Command() string {
+	cmd := shellquote.Join(req.Argv...)
+	if len(req.Env) > 0 {
+		cmd = "env " + shellquote.Join(req.Env...) + " " + cmd
+	}
+	if req.Dir != "" {
+		cmd = "cd " + shellquote.Join(req.Dir) + " && exec " + cmd
+	}
+	if req.Sudo {
+		cmd = "sudo sh -c " + shellquote.Join(cmd)
+	}
+	return cmd
+}
+
+// Exec runs req on m over a new SSH connection.  The command's exit
+// status is in the result, with a nil error; errors are for failures to
+// run it, or to wait for it if ctx is done or the timeout expires first,
+// in which case the command is killed.
+func (bc *BaseCluster) Exec(ctx context.Context, m Machine, req ExecRequest) (*ExecResult, error) {
+	if len(req.Argv) == 0 {
+		return nil, errors.New("no command given")
+	}
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+
+	client, err := bc.SSHClient(m.IP())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	session.Stdin = req.Stdin
+	session.Stdout = req.Stdout
+	if session.Stdout == nil {
+		session.Stdout = ioutil.Discard
+	}
+	session.Stderr = req.Stderr
+	if session.Stderr == nil {
+		session.Stderr = ioutil.Discard
+	}
+
+	start := time.Now()
+	if err := session.Start(req.Command()); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Not all sshd versions deliver signals, but closing the
+		// session hangs the command up either way
+		session.Signal(ssh.SIGKILL) // Ignore errors
+		session.Close()
+		if ctx.Err() == context.DeadlineExceeded && req.Timeout > 0 {
+			return nil, fmt.Errorf("%q timed out after %v", strings.Join(req.Argv, " "), req.Timeout)
+		}
+		return nil, ctx.Err()
+	}
+
+	result := &ExecResult{
+		Duration: time.Since(start),
+	}
+	switch err := err.(type) {
+	case nil:
+	case *ssh.ExitError:
+		result.ExitCode = err.ExitStatus()
+		if err.Signal() != "" {
+			result.ExitCode = -1
+			result.Signal = err.Signal()
+		}
+	case *ssh.ExitMissingError:
+		result.ExitCode = -1
+	default:
+		return nil, err
+	}
+	return result, nil
+}
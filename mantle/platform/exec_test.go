@@ -0,0 +1,37 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"testing"
+)
+
+func TestExecRequestCommand(t *testing.T) {
+	for _, tt := range []struct {
+		req ExecRequest
+		cmd string
+	}{
+		{ExecRequest{Argv: []string{"echo", "a b", "$HOME"}}, `echo 'a b' \$HOME`},
+		{ExecRequest{Argv: []string{"env"}, Env: []string{"A=1 2"}}, `env 'A=1 2' env`},
+		{ExecRequest{Argv: []string{"ls"}, Dir: "/var/tmp"}, `cd /var/tmp && exec ls`},
+		{ExecRequest{Argv: []string{"id", "-u"}, Sudo: true}, `sudo sh -c 'id -u'`},
+		{ExecRequest{Argv: []string{"cat", "it's"}, Dir: "/a b", Sudo: true},
+			`sudo sh -c 'cd '\''/a b'\'' && exec cat it\'\''s'`},
+	} {
+		if cmd := tt.req.Command(); cmd != tt.cmd {
+			t.Errorf("%q: got %s, expected %s", tt.req.Argv, cmd, tt.cmd)
+		}
+	}
+}
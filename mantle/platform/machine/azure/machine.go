@@ -15,6 +15,7 @@
 package azure
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -74,6 +75,10 @@ func (am *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return am.cluster.SSH(am, cmd)
 }
 
+func (am *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return am.cluster.Exec(ctx, am, req)
+}
+
 func (am *machine) IgnitionError() error {
 	return nil
 }
@@ -61,6 +61,10 @@ func (dm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return dm.cluster.SSH(dm, cmd)
 }
 
+func (dm *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return dm.cluster.Exec(ctx, dm, req)
+}
+
 func (dm *machine) IgnitionError() error {
 	return nil
 }
@@ -15,6 +15,7 @@
 package esx
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"time"
@@ -62,6 +63,10 @@ func (em *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return em.cluster.SSH(em, cmd)
 }
 
+func (em *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return em.cluster.Exec(ctx, em, req)
+}
+
 func (em *machine) IgnitionError() error {
 	return nil
 }
@@ -15,6 +15,7 @@
 package gcloud
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"time"
@@ -62,6 +63,10 @@ func (gm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return gm.gc.SSH(gm, cmd)
 }
 
+func (gm *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return gm.gc.Exec(ctx, gm, req)
+}
+
 func (gm *machine) IgnitionError() error {
 	return nil
 }
@@ -15,6 +15,7 @@
 package openstack
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -83,6 +84,10 @@ func (om *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return om.cluster.SSH(om, cmd)
 }
 
+func (om *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return om.cluster.Exec(ctx, om, req)
+}
+
 func (om *machine) IgnitionError() error {
 	return nil
 }
@@ -15,6 +15,7 @@
 package packet
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -61,6 +62,10 @@ func (pm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return pm.cluster.SSH(pm, cmd)
 }
 
+func (pm *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return pm.cluster.Exec(ctx, pm, req)
+}
+
 func (pm *machine) IgnitionError() error {
 	return nil
 }
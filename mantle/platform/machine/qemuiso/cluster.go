@@ -114,9 +114,15 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 
 	builder.AddIso(qc.flight.opts.IsoPath, "")
 
-	for _, disk := range options.AdditionalDisks {
+	for i, disk := range options.AdditionalDisks {
+		size, multipath, err := platform.ParseDiskSpec(disk)
+		if err != nil {
+			return nil, err
+		}
 		if err = builder.AddDisk(&platform.Disk{
-			Size: disk,
+			Size:          size,
+			MultiPathDisk: multipath,
+			Faults:        options.DiskFaults[i],
 		}); err != nil {
 			return nil, errors.Wrapf(err, "adding additional disk")
 		}
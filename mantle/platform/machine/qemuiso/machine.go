@@ -63,6 +63,10 @@ func (m *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return m.qc.SSH(m, cmd)
 }
 
+func (m *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return m.qc.Exec(ctx, m, req)
+}
+
 func (m *machine) IgnitionError() error {
 	ctx := context.Background()
 	buf, err := m.inst.WaitIgnitionError(ctx)
@@ -83,6 +87,30 @@ func (m *machine) WaitForReboot(timeout time.Duration, oldBootId string) error {
 	return platform.WaitForMachineReboot(m, m.journal, timeout, oldBootId)
 }
 
+func (m *machine) Snapshot(name string) error {
+	return m.inst.Snapshot(name)
+}
+
+func (m *machine) Restore(name string) error {
+	return m.inst.Restore(name)
+}
+
+func (m *machine) UnplugDisk(serial string) error {
+	return m.inst.UnplugDisk(serial)
+}
+
+func (m *machine) PlugDisk(serial string) error {
+	return m.inst.PlugDisk(serial)
+}
+
+func (m *machine) UnplugDiskPath(serial string, path int) error {
+	return m.inst.UnplugDiskPath(serial, path)
+}
+
+func (m *machine) PlugDiskPath(serial string, path int) error {
+	return m.inst.PlugDiskPath(serial, path)
+}
+
 func (m *machine) Destroy() {
 	m.inst.Destroy()
 
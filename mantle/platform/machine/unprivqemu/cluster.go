@@ -28,6 +28,7 @@ import (
 
 	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/conf"
+	"github.com/coreos/mantle/platform/metadata"
 	"github.com/coreos/mantle/util"
 )
 
@@ -38,6 +39,8 @@ import (
 type Cluster struct {
 	*platform.BaseCluster
 	flight *flight
+	// privnet is shared by all machines in the cluster, if enabled
+	privnet *platform.PrivateNetwork
 
 	mu sync.Mutex
 }
@@ -71,6 +74,12 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	}
 	qc.mu.Unlock()
 
+	hostname := fmt.Sprintf("qemu%d", qc.BaseCluster.AllocateMachineSerial())
+	emulated := qc.RuntimeConf().EmulatedPlatform
+	if emulated != "" {
+		metadata.ConfigureGuest(emulated, conf)
+	}
+
 	var confPath string
 	if conf.IsIgnition() {
 		confPath = filepath.Join(dir, "ignition.json")
@@ -98,14 +107,30 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	if options.DisablePDeathSig {
 		builder.Pdeathsig = false
 	}
-	builder.ConfigFile = confPath
 	defer builder.Close()
 	builder.UUID = qm.id
 	builder.Firmware = qc.flight.opts.Firmware
 	builder.Swtpm = qc.flight.opts.Swtpm
-	builder.Hostname = fmt.Sprintf("qemu%d", qc.BaseCluster.AllocateMachineSerial())
+	builder.Hostname = hostname
 	builder.ConsoleFile = qm.consolePath
 
+	if emulated != "" {
+		// The config is served by the metadata service rather than
+		// injected
+		qm.metadata, err = qc.startMetadata(emulated, qm.id, hostname, conf, dir, builder)
+		if err != nil {
+			return nil, errors.Wrapf(err, "emulating %s metadata service", emulated)
+		}
+		defer func() {
+			if qm.inst == nil {
+				qm.metadata.Close()
+			}
+		}()
+	} else {
+		builder.ConfigFile = confPath
+	}
+	builder.PrivateNetwork = qc.privnet
+
 	if qc.flight.opts.Memory != "" {
 		memory, err := strconv.ParseInt(qc.flight.opts.Memory, 10, 32)
 		if err != nil {
@@ -140,9 +165,15 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	if err != nil {
 		return nil, err
 	}
-	for _, disk := range options.AdditionalDisks {
+	for i, disk := range options.AdditionalDisks {
+		size, multipath, err := platform.ParseDiskSpec(disk)
+		if err != nil {
+			return nil, err
+		}
 		if err = builder.AddDisk(&platform.Disk{
-			Size: disk,
+			Size:          size,
+			MultiPathDisk: multipath,
+			Faults:        options.DiskFaults[i],
 		}); err != nil {
 			return nil, errors.Wrapf(err, "adding additional disk")
 		}
@@ -156,9 +187,13 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		}
 		builder.EnableUsermodeNetworking(h)
 	}
+	builder.GuestForwardPorts = append(builder.GuestForwardPorts, qc.RuntimeConf().GuestForwardPorts...)
+	builder.TFTPDir = qc.RuntimeConf().TFTPDir
 	if !qc.RuntimeConf().InternetAccess {
 		builder.RestrictNetworking = true
 	}
+	builder.ShapeNetwork = qc.RuntimeConf().ShapeNetwork
+	builder.NetworkFaults = qc.RuntimeConf().NetworkFaults
 
 	inst, err := builder.Exec()
 	if err != nil {
@@ -188,7 +223,64 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	return qm, nil
 }
 
+// startMetadata starts a service emulating the metadata service of
+// platformID for a machine, serving it conf, and sets up builder to boot
+// as on that platform.
+func (qc *Cluster) startMetadata(platformID, id, hostname string, conf *conf.Conf, dir string, builder *platform.QemuBuilder) (*metadata.Service, error) {
+	if len(qc.RuntimeConf().GuestForwardPorts) > 0 {
+		// They are on the default usermode network
+		return nil, fmt.Errorf("cannot be combined with guest forwards")
+	}
+	config := metadata.Config{
+		InstanceID: id,
+		Hostname:   hostname,
+	}
+	if conf.IsIgnition() {
+		config.UserData = conf.Bytes()
+	}
+	if !qc.RuntimeConf().NoSSHKeyInMetadata {
+		keys, err := qc.Keys()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			config.SSHKeys = append(config.SSHKeys, key.String())
+		}
+	}
+	svc, err := metadata.Start(platformID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	builder.PlatformID = platformID
+	builder.UsermodeNetwork = metadata.Network
+	builder.GuestForwardPorts, err = svc.GuestForwardPorts()
+	if err != nil {
+		svc.Close()
+		return nil, err
+	}
+	builder.AppendInitrd, err = svc.Initrd(dir)
+	if err != nil {
+		svc.Close()
+		return nil, err
+	}
+	media, err := svc.Media(dir)
+	if err != nil {
+		svc.Close()
+		return nil, err
+	}
+	if media != "" {
+		builder.AddCdrom(media, "Virtual CD")
+	}
+	return svc, nil
+}
+
 func (qc *Cluster) Destroy() {
 	qc.BaseCluster.Destroy()
+	if qc.privnet != nil {
+		if err := qc.privnet.Close(); err != nil {
+			plog.Errorf("Error closing private network: %v", err)
+		}
+	}
 	qc.flight.DelCluster(qc)
 }
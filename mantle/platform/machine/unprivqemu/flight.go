@@ -44,6 +44,10 @@ type Options struct {
 	//Option to create a temporary software TPM - true by default
 	Swtpm bool
 
+	// PrivateNetwork connects the machines of a cluster over a shared
+	// network so they can reach each other - true by default
+	PrivateNetwork bool
+
 	*platform.Options
 }
 
@@ -83,6 +87,13 @@ func (qf *flight) NewCluster(rconf *platform.RuntimeConfig) (platform.Cluster, e
 		flight:      qf,
 	}
 
+	if qf.opts.PrivateNetwork {
+		qc.privnet, err = platform.NewPrivateNetwork()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	qf.AddCluster(qc)
 
 	return qc, nil
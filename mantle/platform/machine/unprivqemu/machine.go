@@ -23,12 +23,14 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/metadata"
 )
 
 type machine struct {
 	qc          *Cluster
 	id          string
 	inst        *platform.QemuInstance
+	metadata    *metadata.Service
 	journal     *platform.Journal
 	consolePath string
 	console     string
@@ -44,6 +46,9 @@ func (m *machine) IP() string {
 }
 
 func (m *machine) PrivateIP() string {
+	if ip := m.inst.PrivateIP(); ip != "" {
+		return ip
+	}
 	return m.ip
 }
 
@@ -63,6 +68,10 @@ func (m *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return m.qc.SSH(m, cmd)
 }
 
+func (m *machine) Exec(ctx context.Context, req platform.ExecRequest) (*platform.ExecResult, error) {
+	return m.qc.Exec(ctx, m, req)
+}
+
 func (m *machine) IgnitionError() error {
 	ctx := context.Background()
 	buf, err := m.inst.WaitIgnitionError(ctx)
@@ -83,8 +92,43 @@ func (m *machine) WaitForReboot(timeout time.Duration, oldBootId string) error {
 	return platform.WaitForMachineReboot(m, m.journal, timeout, oldBootId)
 }
 
+func (m *machine) Snapshot(name string) error {
+	return m.inst.Snapshot(name)
+}
+
+func (m *machine) Restore(name string) error {
+	return m.inst.Restore(name)
+}
+
+func (m *machine) SetNetworkConditions(c platform.NetworkConditions) error {
+	return m.inst.SetNetworkConditions(c)
+}
+
+func (m *machine) SetLinkUp(up bool) error {
+	return m.inst.SetLinkUp(up)
+}
+
+func (m *machine) UnplugDisk(serial string) error {
+	return m.inst.UnplugDisk(serial)
+}
+
+func (m *machine) PlugDisk(serial string) error {
+	return m.inst.PlugDisk(serial)
+}
+
+func (m *machine) UnplugDiskPath(serial string, path int) error {
+	return m.inst.UnplugDiskPath(serial, path)
+}
+
+func (m *machine) PlugDiskPath(serial string, path int) error {
+	return m.inst.PlugDiskPath(serial, path)
+}
+
 func (m *machine) Destroy() {
 	m.inst.Destroy()
+	if m.metadata != nil {
+		m.metadata.Close()
+	}
 
 	m.journal.Destroy()
 
@@ -0,0 +1,172 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	azureVMSize       = "Standard_D2s_v3"
+	azureWireVersion  = "2012-11-30"
+	azureContainerID  = "kola-container"
+	azureSharedConfig = "http://" + WireServerIP + "/machine/" + azureContainerID + "/instance?comp=config&type=sharedConfig&incarnation=1"
+)
+
+// serveAzureIMDS serves the Azure instance metadata service, which answers
+// JSON, or plain text for leaf values with format=text.
+func (s *Service) serveAzureIMDS(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Metadata") != "true" || r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "required metadata header not specified", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		http.Error(w, "missing api-version", http.StatusBadRequest)
+		return
+	}
+
+	var publicKeys []interface{}
+	for _, key := range s.config.SSHKeys {
+		publicKeys = append(publicKeys, map[string]interface{}{
+			"keyData": key,
+			"path":    "/home/core/.ssh/authorized_keys",
+		})
+	}
+	var v interface{} = map[string]interface{}{
+		"compute": map[string]interface{}{
+			"location":   "eastus",
+			"name":       s.config.Hostname,
+			"osType":     "Linux",
+			"publicKeys": publicKeys,
+			"vmId":       s.config.InstanceID,
+			"vmSize":     azureVMSize,
+		},
+		"network": map[string]interface{}{
+			"interface": []interface{}{
+				map[string]interface{}{
+					"ipv4": map[string]interface{}{
+						"ipAddress": []interface{}{
+							map[string]interface{}{
+								"privateIpAddress": GuestIP,
+								"publicIpAddress":  PublicIP,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/metadata/instance"), "/")
+	if path != "" {
+		for _, elem := range strings.Split(path, "/") {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				v = node[elem]
+			case []interface{}:
+				var i int
+				if _, err := fmt.Sscanf(elem, "%d", &i); err != nil || i < 0 || i >= len(node) {
+					v = nil
+				} else {
+					v = node[i]
+				}
+			default:
+				v = nil
+			}
+			if v == nil {
+				http.NotFound(w, r)
+				return
+			}
+		}
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		text, ok := v.(string)
+		if !ok {
+			http.Error(w, "format=text is only supported for leaf values", http.StatusBadRequest)
+			return
+		}
+		serveText(w, r, []byte(text), true)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v) // Ignore errors
+}
+
+// serveWireServer serves the parts of the Azure wireserver that Afterburn
+// uses: protocol versions, the goal state and shared config holding the
+// instance's addresses, and health reports.
+func (s *Service) serveWireServer(w http.ResponseWriter, r *http.Request) {
+	comp := r.URL.Query().Get("comp")
+	switch {
+	case r.URL.Path == "/" && comp == "versions":
+		serveXML(w, `<Versions>
+  <Preferred><Version>`+azureWireVersion+`</Version></Preferred>
+  <Supported><Version>`+azureWireVersion+`</Version></Supported>
+</Versions>`)
+	case strings.HasPrefix(r.URL.Path, "/machine") && comp == "goalstate":
+		serveXML(w, `<GoalState>
+  <Version>`+azureWireVersion+`</Version>
+  <Incarnation>1</Incarnation>
+  <Machine><ExpectedState>Started</ExpectedState></Machine>
+  <Container>
+    <ContainerId>`+azureContainerID+`</ContainerId>
+    <RoleInstanceList>
+      <RoleInstance>
+        <InstanceId>`+s.config.InstanceID+`</InstanceId>
+        <State>Started</State>
+        <Configuration>
+          <SharedConfig>`+strings.Replace(azureSharedConfig, "&", "&amp;", -1)+`</SharedConfig>
+        </Configuration>
+      </RoleInstance>
+    </RoleInstanceList>
+  </Container>
+</GoalState>`)
+	case strings.HasPrefix(r.URL.Path, "/machine/") && comp == "config" && r.URL.Query().Get("type") == "sharedConfig":
+		// No load balancer, hence no endpoints with a virtual address
+		serveXML(w, `<SharedConfig version="1.0.0.0" goalStateIncarnation="1">
+  <Incarnation number="1" instance="`+s.config.InstanceID+`" guid="{00000000-0000-0000-0000-000000000000}" />
+  <Instances>
+    <Instance id="`+s.config.InstanceID+`" address="`+GuestIP+`">
+      <InputEndpoints />
+    </Instance>
+  </Instances>
+</SharedConfig>`)
+	case strings.HasPrefix(r.URL.Path, "/machine") && comp == "health":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || !strings.Contains(string(body), "<State>Ready</State>") {
+			http.Error(w, "malformed health report", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.readyReports++
+		s.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func serveXML(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + body)) // Ignore errors
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/system"
+)
+
+const (
+	ec2TokenPath      = "/latest/api/token"
+	ec2TokenHeader    = "X-aws-ec2-metadata-token"
+	ec2TokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	ec2MaxTokenTTL    = 6 * 60 * 60
+
+	ec2Region = "us-east-1"
+	ec2Zone   = "us-east-1a"
+)
+
+// ec2Version matches the API version prefix of EC2 metadata paths,
+// "latest" or a release date.
+var ec2Version = regexp.MustCompile(`^/(latest|\d{4}-\d{2}-\d{2})(/|$)`)
+
+// ec2Handler serves the EC2 instance metadata service. OpenStack's is a
+// subset of it which doesn't know about IMDSv2 session tokens, and only
+// the AWS flavor, with tokens, has instance identity documents.
+func (s *Service) ec2Handler(aws bool) http.Handler {
+	values := tree{
+		"meta-data/ami-id":                      "ami-0c0c0c0c0c0c0c0c0",
+		"meta-data/hostname":                    s.config.Hostname,
+		"meta-data/instance-id":                 s.config.InstanceID,
+		"meta-data/instance-type":               "t3.small",
+		"meta-data/local-hostname":              s.config.Hostname,
+		"meta-data/local-ipv4":                  GuestIP,
+		"meta-data/placement/availability-zone": ec2Zone,
+		"meta-data/public-hostname":             fmt.Sprintf("ec2-%s.compute-1.amazonaws.com", strings.Replace(PublicIP, ".", "-", -1)),
+		"meta-data/public-ipv4":                 PublicIP,
+	}
+	if !aws {
+		values["meta-data/instance-type"] = "m1.small"
+		values["meta-data/placement/availability-zone"] = "nova"
+	}
+	for i, key := range s.config.SSHKeys {
+		values[fmt.Sprintf("meta-data/public-keys/%d/openssh-key", i)] = key
+	}
+	if s.config.UserData != nil {
+		values["user-data"] = string(s.config.UserData)
+	}
+	if aws {
+		doc, _ := json.MarshalIndent(map[string]string{
+			"accountId":        "123456789012",
+			"architecture":     system.RpmArch(),
+			"availabilityZone": ec2Zone,
+			"imageId":          values["meta-data/ami-id"],
+			"instanceId":       s.config.InstanceID,
+			"instanceType":     values["meta-data/instance-type"],
+			"privateIp":        GuestIP,
+			"region":           ec2Region,
+			"version":          "2017-09-30",
+		}, "", "  ")
+		values["dynamic/instance-identity/document"] = string(doc)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if aws && r.URL.Path == ec2TokenPath {
+			s.serveEC2Token(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if aws && !s.checkEC2Token(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		m := ec2Version.FindString(r.URL.Path)
+		if m == "" {
+			http.NotFound(w, r)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, m)
+		// The public-keys listing only shows "<index>=<name>" entries
+		if strings.TrimSuffix(path, "/") == "meta-data/public-keys" {
+			var entries []string
+			for i := range s.config.SSHKeys {
+				entries = append(entries, fmt.Sprintf("%d=kola-%d", i, i))
+			}
+			serveText(w, r, []byte(strings.Join(entries, "\n")), len(entries) > 0)
+			return
+		}
+		body, ok := values.get(path)
+		serveText(w, r, body, ok)
+	})
+}
+
+// serveEC2Token hands out an IMDSv2 session token for the requested TTL.
+func (s *Service) serveEC2Token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get(ec2TokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > ec2MaxTokenTTL {
+		http.Error(w, "invalid token TTL", http.StatusBadRequest)
+		return
+	}
+	buf := make([]byte, 40)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := base64.StdEncoding.EncodeToString(buf)
+
+	s.mu.Lock()
+	s.tokens[token] = time.Now().Add(time.Duration(ttl) * time.Second)
+	s.mu.Unlock()
+
+	w.Header().Set(ec2TokenTTLHeader, strconv.Itoa(ttl))
+	serveText(w, r, []byte(token), true)
+}
+
+// checkEC2Token returns whether r may read metadata: it must carry a valid
+// session token, unless it carries none and tokens are optional.
+func (s *Service) checkEC2Token(r *http.Request) bool {
+	token := r.Header.Get(ec2TokenHeader)
+	if token == "" {
+		return !s.config.RequireToken
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
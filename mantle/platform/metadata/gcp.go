@@ -0,0 +1,81 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// GCPHostname is the name guests look the GCE metadata server up by.
+	GCPHostname = "metadata.google.internal"
+
+	gcpFlavorHeader = "Metadata-Flavor"
+	gcpFlavor       = "Google"
+	gcpProject      = "projects/123456789012"
+)
+
+// gcpHostsUnit makes GCPHostname resolve to MetadataIP in the real root.
+// Ignition resolves it in the initramfs through Service.Initrd.
+const gcpHostsUnit = `[Unit]
+Description=Resolve ` + GCPHostname + ` to the emulated metadata server
+DefaultDependencies=no
+After=local-fs.target
+Before=afterburn.service afterburn-sshkeys@core.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh -c 'grep -q ` + GCPHostname + ` /etc/hosts || echo "` + MetadataIP + ` ` + GCPHostname + `" >> /etc/hosts'
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func (s *Service) serveGCP(w http.ResponseWriter, r *http.Request) {
+	// The real server refuses requests which could have been forwarded
+	// on behalf of someone else
+	if r.Header.Get(gcpFlavorHeader) != gcpFlavor || r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+		return
+	}
+	w.Header().Set(gcpFlavorHeader, gcpFlavor)
+
+	values := tree{
+		"instance/hostname":     s.config.Hostname,
+		"instance/id":           s.config.InstanceID,
+		"instance/machine-type": gcpProject + "/machineTypes/n1-standard-1",
+		"instance/name":         s.config.Hostname,
+		"instance/network-interfaces/0/access-configs/0/external-ip": PublicIP,
+		"instance/network-interfaces/0/access-configs/0/type":        "ONE_TO_ONE_NAT",
+		"instance/network-interfaces/0/ip":                           GuestIP,
+		"instance/zone":                                              gcpProject + "/zones/us-central1-a",
+		"project/numeric-project-id":                                 strings.TrimPrefix(gcpProject, "projects/"),
+		"project/project-id":                                         "kola",
+	}
+	if s.config.UserData != nil {
+		values["instance/attributes/user-data"] = string(s.config.UserData)
+	}
+	if len(s.config.SSHKeys) > 0 {
+		var keys []string
+		for _, key := range s.config.SSHKeys {
+			keys = append(keys, "core:"+key)
+		}
+		values["instance/attributes/ssh-keys"] = strings.Join(keys, "\n")
+	}
+	body, ok := values.get(strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/"))
+	serveText(w, r, body, ok)
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const azureOvfEnv = `<?xml version="1.0" encoding="utf-8"?>
+<Environment xmlns="http://schemas.dmtf.org/ovf/environment/1" xmlns:wa="http://schemas.microsoft.com/windowsazure">
+  <wa:ProvisioningSection>
+    <wa:Version>1.0</wa:Version>
+    <LinuxProvisioningConfigurationSet xmlns="http://schemas.microsoft.com/windowsazure">
+      <ConfigurationSetType>LinuxProvisioningConfiguration</ConfigurationSetType>
+      <HostName>%s</HostName>
+      <UserName>core</UserName>
+      <DisableSshPasswordAuthentication>true</DisableSshPasswordAuthentication>
+      <CustomData>%s</CustomData>
+    </LinuxProvisioningConfigurationSet>
+  </wa:ProvisioningSection>
+</Environment>
+`
+
+// Media writes the removable medium the platform attaches to instances to
+// dir, and returns its path or "" if there is none: the UDF provisioning
+// disk holding CustomData.bin on Azure and the config-2 config drive on
+// OpenStack. On Azure, Ignition only reads its config from there.
+func (s *Service) Media(dir string) (string, error) {
+	files := make(map[string][]byte)
+	var args []string
+	switch s.Platform {
+	case "azure":
+		files["ovf-env.xml"] = []byte(fmt.Sprintf(azureOvfEnv, s.config.Hostname,
+			base64.StdEncoding.EncodeToString(s.config.UserData)))
+		if s.config.UserData != nil {
+			files["CustomData.bin"] = s.config.UserData
+		}
+		// Ignition only mounts it as UDF
+		args = []string{"-udf"}
+	case "openstack":
+		for name, data := range s.openStackFiles() {
+			files[filepath.Join("openstack", "latest", name)] = data
+		}
+		args = []string{"-V", "config-2", "-R", "-J"}
+	default:
+		return "", nil
+	}
+
+	root, err := ioutil.TempDir(dir, "media-root")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(root)
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	iso := filepath.Join(dir, s.Platform+"-media.iso")
+	cmd := exec.Command("genisoimage", append(append([]string{"-quiet", "-o", iso}, args...), root)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "running genisoimage")
+	}
+	return iso, nil
+}
+
+// Initrd writes a cpio archive to be loaded after the initramfs to dir,
+// and returns its path or "" if the platform needs none. On GCE it adds
+// an /etc/hosts resolving GCPHostname, for Ignition to find its config.
+func (s *Service) Initrd(dir string) (string, error) {
+	if s.Platform != "gcp" {
+		return "", nil
+	}
+	hosts := "127.0.0.1   localhost localhost.localdomain localhost4 localhost4.localdomain4\n" +
+		"::1         localhost localhost.localdomain localhost6 localhost6.localdomain6\n" +
+		MetadataIP + " " + GCPHostname + "\n"
+
+	var buf bytes.Buffer
+	writeCpioEntry(&buf, "etc", 040755, nil)
+	writeCpioEntry(&buf, "etc/hosts", 0100644, []byte(hosts))
+	writeCpioEntry(&buf, "TRAILER!!!", 0, nil)
+
+	path := filepath.Join(dir, "metadata-initrd.img")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeCpioEntry appends a file to an archive in the "newc" format that
+// the kernel unpacks initramfs images from.
+func writeCpioEntry(buf *bytes.Buffer, name string, mode int, data []byte) {
+	nlink := 1
+	if mode&040000 != 0 {
+		nlink = 2
+	}
+	// magic, ino, mode, uid, gid, nlink, mtime, filesize, devmajor,
+	// devminor, rdevmajor, rdevminor, namesize, check
+	fmt.Fprintf(buf, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		0, mode, 0, 0, nlink, 0, len(data), 0, 0, 0, 0, len(name)+1, 0)
+	buf.WriteString(name)
+	buf.WriteByte(0)
+	cpioPad(buf)
+	buf.Write(data)
+	cpioPad(buf)
+}
+
+// cpioPad aligns the archive to 4 bytes.
+func cpioPad(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metadata emulates the instance metadata services of cloud
+// platforms on the host, so that QEMU guests booted with the matching
+// ignition.platform.id take the same Ignition and Afterburn code paths as
+// on the real cloud: the EC2 IMDS with IMDSv2 session tokens, the GCE
+// metadata server, the Azure IMDS and wireserver, and the OpenStack
+// metadata service and config drive.
+package metadata
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+const (
+	// Network is the usermode network of guests using an emulated
+	// service. QEMU only forwards guest connections to addresses inside
+	// it, and it must hold both MetadataIP and WireServerIP.
+	Network = "168.0.0.0/7"
+	// GuestIP is the address guests get on Network over DHCP.
+	GuestIP = "168.0.0.15"
+	// PublicIP is the address reported as the public one of guests.
+	PublicIP = "203.0.113.15"
+
+	// MetadataIP is where guests reach the metadata service, on port 80.
+	MetadataIP = "169.254.169.254"
+	// WireServerIP is where Azure guests reach the wireserver, on port 80.
+	WireServerIP = "168.63.129.16"
+)
+
+// Platforms are the ignition.platform.id values which can be emulated.
+var Platforms = []string{"aws", "azure", "gcp", "openstack"}
+
+var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/metadata")
+
+// Config describes the instance served by a Service.
+type Config struct {
+	InstanceID string
+	Hostname   string
+	// UserData is the instance user data, usually an Ignition config.
+	// None is served if it is nil.
+	UserData []byte
+	// SSHKeys are authorized_keys lines for the core user.
+	SSHKeys []string
+	// RequireToken makes the EC2 IMDS reject requests without an IMDSv2
+	// session token, as with HttpTokens=required.
+	RequireToken bool
+}
+
+// Service is a running metadata service for one instance.
+type Service struct {
+	// Platform is the emulated ignition.platform.id.
+	Platform string
+
+	config Config
+	srv    *http.Server
+	addr   string
+
+	mu sync.Mutex
+	// tokens are the valid IMDSv2 session tokens, with their expiry
+	tokens map[string]time.Time
+	// readyReports counts the Azure wireserver health reports
+	readyReports int
+}
+
+// Supported returns whether platformID can be emulated.
+func Supported(platformID string) bool {
+	for _, p := range Platforms {
+		if p == platformID {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigureGuest adds to c what guests of platformID need to reach the
+// emulated service once booted.
+func ConfigureGuest(platformID string, c *conf.Conf) {
+	if platformID == "gcp" {
+		c.AddSystemdUnit("kola-metadata-hosts.service", gcpHostsUnit, conf.Enable)
+	}
+}
+
+// Start starts a service emulating platformID for the instance described
+// by config, on the loopback interface.
+func Start(platformID string, config Config) (*Service, error) {
+	s := &Service{
+		Platform: platformID,
+		config:   config,
+		tokens:   make(map[string]time.Time),
+	}
+	mux := http.NewServeMux()
+	switch platformID {
+	case "aws":
+		mux.Handle("/", s.ec2Handler(true))
+	case "azure":
+		mux.HandleFunc("/metadata/", s.serveAzureIMDS)
+		mux.HandleFunc("/", s.serveWireServer)
+	case "gcp":
+		mux.HandleFunc("/computeMetadata/v1/", s.serveGCP)
+	case "openstack":
+		mux.HandleFunc("/openstack/", s.serveOpenStack)
+		mux.Handle("/", s.ec2Handler(false))
+	default:
+		return nil, fmt.Errorf("cannot emulate the metadata service of %q", platformID)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s.addr = l.Addr().String()
+	s.srv = &http.Server{Handler: mux}
+	go func() {
+		if err := s.srv.Serve(l); err != http.ErrServerClosed {
+			plog.Errorf("Metadata service on %s failed: %v", s.addr, err)
+		}
+	}()
+	return s, nil
+}
+
+// Close stops the service.
+func (s *Service) Close() error {
+	return s.srv.Close()
+}
+
+// Addr returns the host address of the service.
+func (s *Service) Addr() string {
+	return s.addr
+}
+
+// GuestForwardPorts returns the forwards making the service reachable
+// from guests on Network.
+func (s *Service) GuestForwardPorts() ([]platform.GuestForwardPort, error) {
+	ips := []string{MetadataIP}
+	if s.Platform == "azure" {
+		ips = append(ips, WireServerIP)
+	}
+	var fwds []platform.GuestForwardPort
+	for _, ip := range ips {
+		fwd, err := platform.GuestRelay(ip, 80, s.addr)
+		if err != nil {
+			return nil, err
+		}
+		fwds = append(fwds, fwd)
+	}
+	return fwds, nil
+}
+
+// ReadyReports returns how many times the guest reported itself ready to
+// the Azure wireserver, as afterburn-checkin.service does.
+func (s *Service) ReadyReports() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readyReports
+}
+
+// tree holds metadata values by their slash-separated path.
+type tree map[string]string
+
+// get returns the value at path or, if path is a directory, its listing
+// in the format shared by the EC2 and GCE services: one entry per line,
+// subdirectories with a trailing slash.
+func (t tree) get(path string) ([]byte, bool) {
+	path = strings.TrimPrefix(path, "/")
+	if v, ok := t[path]; ok {
+		return []byte(v), true
+	}
+	dir := strings.TrimSuffix(path, "/") + "/"
+	if dir == "/" {
+		dir = ""
+	}
+	seen := make(map[string]bool)
+	var entries []string
+	for key := range t {
+		if !strings.HasPrefix(key, dir) {
+			continue
+		}
+		entry := strings.TrimPrefix(key, dir)
+		if i := strings.Index(entry, "/"); i >= 0 {
+			entry = entry[:i+1]
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, false
+	}
+	sort.Strings(entries)
+	return []byte(strings.Join(entries, "\n")), true
+}
+
+// serveText writes body as a plain text response, or a 404 unless ok.
+func serveText(w http.ResponseWriter, r *http.Request, body []byte, ok bool) {
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(body) // Ignore errors
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testConfig = Config{
+	InstanceID: "i-kola",
+	Hostname:   "qemu0",
+	UserData:   []byte(`{"ignition": {"version": "3.0.0"}}`),
+	SSHKeys:    []string{"ssh-ed25519 AAAA kola"},
+}
+
+func start(t *testing.T, platformID string, config Config) *Service {
+	s, err := Start(platformID, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// do sends a request to s and returns the response status and body.
+func do(t *testing.T, s *Service, method, path string, header map[string]string, body string) (int, string) {
+	req, err := http.NewRequest(method, "http://"+s.Addr()+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(buf)
+}
+
+type request struct {
+	method string
+	path   string
+	header map[string]string
+	status int
+	body   string
+}
+
+func check(t *testing.T, s *Service, requests []request) {
+	for _, r := range requests {
+		if r.method == "" {
+			r.method = http.MethodGet
+		}
+		status, body := do(t, s, r.method, r.path, r.header, "")
+		if status != r.status {
+			t.Errorf("%s %s: got status %d, expected %d", r.method, r.path, status, r.status)
+		}
+		if r.body != "" && body != r.body {
+			t.Errorf("%s %s: got %q, expected %q", r.method, r.path, body, r.body)
+		}
+	}
+}
+
+func TestEC2(t *testing.T) {
+	s := start(t, "aws", testConfig)
+	defer s.Close()
+
+	status, token := do(t, s, http.MethodPut, "/latest/api/token", map[string]string{ec2TokenTTLHeader: "60"}, "")
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("getting token: status %d", status)
+	}
+	check(t, s, []request{
+		{path: "/2019-10-01/user-data", status: 200, body: string(testConfig.UserData)},
+		{path: "/latest/meta-data/local-ipv4", status: 200, body: GuestIP},
+		{path: "/latest/meta-data/placement/", status: 200, body: "availability-zone"},
+		{path: "/latest/meta-data/public-keys/", status: 200, body: "0=kola-0"},
+		{path: "/latest/meta-data/public-keys/0/", status: 200, body: "openssh-key"},
+		{path: "/latest/meta-data/public-keys/0/openssh-key", status: 200, body: testConfig.SSHKeys[0]},
+		{path: "/latest/meta-data/hostname", header: map[string]string{ec2TokenHeader: token}, status: 200, body: "qemu0"},
+		{path: "/latest/meta-data/hostname", header: map[string]string{ec2TokenHeader: "bogus"}, status: 401},
+		{path: "/latest/meta-data/missing", status: 404},
+		{path: "/openstack/latest/user_data", status: 404},
+		{method: http.MethodPut, path: "/latest/api/token", status: 400},
+	})
+
+	_, doc := do(t, s, http.MethodGet, "/latest/dynamic/instance-identity/document", nil, "")
+	var identity map[string]string
+	if err := json.Unmarshal([]byte(doc), &identity); err != nil || identity["region"] != ec2Region {
+		t.Errorf("identity document: got %q, %v", doc, err)
+	}
+
+	config := testConfig
+	config.RequireToken = true
+	v2 := start(t, "aws", config)
+	defer v2.Close()
+	check(t, v2, []request{
+		{path: "/latest/meta-data/hostname", status: 401},
+	})
+}
+
+func TestGCP(t *testing.T) {
+	s := start(t, "gcp", testConfig)
+	defer s.Close()
+
+	flavor := map[string]string{gcpFlavorHeader: gcpFlavor}
+	check(t, s, []request{
+		{path: "/computeMetadata/v1/instance/attributes/user-data", header: flavor, status: 200, body: string(testConfig.UserData)},
+		{path: "/computeMetadata/v1/instance/attributes/ssh-keys", header: flavor, status: 200, body: "core:" + testConfig.SSHKeys[0]},
+		{path: "/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip", header: flavor, status: 200, body: PublicIP},
+		{path: "/computeMetadata/v1/instance/attributes/block-project-ssh-keys", header: flavor, status: 404},
+		{path: "/computeMetadata/v1/instance/hostname", status: 403},
+		{path: "/latest/meta-data/hostname", status: 404},
+	})
+}
+
+func TestAzure(t *testing.T) {
+	s := start(t, "azure", testConfig)
+	defer s.Close()
+
+	imds := map[string]string{"Metadata": "true"}
+	check(t, s, []request{
+		{path: "/metadata/instance/compute/vmSize?api-version=2017-08-01&format=text", header: imds, status: 200, body: azureVMSize},
+		{path: "/metadata/instance/network/interface/0/ipv4/ipAddress/0/privateIpAddress?api-version=2017-08-01&format=text", header: imds, status: 200, body: GuestIP},
+		{path: "/metadata/instance/compute/vmSize?api-version=2017-08-01&format=text", status: 400},
+		{path: "/metadata/instance/compute/vmSize?format=text", header: imds, status: 400},
+		{path: "/metadata/instance/compute/missing?api-version=2017-08-01", header: imds, status: 404},
+		{path: "/?comp=versions", status: 200},
+		{path: "/machine/?comp=goalstate", status: 200},
+	})
+
+	_, shared := do(t, s, http.MethodGet, strings.TrimPrefix(azureSharedConfig, "http://"+WireServerIP), nil, "")
+	if !strings.Contains(shared, `address="`+GuestIP+`"`) {
+		t.Errorf("shared config lacks the instance address: %q", shared)
+	}
+
+	health := `<Health><GoalStateIncarnation>1</GoalStateIncarnation><Container><ContainerId>` + azureContainerID +
+		`</ContainerId><RoleInstanceList><Role><InstanceId>i-kola</InstanceId><Health><State>Ready</State></Health></Role></RoleInstanceList></Container></Health>`
+	if status, _ := do(t, s, http.MethodPost, "/machine/?comp=health", nil, health); status != http.StatusOK {
+		t.Errorf("health report: got status %d", status)
+	}
+	if n := s.ReadyReports(); n != 1 {
+		t.Errorf("got %d ready reports, expected 1", n)
+	}
+}
+
+func TestOpenStack(t *testing.T) {
+	s := start(t, "openstack", testConfig)
+	defer s.Close()
+
+	check(t, s, []request{
+		{path: "/openstack/latest/user_data", status: 200, body: string(testConfig.UserData)},
+		{path: "/latest/meta-data/public-keys/0/openssh-key", status: 200, body: testConfig.SSHKeys[0]},
+		{path: "/latest/meta-data/placement/availability-zone", status: 200, body: "nova"},
+		{method: http.MethodPut, path: "/latest/api/token", status: 405},
+	})
+
+	_, body := do(t, s, http.MethodGet, "/openstack/latest/meta_data.json", nil, "")
+	var metaData struct {
+		UUID       string            `json:"uuid"`
+		PublicKeys map[string]string `json:"public_keys"`
+	}
+	if err := json.Unmarshal([]byte(body), &metaData); err != nil {
+		t.Fatal(err)
+	}
+	if metaData.UUID != testConfig.InstanceID || metaData.PublicKeys["kola-0"] != testConfig.SSHKeys[0] {
+		t.Errorf("meta_data.json: got %q", body)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	if _, err := Start("packet", testConfig); err == nil {
+		t.Errorf("started a service for an unsupported platform")
+	}
+}
+
+func TestInitrd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata-initrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := start(t, "gcp", testConfig)
+	defer s.Close()
+	path, err := s.Initrd(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf), "070701") || len(buf)%4 != 0 {
+		t.Errorf("malformed cpio archive")
+	}
+	for _, s := range []string{"etc/hosts\x00", MetadataIP + " " + GCPHostname, "TRAILER!!!\x00"} {
+		if !strings.Contains(string(buf), s) {
+			t.Errorf("archive lacks %q", s)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// openStackFiles returns the files of the OpenStack metadata format, by
+// path under openstack/latest/, as served by the metadata service and
+// written to config drives.
+func (s *Service) openStackFiles() map[string][]byte {
+	publicKeys := make(map[string]string)
+	var keys []map[string]string
+	for i, key := range s.config.SSHKeys {
+		name := fmt.Sprintf("kola-%d", i)
+		publicKeys[name] = key
+		keys = append(keys, map[string]string{
+			"name": name,
+			"type": "ssh",
+			"data": key,
+		})
+	}
+	metaData, _ := json.Marshal(map[string]interface{}{
+		"availability_zone": "nova",
+		"hostname":          s.config.Hostname,
+		"keys":              keys,
+		"launch_index":      0,
+		"name":              s.config.Hostname,
+		"project_id":        "kola",
+		"public_keys":       publicKeys,
+		"uuid":              s.config.InstanceID,
+	})
+	files := map[string][]byte{
+		"meta_data.json": metaData,
+	}
+	if s.config.UserData != nil {
+		files["user_data"] = s.config.UserData
+	}
+	return files
+}
+
+func (s *Service) serveOpenStack(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/openstack"), "/")
+	if path == "" {
+		serveText(w, r, []byte("latest"), true)
+		return
+	}
+	if !strings.HasPrefix(path, "latest/") {
+		http.NotFound(w, r)
+		return
+	}
+	body, ok := s.openStackFiles()[strings.TrimPrefix(path, "latest/")]
+	serveText(w, r, body, ok)
+}
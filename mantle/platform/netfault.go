@@ -0,0 +1,335 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// netShaperQueueLen is how many packets each direction of the shaper
+// holds back before dropping new ones, like a router's tail drop.
+const netShaperQueueLen = 1000
+
+// netShaperMaxFrame bounds the frames accepted from QEMU.
+const netShaperMaxFrame = 65536
+
+// NetworkConditions degrade the primary NIC of a QEMU machine. They apply
+// to each direction separately. The zero value is a perfect link.
+type NetworkConditions struct {
+	// Latency delays each packet.
+	Latency time.Duration
+	// Jitter adds a random delay of up to Jitter to each packet, without
+	// reordering them.
+	Jitter time.Duration
+	// Loss is the probability of dropping each packet, from 0 to 1.
+	Loss float64
+	// Bandwidth if set limits throughput, in bytes per second.
+	Bandwidth int64
+}
+
+func (c NetworkConditions) validate() error {
+	if c.Latency < 0 || c.Jitter < 0 {
+		return fmt.Errorf("negative network latency")
+	}
+	if c.Loss < 0 || c.Loss > 1 {
+		return fmt.Errorf("network loss %v not between 0 and 1", c.Loss)
+	}
+	if c.Bandwidth < 0 {
+		return fmt.Errorf("negative network bandwidth")
+	}
+	return nil
+}
+
+// NetworkFault is a change to the primary NIC of a QEMU machine at a time
+// after QEMU starts: the link goes down, or comes up with Conditions.
+type NetworkFault struct {
+	At         time.Duration
+	LinkDown   bool
+	Conditions NetworkConditions
+}
+
+// netShaper relays the packets of a usermode netdev through the host, to
+// apply NetworkConditions to them. For each direction, a QEMU
+// filter-redirector hands packets over a socket and takes the shaped ones
+// back over another; QEMU connects to both, framing each packet with its
+// length as a big-endian uint32.
+type netShaper struct {
+	dir       string
+	listeners []net.Listener
+
+	mu     sync.Mutex
+	conns  []net.Conn
+	closed bool
+
+	rx *netShaperQueue // from the guest
+	tx *netShaperQueue // to the guest
+}
+
+// newNetShaper listens on sockets in dir for QEMU to connect to.
+func newNetShaper(dir string) (*netShaper, error) {
+	s := &netShaper{
+		dir: dir,
+		rx:  newNetShaperQueue(),
+		tx:  newNetShaperQueue(),
+	}
+	for _, name := range []string{"rx-out", "rx-in", "tx-out", "tx-in"} {
+		l, err := net.Listen("unix", s.socket(name))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.listeners = append(s.listeners, l)
+	}
+	go s.relay(s.listeners[0], s.listeners[1], s.rx)
+	go s.relay(s.listeners[2], s.listeners[3], s.tx)
+	return s, nil
+}
+
+func (s *netShaper) socket(name string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("netshaper-%s.sock", name))
+}
+
+// args returns the qemu arguments redirecting the packets of netdev
+// through the shaper.
+func (s *netShaper) args(netdev string) []string {
+	var args []string
+	for _, queue := range []string{"rx", "tx"} {
+		out := fmt.Sprintf("%s-%s-out", netdev, queue)
+		in := fmt.Sprintf("%s-%s-in", netdev, queue)
+		args = append(args,
+			"-chardev", fmt.Sprintf("socket,id=%s,path=%s", out, s.socket(queue+"-out")),
+			"-chardev", fmt.Sprintf("socket,id=%s,path=%s", in, s.socket(queue+"-in")),
+			"-object", fmt.Sprintf("filter-redirector,id=%s-%s,netdev=%s,queue=%s,outdev=%s,indev=%s",
+				netdev, queue, netdev, queue, out, in))
+	}
+	return args
+}
+
+// SetConditions applies c to both directions from now on. Packets
+// already queued keep their schedule.
+func (s *netShaper) SetConditions(c NetworkConditions) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	s.rx.setConditions(c)
+	s.tx.setConditions(c)
+	return nil
+}
+
+// Close stops relaying packets. The link of the instance goes dead.
+func (s *netShaper) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	s.rx.close()
+	s.tx.close()
+	return nil
+}
+
+// accept waits for QEMU to connect to l.
+func (s *netShaper) accept(l net.Listener) (net.Conn, error) {
+	c, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		c.Close()
+		return nil, fmt.Errorf("network shaper closed")
+	}
+	s.conns = append(s.conns, c)
+	return c, nil
+}
+
+// relay shapes the packets QEMU sends to the out socket and sends them
+// back to QEMU over the in socket.
+func (s *netShaper) relay(outL, inL net.Listener, q *netShaperQueue) {
+	out, err := s.accept(outL)
+	if err != nil {
+		s.relayFailed(err)
+		return
+	}
+	in, err := s.accept(inL)
+	if err != nil {
+		s.relayFailed(err)
+		return
+	}
+	go func() {
+		for {
+			frame, err := readNetFrame(out)
+			if err != nil {
+				s.relayFailed(err)
+				q.close()
+				return
+			}
+			q.push(frame, time.Now())
+		}
+	}()
+	for {
+		frame, ok := q.pop()
+		if !ok {
+			return
+		}
+		if err := writeNetFrame(in, frame); err != nil {
+			s.relayFailed(err)
+			return
+		}
+	}
+}
+
+func (s *netShaper) relayFailed(err error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed && err != io.EOF {
+		plog.Errorf("Network shaper failed: %v", err)
+	}
+}
+
+func readNetFrame(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > netShaperMaxFrame {
+		return nil, fmt.Errorf("oversized frame of %d bytes", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func writeNetFrame(w io.Writer, frame []byte) error {
+	buf := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	copy(buf[4:], frame)
+	_, err := w.Write(buf)
+	return err
+}
+
+// netShaperPacket is a packet held back until due.
+type netShaperPacket struct {
+	frame []byte
+	due   time.Time
+}
+
+// netShaperQueue holds back the packets of one direction of the shaper.
+type netShaperQueue struct {
+	mu    sync.Mutex
+	cond  NetworkConditions
+	rand  *rand.Rand
+	last  time.Time // when the last queued packet is due
+	busy  time.Time // when the link is done sending the queued packets
+	queue chan netShaperPacket
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newNetShaperQueue() *netShaperQueue {
+	return &netShaperQueue{
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		queue: make(chan netShaperPacket, netShaperQueueLen),
+		done:  make(chan struct{}),
+	}
+}
+
+func (q *netShaperQueue) setConditions(c NetworkConditions) {
+	q.mu.Lock()
+	q.cond = c
+	q.mu.Unlock()
+}
+
+// schedule returns when a packet of size bytes received at now is due, or
+// false if it is lost.
+func (q *netShaperQueue) schedule(size int, now time.Time) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c := q.cond
+	if c.Loss > 0 && q.rand.Float64() < c.Loss {
+		return time.Time{}, false
+	}
+	due := now
+	if c.Bandwidth > 0 {
+		if q.busy.Before(now) {
+			q.busy = now
+		}
+		q.busy = q.busy.Add(time.Duration(int64(size) * int64(time.Second) / c.Bandwidth))
+		due = q.busy
+	}
+	due = due.Add(c.Latency)
+	if c.Jitter > 0 {
+		due = due.Add(time.Duration(q.rand.Int63n(int64(c.Jitter) + 1)))
+	}
+	// Keep packets in order
+	if due.Before(q.last) {
+		due = q.last
+	}
+	q.last = due
+	return due, true
+}
+
+// push queues frame, unless it is lost or the queue is full.
+func (q *netShaperQueue) push(frame []byte, now time.Time) {
+	due, ok := q.schedule(len(frame), now)
+	if !ok {
+		return
+	}
+	select {
+	case q.queue <- netShaperPacket{frame, due}:
+	default:
+	}
+}
+
+// pop waits for the next packet to be due, returning false once the queue
+// is closed.
+func (q *netShaperQueue) pop() ([]byte, bool) {
+	select {
+	case p := <-q.queue:
+		if wait := time.Until(p.due); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-q.done:
+				return nil, false
+			}
+		}
+		return p.frame, true
+	case <-q.done:
+		return nil, false
+	}
+}
+
+func (q *netShaperQueue) close() {
+	q.once.Do(func() { close(q.done) })
+}
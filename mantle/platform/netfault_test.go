@@ -0,0 +1,120 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNetShaperSchedule(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	q := newNetShaperQueue()
+	if due, ok := q.schedule(100, now); !ok || !due.Equal(now) {
+		t.Errorf("perfect link: got %v, %v", due, ok)
+	}
+
+	q = newNetShaperQueue()
+	q.setConditions(NetworkConditions{Loss: 1})
+	if _, ok := q.schedule(100, now); ok {
+		t.Errorf("total loss: packet not dropped")
+	}
+
+	q = newNetShaperQueue()
+	q.setConditions(NetworkConditions{Latency: time.Second})
+	if due, _ := q.schedule(100, now); !due.Equal(now.Add(time.Second)) {
+		t.Errorf("latency: due at %v", due)
+	}
+
+	q = newNetShaperQueue()
+	q.setConditions(NetworkConditions{Bandwidth: 1000})
+	q.schedule(500, now)
+	if due, _ := q.schedule(500, now); !due.Equal(now.Add(time.Second)) {
+		t.Errorf("bandwidth: second packet due at %v", due)
+	}
+	if due, _ := q.schedule(500, now.Add(5*time.Second)); !due.Equal(now.Add(5*time.Second + 500*time.Millisecond)) {
+		t.Errorf("bandwidth: packet after idle link due at %v", due)
+	}
+
+	q = newNetShaperQueue()
+	q.setConditions(NetworkConditions{Jitter: time.Second})
+	var last time.Time
+	for i := 0; i < 100; i++ {
+		due, _ := q.schedule(100, now.Add(time.Duration(i)*time.Millisecond))
+		if due.Before(last) {
+			t.Fatalf("jitter: packet %d reordered", i)
+		}
+		if due.After(now.Add(time.Duration(i)*time.Millisecond + time.Second)) {
+			t.Fatalf("jitter: packet %d due at %v", i, due)
+		}
+		last = due
+	}
+}
+
+func TestNetShaperRelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netshaper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newNetShaper(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Connect like QEMU's filter-redirectors
+	conns := make(map[string]net.Conn)
+	for _, name := range []string{"rx-out", "rx-in", "tx-out", "tx-in"} {
+		c, err := net.Dial("unix", s.socket(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns[name] = c
+	}
+
+	if err := s.SetConditions(NetworkConditions{Latency: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	for _, queue := range []string{"rx", "tx"} {
+		frame := []byte("frame from " + queue)
+		start := time.Now()
+		if err := writeNetFrame(conns[queue+"-out"], frame); err != nil {
+			t.Fatal(err)
+		}
+		conns[queue+"-in"].SetReadDeadline(time.Now().Add(5 * time.Second))
+		got, err := readNetFrame(conns[queue+"-in"])
+		if err != nil {
+			t.Fatalf("%s: %v", queue, err)
+		}
+		if !bytes.Equal(got, frame) {
+			t.Errorf("%s: got frame %q", queue, got)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("%s: frame relayed after %v", queue, elapsed)
+		}
+	}
+
+	if err := s.SetConditions(NetworkConditions{Loss: 2}); err == nil {
+		t.Errorf("invalid loss accepted")
+	}
+}
@@ -73,6 +73,10 @@ type Machine interface {
 	// SSH runs a single command over a new SSH connection.
 	SSH(cmd string) ([]byte, []byte, error)
 
+	// Exec runs a command over a new SSH connection, streaming its output,
+	// and returns its exit status.
+	Exec(ctx context.Context, req ExecRequest) (*ExecResult, error)
+
 	// Reboot restarts the machine and waits for it to come back.
 	Reboot() error
 
@@ -92,6 +96,53 @@ type Machine interface {
 	JournalOutput() string
 }
 
+// SnapshotMachine is a Machine whose whole state can be saved and rolled
+// back to, such as a QEMU machine.
+type SnapshotMachine interface {
+	Machine
+
+	// Snapshot saves the state of the machine as name, replacing any
+	// previous snapshot of that name.
+	Snapshot(name string) error
+
+	// Restore rolls the machine back to the snapshot name. SSH
+	// connections to the machine are lost.
+	Restore(name string) error
+}
+
+// NetworkFaultMachine is a Machine whose primary network link can be
+// degraded or cut at runtime, such as a QEMU machine started with
+// RuntimeConfig.ShapeNetwork.
+type NetworkFaultMachine interface {
+	Machine
+
+	// SetNetworkConditions degrades the link with c from now on, or
+	// restores it with the zero value.
+	SetNetworkConditions(c NetworkConditions) error
+
+	// SetLinkUp sets the link up or down.
+	SetLinkUp(up bool) error
+}
+
+// DiskHotplugMachine is a Machine whose disks can be unplugged and plugged
+// back in at runtime, such as a QEMU machine. Disks are identified by
+// serial; additional disks are "disk1", "disk2", ... in order.
+type DiskHotplugMachine interface {
+	Machine
+
+	// UnplugDisk unplugs the disk with all its paths.
+	UnplugDisk(serial string) error
+
+	// PlugDisk plugs the disk back in.
+	PlugDisk(serial string) error
+
+	// UnplugDiskPath unplugs a path of a multipath disk, from 0.
+	UnplugDiskPath(serial string, path int) error
+
+	// PlugDiskPath plugs a path of a multipath disk back in.
+	PlugDiskPath(serial string, path int) error
+}
+
 // Cluster represents a cluster of machines within a single Flight.
 type Cluster interface {
 	// Platform returns the name of the platform.
@@ -157,6 +208,8 @@ type Flight interface {
 type MachineOptions struct {
 	AdditionalDisks []string
 	MinMemory       int
+	// DiskFaults are injected into additional disks, by index; QEMU only
+	DiskFaults map[int][]DiskFault
 }
 
 // SystemdDropin is a userdata type agnostic struct representing a systemd dropin
@@ -197,6 +250,20 @@ type RuntimeConfig struct {
 
 	// InternetAccess is true if the cluster should be Internet connected
 	InternetAccess bool
+
+	// GuestForwardPorts are host services made reachable from QEMU
+	// machines, even without InternetAccess
+	GuestForwardPorts []GuestForwardPort
+	// TFTPDir if set is served over TFTP to QEMU machines
+	TFTPDir string
+	// EmulatedPlatform if set is the ignition.platform.id QEMU machines
+	// boot as, against an emulated metadata service
+	EmulatedPlatform string
+	// ShapeNetwork makes QEMU machines NetworkFaultMachines
+	ShapeNetwork bool
+	// NetworkFaults are injected into QEMU machines on schedule after
+	// they start, implying ShapeNetwork
+	NetworkFaults []NetworkFault
 }
 
 // Wrap a StdoutPipe as a io.ReadCloser
@@ -356,40 +423,41 @@ func CopyDirToMachine(inputdir string, m Machine, destdir string) error {
 // NewMachines spawns n instances in cluster c, with
 // each instance passed the same userdata.
 func NewMachines(c Cluster, userdata *conf.UserData, n int, options MachineOptions) ([]Machine, error) {
+	userdatas := make([]*conf.UserData, n)
+	for i := range userdatas {
+		userdatas[i] = userdata
+	}
+	return NewMachinesWithUserData(c, userdatas, options)
+}
+
+// NewMachinesWithUserData spawns one instance in cluster c per entry of
+// userdatas, concurrently. The returned machines are in the same order as
+// userdatas.
+func NewMachinesWithUserData(c Cluster, userdatas []*conf.UserData, options MachineOptions) ([]Machine, error) {
 	var wg sync.WaitGroup
 
-	mchan := make(chan Machine, n)
-	errchan := make(chan error, n)
+	machs := make([]Machine, len(userdatas))
+	errs := make([]error, len(userdatas))
 
-	for i := 0; i < n; i++ {
+	for i, userdata := range userdatas {
 		wg.Add(1)
-		go func() {
+		go func(i int, userdata *conf.UserData) {
 			defer wg.Done()
-			m, err := c.NewMachineWithOptions(userdata, options)
-			if err != nil {
-				errchan <- err
-			}
-			if m != nil {
-				mchan <- m
-			}
-		}()
+			machs[i], errs[i] = c.NewMachineWithOptions(userdata, options)
+		}(i, userdata)
 	}
 
 	wg.Wait()
-	close(mchan)
-	close(errchan)
-
-	machs := []Machine{}
-
-	for m := range mchan {
-		machs = append(machs, m)
-	}
 
-	if firsterr, ok := <-errchan; ok {
-		for _, m := range machs {
-			m.Destroy()
+	for _, err := range errs {
+		if err != nil {
+			for _, m := range machs {
+				if m != nil {
+					m.Destroy()
+				}
+			}
+			return nil, err
 		}
-		return nil, firsterr
 	}
 
 	return machs, nil
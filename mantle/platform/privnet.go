@@ -0,0 +1,299 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// The private network is 10.0.3.0/24, next to the 10.0.2.0/24 that QEMU
+// usermode networking uses for the primary NIC.  The switch itself answers
+// DHCP as .1 and instances are numbered from .10 in the order they attach.
+const (
+	privnetFirstHost = 10
+	privnetLastHost  = 254
+	privnetLeaseSecs = 24 * 60 * 60
+)
+
+var (
+	privnetServerIP  = net.IPv4(10, 0, 3, 1).To4()
+	privnetServerMAC = net.HardwareAddr{0x52, 0x54, 0x00, 0x0a, 0x03, 0x01}
+	privnetMask      = net.CIDRMask(24, 32)
+	privnetHostIP    = net.IPv4(127, 0, 0, 1)
+)
+
+// DHCP message types, RFC 2132 section 9.6.
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+)
+
+// PrivateNetwork is an L2 segment shared between QEMU instances which
+// needs no privileges.  Each instance gets a second NIC backed by a QEMU
+// UDP socket netdev pointing at a userspace switch on the privnetHostIP
+// interface; the switch forwards Ethernet frames between instances and
+// hands out a fixed address to each one over DHCP.
+type PrivateNetwork struct {
+	conn *net.UDPConn
+
+	mu     sync.Mutex
+	nodes  []*privnetNode
+	closed bool
+}
+
+// privnetNode is an instance's port on the switch.
+type privnetNode struct {
+	addr     *net.UDPAddr // QEMU's end of the link
+	mac      net.HardwareAddr
+	ip       net.IP
+	attached bool
+}
+
+// NewPrivateNetwork starts the switch for a new private network.
+func NewPrivateNetwork() (*PrivateNetwork, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: privnetHostIP})
+	if err != nil {
+		return nil, err
+	}
+	n := &PrivateNetwork{
+		conn: conn,
+	}
+	go n.serve()
+	return n, nil
+}
+
+// Close stops the switch.  Instances still attached lose connectivity
+// to each other.
+func (n *PrivateNetwork) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+	return n.conn.Close()
+}
+
+// attach allocates a port and the next address on the network.
+func (n *PrivateNetwork) attach() (*privnetNode, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	host := privnetFirstHost + len(n.nodes)
+	if host > privnetLastHost {
+		return nil, fmt.Errorf("private network is full")
+	}
+	// Possible race condition between getting the port here and using it
+	// with qemu -- trade off for simpler port management
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: privnetHostIP})
+	if err != nil {
+		return nil, err
+	}
+	l.Close()
+
+	node := &privnetNode{
+		addr:     l.LocalAddr().(*net.UDPAddr),
+		mac:      net.HardwareAddr{0x52, 0x54, 0x00, 0x0a, 0x03, byte(host)},
+		ip:       net.IPv4(10, 0, 3, byte(host)).To4(),
+		attached: true,
+	}
+	n.nodes = append(n.nodes, node)
+	return node, nil
+}
+
+// detach stops forwarding frames to node.  Its address is not reused.
+func (n *PrivateNetwork) detach(node *privnetNode) {
+	n.mu.Lock()
+	node.attached = false
+	n.mu.Unlock()
+}
+
+// netdev returns the qemu -netdev argument connecting node to the switch.
+func (n *PrivateNetwork) netdev(id string, node *privnetNode) string {
+	return fmt.Sprintf("socket,id=%s,udp=%s,localaddr=%s", id, n.conn.LocalAddr(), node.addr)
+}
+
+func (n *PrivateNetwork) serve() {
+	buf := make([]byte, 65536)
+	for {
+		size, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			n.mu.Lock()
+			closed := n.closed
+			n.mu.Unlock()
+			if !closed {
+				plog.Errorf("Private network switch failed: %v", err)
+			}
+			return
+		}
+		n.forward(buf[:size], from)
+	}
+}
+
+// forward handles a frame sent by the instance at from: DHCP requests
+// are answered by the switch, anything else is delivered to the
+// destination port, or flooded if it is a broadcast or the destination
+// is unknown.
+func (n *PrivateNetwork) forward(frame []byte, from *net.UDPAddr) {
+	if len(frame) < 14 {
+		return
+	}
+
+	n.mu.Lock()
+	var src *privnetNode
+	for _, node := range n.nodes {
+		if node.attached && node.addr.String() == from.String() {
+			src = node
+			break
+		}
+	}
+	if src == nil {
+		n.mu.Unlock()
+		return
+	}
+	dst := net.HardwareAddr(frame[0:6])
+	var dsts []*net.UDPAddr
+	if dst[0]&1 == 0 {
+		for _, node := range n.nodes {
+			if node.attached && node != src && bytes.Equal(node.mac, dst) {
+				dsts = append(dsts, node.addr)
+			}
+		}
+	}
+	if len(dsts) == 0 {
+		for _, node := range n.nodes {
+			if node.attached && node != src {
+				dsts = append(dsts, node.addr)
+			}
+		}
+	}
+	n.mu.Unlock()
+
+	if reply := dhcpReply(frame, src.mac, src.ip); reply != nil {
+		if _, err := n.conn.WriteToUDP(reply, from); err != nil {
+			plog.Errorf("Sending DHCP reply to %s: %v", src.mac, err)
+		}
+		return
+	}
+	for _, addr := range dsts {
+		// Delivery is best effort, as on a real wire
+		n.conn.WriteToUDP(frame, addr) // Ignore errors
+	}
+}
+
+// dhcpReply returns the Ethernet frame answering frame if it is a DHCP
+// DISCOVER or REQUEST from mac, leasing it ip.  Otherwise it returns nil.
+func dhcpReply(frame []byte, mac net.HardwareAddr, ip net.IP) []byte {
+	if len(frame) < 14+20 || binary.BigEndian.Uint16(frame[12:14]) != 0x0800 {
+		return nil
+	}
+	ipHdr := frame[14:]
+	ihl := int(ipHdr[0]&0x0f) * 4
+	if ihl < 20 || ipHdr[9] != 17 || len(ipHdr) < ihl+8 {
+		return nil
+	}
+	udp := ipHdr[ihl:]
+	if binary.BigEndian.Uint16(udp[2:4]) != 67 {
+		return nil
+	}
+	req := udp[8:]
+	if len(req) < 240 || req[0] != 1 || !bytes.Equal(req[28:34], mac) ||
+		!bytes.Equal(req[236:240], []byte{99, 130, 83, 99}) {
+		return nil
+	}
+
+	var msgType byte
+	opts := req[240:]
+	for len(opts) > 0 && opts[0] != 255 {
+		if opts[0] == 0 {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil
+		}
+		if opts[0] == 53 && opts[1] == 1 {
+			msgType = opts[2]
+		}
+		opts = opts[2+int(opts[1]):]
+	}
+	var replyType byte
+	switch msgType {
+	case dhcpDiscover:
+		replyType = dhcpOffer
+	case dhcpRequest:
+		replyType = dhcpAck
+	default:
+		return nil
+	}
+
+	// No router or DNS options: the default route stays on the
+	// usermode NIC and only the subnet route goes through this one.
+	resp := make([]byte, 240, 300)
+	resp[0] = 2 // BOOTREPLY
+	resp[1] = 1 // Ethernet
+	resp[2] = 6
+	copy(resp[4:8], req[4:8])     // xid
+	copy(resp[10:12], req[10:12]) // flags
+	copy(resp[16:20], ip)
+	copy(resp[20:24], privnetServerIP)
+	copy(resp[28:44], req[28:44])
+	copy(resp[236:240], req[236:240])
+	lease := make([]byte, 4)
+	binary.BigEndian.PutUint32(lease, privnetLeaseSecs)
+	resp = append(resp, 53, 1, replyType)
+	resp = append(resp, 54, 4)
+	resp = append(resp, privnetServerIP...)
+	resp = append(resp, 51, 4)
+	resp = append(resp, lease...)
+	resp = append(resp, 1, 4)
+	resp = append(resp, privnetMask...)
+	resp = append(resp, 255)
+
+	// Broadcast at the IP level since the client has no address yet
+	out := make([]byte, 14+20+8, 14+20+8+len(resp))
+	copy(out[0:6], mac)
+	copy(out[6:12], privnetServerMAC)
+	binary.BigEndian.PutUint16(out[12:14], 0x0800)
+	ipOut := out[14:34]
+	ipOut[0] = 0x45
+	binary.BigEndian.PutUint16(ipOut[2:4], uint16(20+8+len(resp)))
+	ipOut[8] = 64
+	ipOut[9] = 17
+	copy(ipOut[12:16], privnetServerIP)
+	copy(ipOut[16:20], net.IPv4bcast.To4())
+	binary.BigEndian.PutUint16(ipOut[10:12], ipChecksum(ipOut))
+	udpOut := out[34:42]
+	binary.BigEndian.PutUint16(udpOut[0:2], 67)
+	binary.BigEndian.PutUint16(udpOut[2:4], 68)
+	binary.BigEndian.PutUint16(udpOut[4:6], uint16(8+len(resp)))
+	// A zero UDP checksum means none was computed, which IPv4 allows
+	return append(out, resp...)
+}
+
+// ipChecksum computes the IPv4 header checksum of hdr.
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
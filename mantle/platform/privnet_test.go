@@ -0,0 +1,153 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// dhcpClientFrame builds a DHCP client message of msgType from mac.
+func dhcpClientFrame(mac net.HardwareAddr, msgType byte) []byte {
+	msg := make([]byte, 240)
+	msg[0] = 1
+	msg[1] = 1
+	msg[2] = 6
+	copy(msg[4:8], []byte{0xde, 0xad, 0xbe, 0xef})
+	copy(msg[28:34], mac)
+	copy(msg[236:240], []byte{99, 130, 83, 99})
+	msg = append(msg, 0, 53, 1, msgType, 255)
+
+	frame := make([]byte, 42)
+	copy(frame[0:6], net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], mac)
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+	frame[14] = 0x45
+	frame[23] = 17
+	binary.BigEndian.PutUint16(frame[34:36], 68)
+	binary.BigEndian.PutUint16(frame[36:38], 67)
+	return append(frame, msg...)
+}
+
+func TestDHCPReply(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x0a, 0x03, 0x0a}
+	ip := net.IPv4(10, 0, 3, 10).To4()
+
+	tests := []struct {
+		msgType byte
+		reply   byte
+	}{
+		{dhcpDiscover, dhcpOffer},
+		{dhcpRequest, dhcpAck},
+		{7, 0}, // RELEASE
+	}
+	for _, tt := range tests {
+		out := dhcpReply(dhcpClientFrame(mac, tt.msgType), mac, ip)
+		if tt.reply == 0 {
+			if out != nil {
+				t.Errorf("message type %d: unexpected reply", tt.msgType)
+			}
+			continue
+		}
+		if out == nil {
+			t.Fatalf("message type %d: no reply", tt.msgType)
+		}
+		if !bytes.Equal(out[0:6], mac) {
+			t.Errorf("message type %d: reply sent to %s", tt.msgType, net.HardwareAddr(out[0:6]))
+		}
+		if ipChecksum(out[14:34]) != 0 {
+			t.Errorf("message type %d: bad IP header checksum", tt.msgType)
+		}
+		msg := out[42:]
+		if !bytes.Equal(msg[4:8], []byte{0xde, 0xad, 0xbe, 0xef}) {
+			t.Errorf("message type %d: xid not echoed", tt.msgType)
+		}
+		if got := net.IP(msg[16:20]); !got.Equal(ip) {
+			t.Errorf("message type %d: leased %s, expected %s", tt.msgType, got, ip)
+		}
+		if msg[240] != 53 || msg[242] != tt.reply {
+			t.Errorf("message type %d: replied with %v, expected type %d", tt.msgType, msg[240:243], tt.reply)
+		}
+	}
+
+	other := net.HardwareAddr{0x52, 0x54, 0x00, 0x0a, 0x03, 0x0b}
+	if dhcpReply(dhcpClientFrame(other, dhcpDiscover), mac, ip) != nil {
+		t.Errorf("replied to a DISCOVER from another port's MAC")
+	}
+}
+
+func TestPrivateNetworkForward(t *testing.T) {
+	n, err := NewPrivateNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	var conns []*net.UDPConn
+	var nodes []*privnetNode
+	for i := 0; i < 3; i++ {
+		node, err := n.attach()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.ListenUDP("udp4", node.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		nodes = append(nodes, node)
+	}
+	if got := nodes[2].ip.String(); got != "10.0.3.12" {
+		t.Errorf("third node got %s, expected 10.0.3.12", got)
+	}
+
+	read := func(conn *net.UDPConn) []byte {
+		buf := make([]byte, 1500)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		size, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil
+		}
+		return buf[:size]
+	}
+	send := func(conn *net.UDPConn, frame []byte) {
+		if _, err := conn.WriteToUDP(frame, n.conn.LocalAddr().(*net.UDPAddr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// DHCP is answered by the switch only
+	send(conns[0], dhcpClientFrame(nodes[0].mac, dhcpDiscover))
+	if reply := read(conns[0]); reply == nil || !net.IP(reply[42+16:42+20]).Equal(nodes[0].ip) {
+		t.Errorf("no DHCP offer for %s", nodes[0].ip)
+	}
+
+	// Unicast goes to the destination port only
+	frame := make([]byte, 60)
+	copy(frame[0:6], nodes[2].mac)
+	copy(frame[6:12], nodes[0].mac)
+	send(conns[0], frame)
+	if got := read(conns[2]); !bytes.Equal(got, frame) {
+		t.Errorf("unicast frame not delivered")
+	}
+	conns[1].SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := conns[1].ReadFromUDP(make([]byte, 1500)); err == nil {
+		t.Errorf("unicast frame delivered to a third port")
+	}
+}
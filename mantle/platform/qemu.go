@@ -38,7 +38,9 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -47,6 +49,7 @@ import (
 
 	"github.com/coreos/mantle/system"
 	"github.com/coreos/mantle/system/exec"
+	"github.com/digitalocean/go-qemu/qmp"
 	"github.com/pkg/errors"
 )
 
@@ -62,6 +65,16 @@ type HostForwardPort struct {
 	GuestPort int
 }
 
+// GuestForwardPort makes a host service reachable from the VM: each
+// connection to GuestIP:GuestPort is handed to a new instance of Command on
+// its stdin and stdout.  Unlike other traffic, it is allowed with
+// RestrictNetworking.
+type GuestForwardPort struct {
+	GuestIP   string
+	GuestPort int
+	Command   string
+}
+
 // QemuMachineOptions is specialized MachineOption struct for QEMU.
 type QemuMachineOptions struct {
 	MachineOptions
@@ -71,19 +84,45 @@ type QemuMachineOptions struct {
 
 // Disk holds the details of a virtual disk.
 type Disk struct {
-	Size          string   // disk image size in bytes, optional suffixes "K", "M", "G", "T" allowed.
-	BackingFile   string   // raw disk image to use.
-	BackingFormat string   // qcow2, raw, etc.  If unspecified will be autodetected.
-	Channel       string   // virtio (default), nvme
-	DeviceOpts    []string // extra options to pass to qemu. "serial=XXXX" makes disks show up as /dev/disk/by-id/virtio-<serial>
-	SectorSize    int      // if not 0, override disk sector size
-	NbdDisk       bool     // if true, the disks should be presented over nbd:unix socket
-	MultiPathDisk bool     // if true, present multiple paths
+	Size          string      // disk image size in bytes, optional suffixes "K", "M", "G", "T" allowed.
+	BackingFile   string      // raw disk image to use.
+	BackingFormat string      // qcow2, raw, etc.  If unspecified will be autodetected.
+	Channel       string      // virtio (default), nvme
+	DeviceOpts    []string    // extra options to pass to qemu. "serial=XXXX" makes disks show up as /dev/disk/by-id/virtio-<serial>
+	SectorSize    int         // if not 0, override disk sector size
+	NbdDisk       bool        // if true, the disks should be presented over nbd:unix socket
+	MultiPathDisk bool        // if true, present multiple paths
+	Faults        []DiskFault // errors injected into requests to the disk
+
+	attachEndPoint string      // qemuPath to attach to
+	serial         string      // identifies the disk to UnplugDisk and PlugDisk
+	paths          []*diskPath // the drive and device of each path to the disk
+	fd             *os.File    // builder file descriptor location, e.g. /proc/self/fd/
+	dstFileName    string      // the prepared file
+	nbdServCmd     exec.Cmd    // command to serve the disk
+}
+
+// ParseDiskSpec parses an additional disk given as a size, optionally
+// followed by ":mpath" to present it over multiple paths (e.g. "1G:mpath").
+func ParseDiskSpec(spec string) (size string, multipath bool, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) == 2 {
+		if parts[1] != "mpath" {
+			return "", false, fmt.Errorf("unknown option %q of disk %q", parts[1], spec)
+		}
+		multipath = true
+	}
+	return parts[0], multipath, nil
+}
 
-	attachEndPoint string   // qemuPath to attach to
-	fd             *os.File // builder file descriptor location, e.g. /proc/self/fd/
-	dstFileName    string   // the prepared file
-	nbdServCmd     exec.Cmd // command to serve the disk
+// diskPath is a QEMU drive and the device it is attached to. QEMU deletes
+// the drive along with the device, so plugging the path back in recreates
+// it with replugDrive.
+type diskPath struct {
+	id          string // of the drive
+	device      string // -device argument
+	replugDrive string // -drive argument reopening the image, empty if it can't be
+	unplugged   bool
 }
 
 // bootIso is an internal struct used by AddIso() and setupIso()
@@ -100,6 +139,14 @@ type QemuInstance struct {
 	swtpm              exec.Cmd
 	nbdServers         []exec.Cmd
 	hostForwardedPorts []HostForwardPort
+	privnet            *PrivateNetwork
+	privnetNode        *privnetNode
+	netShaper          *netShaper
+	netFaultsDone      chan struct{}
+	disks              []*Disk
+
+	// QEMU serves a single QMP client at a time
+	qmpMu sync.Mutex
 
 	journalPipe *os.File
 }
@@ -124,6 +171,15 @@ func (inst *QemuInstance) SSHAddress() (string, error) {
 	return "", fmt.Errorf("didn't find an address")
 }
 
+// PrivateIP returns the address of the instance on its private network, or
+// "" if it isn't attached to one.
+func (inst *QemuInstance) PrivateIP() string {
+	if inst.privnetNode == nil {
+		return ""
+	}
+	return inst.privnetNode.ip.String()
+}
+
 // Wait for the qemu process to exit
 func (inst *QemuInstance) Wait() error {
 	return inst.qemu.Wait()
@@ -228,6 +284,18 @@ func (inst *QemuInstance) Destroy() {
 		}
 	}
 	inst.nbdServers = nil
+	if inst.privnetNode != nil {
+		inst.privnet.detach(inst.privnetNode)
+		inst.privnetNode = nil
+	}
+	if inst.netFaultsDone != nil {
+		close(inst.netFaultsDone)
+		inst.netFaultsDone = nil
+	}
+	if inst.netShaper != nil {
+		inst.netShaper.Close()
+		inst.netShaper = nil
+	}
 
 	if inst.tempdir != "" {
 		if err := os.RemoveAll(inst.tempdir); err != nil {
@@ -245,12 +313,11 @@ func (inst *QemuInstance) SwitchBootOrder() error {
 		//Not applicable for other arches
 		return nil
 	}
-	monitor, err := newQMPMonitor(inst.tempdir)
+	monitor, disconnect, err := inst.connectQMP()
 	if err != nil {
-		return errors.Wrapf(err, "Could not connect to QMP device")
+		return err
 	}
-	monitor.Connect()
-	defer monitor.Disconnect()
+	defer disconnect()
 	devs, err := listQMPDevices(monitor, inst.tempdir)
 	if err != nil {
 		return errors.Wrapf(err, "Could not list devices through qmp")
@@ -295,6 +362,241 @@ func (inst *QemuInstance) SwitchBootOrder() error {
 	return nil
 }
 
+// connectQMP connects to the QMP socket of the instance, waiting for other
+// users of the socket to disconnect. The returned function disconnects.
+func (inst *QemuInstance) connectQMP() (*qmp.SocketMonitor, func(), error) {
+	inst.qmpMu.Lock()
+	monitor, err := newQMPMonitor(inst.tempdir)
+	if err != nil {
+		inst.qmpMu.Unlock()
+		return nil, nil, errors.Wrapf(err, "Could not connect to QMP device")
+	}
+	if err := monitor.Connect(); err != nil {
+		inst.qmpMu.Unlock()
+		return nil, nil, errors.Wrapf(err, "Could not connect to QMP device")
+	}
+	return monitor, func() {
+		monitor.Disconnect()
+		inst.qmpMu.Unlock()
+	}, nil
+}
+
+// runSnapshotCommand connects to the QMP socket of the instance and runs
+// the snapshot HMP command on the snapshot name.
+func (inst *QemuInstance) runSnapshotCommand(command, name string) error {
+	monitor, disconnect, err := inst.connectQMP()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	return runSnapshotCommand(monitor, command, name)
+}
+
+// Snapshot saves the state of the running instance, including its memory
+// and disks, as an internal qcow2 snapshot called name, replacing any
+// previous snapshot of that name. All writable disks of the instance must
+// be qcow2 images.
+func (inst *QemuInstance) Snapshot(name string) error {
+	return inst.runSnapshotCommand("savevm", name)
+}
+
+// Restore rolls the instance back to the state saved by Snapshot(name).
+// Connections to the instance are lost, and its clock jumps back until
+// corrected by the guest.
+func (inst *QemuInstance) Restore(name string) error {
+	return inst.runSnapshotCommand("loadvm", name)
+}
+
+// DeleteSnapshot deletes the snapshot called name.
+func (inst *QemuInstance) DeleteSnapshot(name string) error {
+	return inst.runSnapshotCommand("delvm", name)
+}
+
+// diskPaths returns the paths to the disk with the given serial, or only
+// the path with index path if it isn't negative.
+func (inst *QemuInstance) diskPaths(serial string, path int) ([]*diskPath, error) {
+	for _, disk := range inst.disks {
+		if disk.serial != serial {
+			continue
+		}
+		if path < 0 {
+			return disk.paths, nil
+		}
+		if path >= len(disk.paths) {
+			return nil, fmt.Errorf("disk %s has no path %d", serial, path)
+		}
+		return disk.paths[path : path+1], nil
+	}
+	return nil, fmt.Errorf("no disk with serial %s", serial)
+}
+
+// unplugDiskPaths hot-unplugs the devices of paths, waiting for the guest
+// to release them.
+func (inst *QemuInstance) unplugDiskPaths(paths []*diskPath) error {
+	monitor, disconnect, err := inst.connectQMP()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	attached := func(p *diskPath) (string, error) {
+		blkdevs, err := listQMPBlkDevices(monitor, inst.tempdir)
+		if err != nil {
+			return "", err
+		}
+		for _, dev := range blkdevs.Return {
+			if dev.Device == p.id {
+				return strings.TrimSuffix(dev.DevicePath, "/virtio-backend"), nil
+			}
+		}
+		return "", nil
+	}
+	for _, p := range paths {
+		if p.unplugged {
+			continue
+		}
+		device, err := attached(p)
+		if err != nil {
+			return err
+		} else if device == "" {
+			return fmt.Errorf("drive %s is not attached", p.id)
+		}
+		if err := deleteQMPDevice(monitor, device); err != nil {
+			return errors.Wrapf(err, "unplugging drive %s", p.id)
+		}
+		// QEMU deletes the drive once the guest releases the device
+		err = util.Retry(30, time.Second, func() error {
+			if device, err := attached(p); err != nil {
+				return err
+			} else if device != "" {
+				return fmt.Errorf("drive %s still attached", p.id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		p.unplugged = true
+	}
+	return nil
+}
+
+// plugDiskPaths hot-plugs paths back in.
+func (inst *QemuInstance) plugDiskPaths(paths []*diskPath) error {
+	monitor, disconnect, err := inst.connectQMP()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	for _, p := range paths {
+		if !p.unplugged {
+			continue
+		}
+		if p.replugDrive == "" {
+			return fmt.Errorf("drive %s cannot be plugged back in", p.id)
+		}
+		if err := runQuietHMPCommand(monitor, "drive_add 0 "+p.replugDrive); err != nil {
+			return errors.Wrapf(err, "recreating drive %s", p.id)
+		}
+		if err := runQuietHMPCommand(monitor, "device_add "+p.device); err != nil {
+			runQuietHMPCommand(monitor, "drive_del "+p.id) // Ignore errors
+			return errors.Wrapf(err, "plugging drive %s", p.id)
+		}
+		p.unplugged = false
+	}
+	return nil
+}
+
+// UnplugDisk hot-unplugs the disk with the given serial, as set by
+// DeviceOpts or "diskN" for the Nth disk added, and waits for the guest to
+// release it. All paths to a multipath disk are unplugged. The bus must
+// support hot-plugging; multipath disks always do.
+func (inst *QemuInstance) UnplugDisk(serial string) error {
+	paths, err := inst.diskPaths(serial, -1)
+	if err != nil {
+		return err
+	}
+	return inst.unplugDiskPaths(paths)
+}
+
+// PlugDisk hot-plugs the disk with the given serial back in after
+// UnplugDisk, with its contents. The primary disk can only be plugged back
+// in if it is served over NBD.
+func (inst *QemuInstance) PlugDisk(serial string) error {
+	paths, err := inst.diskPaths(serial, -1)
+	if err != nil {
+		return err
+	}
+	return inst.plugDiskPaths(paths)
+}
+
+// UnplugDiskPath hot-unplugs the path with index path, from 0, of the
+// multipath disk with the given serial.
+func (inst *QemuInstance) UnplugDiskPath(serial string, path int) error {
+	paths, err := inst.diskPaths(serial, path)
+	if err != nil {
+		return err
+	}
+	return inst.unplugDiskPaths(paths)
+}
+
+// PlugDiskPath hot-plugs the path with index path of the multipath disk
+// with the given serial back in after UnplugDiskPath or UnplugDisk.
+func (inst *QemuInstance) PlugDiskPath(serial string, path int) error {
+	paths, err := inst.diskPaths(serial, path)
+	if err != nil {
+		return err
+	}
+	return inst.plugDiskPaths(paths)
+}
+
+// SetNetworkConditions degrades the primary NIC of the instance with c
+// from now on, or restores it with the zero value. The builder must have
+// had ShapeNetwork set.
+func (inst *QemuInstance) SetNetworkConditions(c NetworkConditions) error {
+	if inst.netShaper == nil {
+		return fmt.Errorf("network shaping is not enabled")
+	}
+	return inst.netShaper.SetConditions(c)
+}
+
+// SetLinkUp sets the link of the primary NIC of the instance up or down,
+// as if its cable was plugged or unplugged.
+func (inst *QemuInstance) SetLinkUp(up bool) error {
+	monitor, disconnect, err := inst.connectQMP()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	return setLink(monitor, "eth0", up)
+}
+
+// runNetworkFaults applies faults at their time after start through
+// shaper, until done is closed.
+func (inst *QemuInstance) runNetworkFaults(start time.Time, faults []NetworkFault, shaper *netShaper, done <-chan struct{}) {
+	faults = append([]NetworkFault(nil), faults...)
+	sort.SliceStable(faults, func(i, j int) bool {
+		return faults[i].At < faults[j].At
+	})
+	for _, fault := range faults {
+		timer := time.NewTimer(time.Until(start.Add(fault.At)))
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return
+		}
+		var err error
+		if fault.LinkDown {
+			err = inst.SetLinkUp(false)
+		} else if err = shaper.SetConditions(fault.Conditions); err == nil {
+			err = inst.SetLinkUp(true)
+		}
+		if err != nil {
+			plog.Errorf("Injecting network fault at %v: %v", fault.At, err)
+		}
+	}
+}
+
 // QemuBuilder is a configurator that can then create a qemu instance
 type QemuBuilder struct {
 	// ConfigFile is a path to Ignition configuration
@@ -322,6 +624,14 @@ type QemuBuilder struct {
 	// IgnitionNetworkKargs are written to /boot/ignition
 	IgnitionNetworkKargs string
 
+	// PlatformID if set replaces ignition.platform.id in the bootloader
+	// config, to boot as on another platform
+	PlatformID string
+
+	// AppendInitrd is a cpio archive loaded after the initramfs of the
+	// primary disk, adding or replacing files in it
+	AppendInitrd string
+
 	Hostname string
 
 	InheritConsole bool
@@ -347,11 +657,33 @@ type QemuBuilder struct {
 	UsermodeNetworking        bool
 	RestrictNetworking        bool
 	requestedHostForwardPorts []HostForwardPort
+	// GuestForwardPorts are host services reachable from usermode
+	// networking.
+	GuestForwardPorts []GuestForwardPort
+	// TFTPDir if set is served over TFTP at the usermode networking
+	// host address, 10.0.2.2.
+	TFTPDir string
+	// UsermodeNetwork if set is the address range of usermode networking
+	// in CIDR notation, instead of 10.0.2.0/24.  The host is at its
+	// second address and the guest at its 16th.
+	UsermodeNetwork string
+
+	// PrivateNetwork if set attaches a second NIC to a network shared
+	// with the other instances using it.
+	PrivateNetwork *PrivateNetwork
+
+	// ShapeNetwork relays the packets of usermode networking through the
+	// host, so that SetNetworkConditions can degrade them.
+	ShapeNetwork bool
+	// NetworkFaults are applied to usermode networking on schedule after
+	// QEMU starts, implying ShapeNetwork.
+	NetworkFaults []NetworkFault
 
 	finalized bool
 	diskID    uint
 	disks     []*Disk
 	fs9pID    uint
+	cdromID   uint
 	// virtioSerialID is incremented for each device
 	virtioSerialID uint
 	// fds is file descriptors we own to pass to qemu
@@ -463,9 +795,20 @@ func (builder *QemuBuilder) setupNetworking() error {
 			builder.requestedHostForwardPorts[i].GuestPort)
 	}
 
+	if builder.UsermodeNetwork != "" {
+		netdev += fmt.Sprintf(",net=%s", builder.UsermodeNetwork)
+	}
 	if builder.Hostname != "" {
 		netdev += fmt.Sprintf(",hostname=%s", builder.Hostname)
 	}
+	// QEMU option values escape commas by doubling them
+	for _, fwd := range builder.GuestForwardPorts {
+		netdev += fmt.Sprintf(",guestfwd=tcp:%s:%d-cmd:%s", fwd.GuestIP, fwd.GuestPort,
+			strings.Replace(fwd.Command, ",", ",,", -1))
+	}
+	if builder.TFTPDir != "" {
+		netdev += ",tftp=" + strings.Replace(builder.TFTPDir, ",", ",,", -1)
+	}
 	if builder.RestrictNetworking {
 		netdev += ",restrict=on"
 	}
@@ -512,6 +855,10 @@ func (builder *QemuBuilder) supportsSwtpm() bool {
 // and it is not needed here.
 const fileRemoteLocation = "/ignition/config.ign"
 
+// initrdRemoteLocation is where AppendInitrd goes on the boot partition;
+// bootloader entries refer to files relative to it.
+const initrdRemoteLocation = "/kola-initrd.img"
+
 // findLabel finds the partition based on the label. The partition belongs to the image attached to the guestfish instance identified by pid.
 func findLabel(label, pid string) (string, error) {
 	if pid == "" {
@@ -602,7 +949,7 @@ func (gf *coreosGuestfish) destroy() {
 }
 
 // setupPreboot performs changes necessary before the disk is booted
-func setupPreboot(confPath, knetargs, kargs string, diskImagePath string, diskSectorSize int) error {
+func setupPreboot(confPath, knetargs, kargs, platformID, initrd string, diskImagePath string, diskSectorSize int) error {
 	gf, err := newGuestfish(diskImagePath, diskSectorSize)
 	if err != nil {
 		return err
@@ -627,7 +974,13 @@ func setupPreboot(confPath, knetargs, kargs string, diskImagePath string, diskSe
 		}
 	}
 
-	if kargs != "" {
+	if initrd != "" {
+		if err := exec.Command("guestfish", gf.remote, "upload", initrd, initrdRemoteLocation).Run(); err != nil {
+			return errors.Wrapf(err, "guestfish initrd upload failed")
+		}
+	}
+
+	if kargs != "" || platformID != "" || initrd != "" {
 		confpathout, err := exec.Command("guestfish", gf.remote, "glob-expand", "/loader/entries/ostree*conf").Output()
 		if err != nil {
 			return errors.Wrapf(err, "finding bootloader config path")
@@ -645,7 +998,16 @@ func setupPreboot(confPath, knetargs, kargs string, diskImagePath string, diskSe
 		var buf strings.Builder
 		for _, line := range strings.Split(string(origconf), "\n") {
 			if strings.HasPrefix(line, "options ") {
-				line += " " + kargs
+				if platformID != "" {
+					line = replacePlatformID(line, platformID)
+				}
+				if kargs != "" {
+					line += " " + kargs
+				}
+			}
+			// Later initrds are unpacked over earlier ones
+			if strings.HasPrefix(line, "initrd ") && initrd != "" {
+				line += " " + initrdRemoteLocation
 			}
 			buf.Write([]byte(line))
 			buf.Write([]byte("\n"))
@@ -661,6 +1023,23 @@ func setupPreboot(confPath, knetargs, kargs string, diskImagePath string, diskSe
 	return nil
 }
 
+// replacePlatformID sets ignition.platform.id in a bootloader options line.
+func replacePlatformID(line, platformID string) string {
+	karg := "ignition.platform.id=" + platformID
+	fields := strings.Fields(line)
+	found := false
+	for i, field := range fields {
+		if strings.HasPrefix(field, "ignition.platform.id=") {
+			fields[i] = karg
+			found = true
+		}
+	}
+	if !found {
+		fields = append(fields, karg)
+	}
+	return strings.Join(fields, " ")
+}
+
 func resolveBackingFile(backingFile string) (string, error) {
 	backingFile, err := filepath.Abs(backingFile)
 	if err != nil {
@@ -730,7 +1109,8 @@ func (disk *Disk) prepare(builder *QemuBuilder) error {
 	fdSet := builder.AddFd(tmpf)
 	disk.attachEndPoint = fdSet
 
-	// MultiPathDisks must be NBD remote mounted
+	// MultiPathDisks must be NBD remote mounted.  The server outlives its
+	// clients, so that paths can be unplugged and plugged back in.
 	if disk.MultiPathDisk || disk.NbdDisk {
 		socketName := fmt.Sprintf("%s.socket", disk.dstFileName)
 		shareCount := "1"
@@ -743,6 +1123,7 @@ func (disk *Disk) prepare(builder *QemuBuilder) error {
 			"--discard", "unmap",
 			"--socket", socketName,
 			"--share", shareCount,
+			"--persistent",
 			disk.dstFileName)
 		disk.attachEndPoint = fmt.Sprintf("nbd:unix:%s", socketName)
 	}
@@ -763,9 +1144,10 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 			return errors.Wrapf(err, "rendering ignition")
 		}
 		requiresInjection := builder.ConfigFile != "" && builder.ForceConfigInjection
-		if requiresInjection || builder.IgnitionNetworkKargs != "" || builder.AppendKernelArguments != "" {
+		if requiresInjection || builder.IgnitionNetworkKargs != "" || builder.AppendKernelArguments != "" ||
+			builder.PlatformID != "" || builder.AppendInitrd != "" {
 			if err := setupPreboot(builder.ConfigFile, builder.IgnitionNetworkKargs, builder.AppendKernelArguments,
-				disk.dstFileName, disk.SectorSize); err != nil {
+				builder.PlatformID, builder.AppendInitrd, disk.dstFileName, disk.SectorSize); err != nil {
 				return errors.Wrapf(err, "ignition injection with guestfs failed")
 			}
 			builder.configInjected = true
@@ -773,16 +1155,17 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 	}
 	diskOpts := disk.DeviceOpts
 	if primary {
-		diskOpts = append(diskOpts, "serial=primary-disk")
+		disk.serial = "primary-disk"
+		diskOpts = append(diskOpts, "serial="+disk.serial)
 	} else {
-		foundserial := false
 		for _, opt := range diskOpts {
 			if strings.HasPrefix(opt, "serial=") {
-				foundserial = true
+				disk.serial = strings.TrimPrefix(opt, "serial=")
 			}
 		}
-		if !foundserial {
-			diskOpts = append(diskOpts, "serial="+fmt.Sprintf("disk%d", builder.diskID))
+		if disk.serial == "" {
+			disk.serial = fmt.Sprintf("disk%d", builder.diskID)
+			diskOpts = append(diskOpts, "serial="+disk.serial)
 		}
 	}
 	channel := disk.Channel
@@ -804,6 +1187,11 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 	}
 
 	id := fmt.Sprintf("d%d", builder.diskID)
+	nbd := disk.MultiPathDisk || disk.NbdDisk
+	fileOpts, err := builder.faultyDrive(disk, id, disk.attachEndPoint, !nbd)
+	if err != nil {
+		return errors.Wrapf(err, "injecting disk faults")
+	}
 
 	// Avoid file locking detection, and the disks we create
 	// here are always currently ephemeral.
@@ -834,33 +1222,57 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 			}
 			pID := fmt.Sprintf("mpath%d%d", builder.diskID, i)
 			scsiID := fmt.Sprintf("scsi_%s", pID)
+			device := fmt.Sprintf("scsi-hd,bus=%s.0,drive=%s,vendor=NVME,product=VirtualMultipath,wwn=%d%s",
+				scsiID, pID, wwn, opts)
+			drive := fmt.Sprintf("if=none,id=%s,%s,media=disk,%s",
+				pID, fileOpts, defaultDiskOpts)
 			builder.Append("-device", fmt.Sprintf("virtio-scsi-%s,id=%s", bus, scsiID))
-			builder.Append("-device",
-				fmt.Sprintf("scsi-hd,bus=%s.0,drive=%s,vendor=NVME,product=VirtualMultipath,wwn=%d%s",
-					scsiID, pID, wwn, opts))
-			builder.Append("-drive", fmt.Sprintf("if=none,id=%s,format=raw,file=%s,media=disk,%s",
-				pID, disk.attachEndPoint, defaultDiskOpts))
+			builder.Append("-device", device)
+			builder.Append("-drive", drive)
+			disk.paths = append(disk.paths, &diskPath{
+				id:          pID,
+				device:      device,
+				replugDrive: drive,
+			})
 		}
 	} else {
+		replugOpts := fileOpts
 		if !disk.NbdDisk {
-			// In the non-multipath/nbd case we can just unlink the disk now
-			// and avoid leaking space if we get Ctrl-C'd (though it's best if
-			// higher level code catches SIGINT and cleans up the directory)
-			os.Remove(disk.dstFileName)
+			if primary {
+				// In the non-multipath/nbd case we can just unlink the disk now
+				// and avoid leaking space if we get Ctrl-C'd (though it's best if
+				// higher level code catches SIGINT and cleans up the directory).
+				// Other disks are kept to be plugged back in, which the file
+				// descriptor can't be once QEMU closes it.
+				os.Remove(disk.dstFileName)
+				replugOpts = ""
+			} else if replugOpts, err = builder.faultyDrive(disk, id, disk.dstFileName, true); err != nil {
+				return errors.Wrapf(err, "injecting disk faults")
+			}
 		}
 		disk.dstFileName = ""
+		var device string
 		switch channel {
 		case "virtio":
-			builder.Append("-device", virtio("blk", fmt.Sprintf("drive=%s%s", id, opts)))
+			device = virtio("blk", fmt.Sprintf("drive=%s%s", id, opts))
 		case "nvme":
-			builder.Append("-device", fmt.Sprintf("nvme,drive=%s%s", id, opts))
+			device = fmt.Sprintf("nvme,drive=%s%s", id, opts)
 		default:
 			panic(fmt.Sprintf("Unhandled channel: %s", channel))
 		}
+		builder.Append("-device", device)
 
 		// Default to cache=unsafe
-		builder.Append("-drive", fmt.Sprintf("if=none,id=%s,file=%s,%s",
-			id, disk.attachEndPoint, defaultDiskOpts))
+		builder.Append("-drive", fmt.Sprintf("if=none,id=%s,%s,%s",
+			id, fileOpts, defaultDiskOpts))
+		path := &diskPath{
+			id:     id,
+			device: device,
+		}
+		if replugOpts != "" {
+			path.replugDrive = fmt.Sprintf("if=none,id=%s,%s,%s", id, replugOpts, defaultDiskOpts)
+		}
+		disk.paths = append(disk.paths, path)
 	}
 	return nil
 }
@@ -900,33 +1312,57 @@ func (builder *QemuBuilder) AddIso(path string, bootindexStr string) error {
 	return nil
 }
 
+// AddCdrom attaches the ISO image at path as a CD-ROM drive.  On x86_64
+// it is an ATA drive with the given model, the way Hyper-V presents its
+// DVD drive; other architectures get a SCSI one.
+func (builder *QemuBuilder) AddCdrom(path, model string) {
+	builder.cdromID++
+	id := fmt.Sprintf("cd%d", builder.cdromID)
+	builder.Append("-drive", fmt.Sprintf("if=none,id=%s,file=%s,format=raw,media=cdrom,readonly=on",
+		id, strings.Replace(path, ",", ",,", -1)))
+	if system.RpmArch() == "x86_64" {
+		// Without a serial, udev names the drive after its model only,
+		// e.g. /dev/disk/by-id/ata-Virtual_CD
+		builder.Append("-device", fmt.Sprintf("ide-cd,drive=%s,model=%s,serial=", id, model))
+	} else {
+		scsiID := "scsi_" + id
+		builder.Append("-device", virtio("scsi", "id="+scsiID),
+			"-device", fmt.Sprintf("scsi-cd,bus=%s.0,drive=%s", scsiID, id))
+	}
+}
+
 func (builder *QemuBuilder) finalize() {
 	if builder.finalized {
 		return
 	}
 	if builder.Memory == 0 {
-		// FIXME; Required memory should really be a property of the tests, and
-		// let's try to drop these arch-specific overrides.  ARM was bumped via
-		// commit 09391907c0b25726374004669fa6c2b161e3892f
-		// Commit:     Geoff Levand <geoff@infradead.org>
-		// CommitDate: Mon Aug 21 12:39:34 2017 -0700
-		//
-		// kola: More memory for arm64 qemu guest machines
-		//
-		// arm64 guest machines seem to run out of memory with 1024 MiB of
-		// RAM, so increase to 2048 MiB.
-
-		// Then later, other non-x86_64 seemed to just copy that.
-		memory := 1024
-		switch system.RpmArch() {
-		case "aarch64", "s390x", "ppc64le":
-			memory = 2048
-		}
-		builder.Memory = memory
+		builder.Memory = DefaultQemuMemory()
 	}
 	builder.finalized = true
 }
 
+// DefaultQemuMemory returns the memory in MiB given to a QEMU guest when
+// QemuBuilder.Memory is unset.
+func DefaultQemuMemory() int {
+	// FIXME; Required memory should really be a property of the tests, and
+	// let's try to drop these arch-specific overrides.  ARM was bumped via
+	// commit 09391907c0b25726374004669fa6c2b161e3892f
+	// Commit:     Geoff Levand <geoff@infradead.org>
+	// CommitDate: Mon Aug 21 12:39:34 2017 -0700
+	//
+	// kola: More memory for arm64 qemu guest machines
+	//
+	// arm64 guest machines seem to run out of memory with 1024 MiB of
+	// RAM, so increase to 2048 MiB.
+
+	// Then later, other non-x86_64 seemed to just copy that.
+	switch system.RpmArch() {
+	case "aarch64", "s390x", "ppc64le":
+		return 2048
+	}
+	return 1024
+}
+
 // Append appends additional arguments for QEMU.
 func (builder *QemuBuilder) Append(args ...string) {
 	builder.Argv = append(builder.Argv, args...)
@@ -1244,6 +1680,18 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 		inst.hostForwardedPorts = builder.requestedHostForwardPorts
 	}
 
+	// Handle the private network
+	if builder.PrivateNetwork != nil {
+		node, err := builder.PrivateNetwork.attach()
+		if err != nil {
+			return nil, errors.Wrapf(err, "attaching to private network")
+		}
+		inst.privnet = builder.PrivateNetwork
+		inst.privnetNode = node
+		builder.Append("-netdev", builder.PrivateNetwork.netdev("eth1", node),
+			"-device", virtio("net", fmt.Sprintf("netdev=eth1,mac=%s", node.mac)))
+	}
+
 	// Handle Software TPM
 	if builder.Swtpm && builder.supportsSwtpm() {
 		err = builder.ensureTempdir()
@@ -1288,6 +1736,26 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 
 	}
 
+	// Handle network shaping, last so the shaper can't leak on errors
+	if builder.ShapeNetwork || len(builder.NetworkFaults) > 0 {
+		if !builder.UsermodeNetworking {
+			return nil, fmt.Errorf("network shaping requires usermode networking")
+		}
+		for _, fault := range builder.NetworkFaults {
+			if err := fault.Conditions.validate(); err != nil {
+				return nil, err
+			}
+		}
+		if err := builder.ensureTempdir(); err != nil {
+			return nil, err
+		}
+		inst.netShaper, err = newNetShaper(builder.tempdir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "starting network shaper")
+		}
+		builder.Append(inst.netShaper.args("eth0")...)
+	}
+
 	// Set up QMP (currently used to switch boot order after first boot on aarch64)
 	qmpPath := filepath.Join(builder.tempdir, "qmp.sock")
 	qmpID := "qemu-qmp"
@@ -1334,14 +1802,24 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 	}
 
 	if err = inst.qemu.Start(); err != nil {
+		if inst.netShaper != nil {
+			inst.netShaper.Close()
+		}
 		return nil, err
 	}
 
+	inst.disks = builder.disks
+
 	// Transfer ownership of the tempdir
 	inst.tempdir = builder.tempdir
 	builder.tempdir = ""
 	cleanupInst = false
 
+	if len(builder.NetworkFaults) > 0 {
+		inst.netFaultsDone = make(chan struct{})
+		go inst.runNetworkFaults(time.Now(), builder.NetworkFaults, inst.netShaper, inst.netFaultsDone)
+	}
+
 	return &inst, nil
 }
 
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/coreos/mantle/util"
@@ -98,3 +99,84 @@ func setBootIndexForDevice(monitor *qmp.SocketMonitor, device string, bootindex
 	}
 	return nil
 }
+
+// Run a human monitor (HMP) command through QMP and return its output
+func runHMPCommand(monitor *qmp.SocketMonitor, cmdline string) (string, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"execute":   "human-monitor-command",
+		"arguments": map[string]string{"command-line": cmdline},
+	})
+	if err != nil {
+		return "", err
+	}
+	out, err := monitor.Run(cmd)
+	if err != nil {
+		return "", errors.Wrapf(err, "Running QMP command")
+	}
+	var res struct {
+		Return string `json:"return"`
+	}
+	if err = json.Unmarshal(out, &res); err != nil {
+		return "", errors.Wrapf(err, "De-serializing QMP output")
+	}
+	return res.Return, nil
+}
+
+// Run a snapshot HMP command (savevm, loadvm or delvm), which only produces
+// output on failure
+func runSnapshotCommand(monitor *qmp.SocketMonitor, command, name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n\"'") {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	out, err := runHMPCommand(monitor, fmt.Sprintf("%s %s", command, name))
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("%s %s: %s", command, name, out)
+	}
+	return nil
+}
+
+// Set the link of the network device or netdev name up or down
+func setLink(monitor *qmp.SocketMonitor, name string, up bool) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"execute":   "set_link",
+		"arguments": map[string]interface{}{"name": name, "up": up},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := monitor.Run(cmd); err != nil {
+		return errors.Wrapf(err, "Running QMP command")
+	}
+	return nil
+}
+
+// Delete a device by id or QOM path. The guest may take a while to release
+// it.
+func deleteQMPDevice(monitor *qmp.SocketMonitor, device string) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"execute":   "device_del",
+		"arguments": map[string]string{"id": device},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := monitor.Run(cmd); err != nil {
+		return errors.Wrapf(err, "Running QMP command")
+	}
+	return nil
+}
+
+// Run an HMP command which only produces output on failure, or says OK
+func runQuietHMPCommand(monitor *qmp.SocketMonitor, cmdline string) error {
+	out, err := runHMPCommand(monitor, cmdline)
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" && out != "OK" {
+		return fmt.Errorf("%s: %s", strings.Fields(cmdline)[0], out)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// RelaySubcommand is the hidden subcommand of the running executable which
+// QEMU runs for each guest connection to a host service forwarded with
+// GuestRelay, with the host address as argument.
+const RelaySubcommand = "guest-relay"
+
+// GuestRelay returns the forward making the TCP service at the host
+// address addr reachable from the guest at guestIP:guestPort.  Each
+// connection is relayed by running this executable with RelaySubcommand.
+func GuestRelay(guestIP string, guestPort int, addr string) (GuestForwardPort, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return GuestForwardPort{}, err
+	}
+	return GuestForwardPort{
+		GuestIP:   guestIP,
+		GuestPort: guestPort,
+		Command:   shellquote.Join(exe, RelaySubcommand, addr),
+	}, nil
+}
+
+// Relay copies stdin to the TCP service at addr and the replies to
+// stdout. It implements RelaySubcommand.
+func Relay(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		io.Copy(conn, os.Stdin) // Ignore errors
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	_, err = io.Copy(os.Stdout, conn)
+	return err
+}
@@ -0,0 +1,470 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// sftpServer is run as root on machines, so transfers can reach any path.
+const sftpServer = "/usr/libexec/openssh/sftp-server"
+
+const selinuxXattr = "security.selinux"
+
+// TransferOptions controls how files are copied to and from machines with
+// Upload, Download, CopyTreeToMachine and CopyTreeFromMachine.
+type TransferOptions struct {
+	// Mode if set replaces the permissions of copied files; directories
+	// and symlinks keep theirs. By default modes are preserved.
+	Mode os.FileMode
+
+	// Owner if set is the "user[:group]" owning the uploaded files.
+	// By default they belong to root. Downloaded files always belong to
+	// the user running kola.
+	Owner string
+
+	// Relabel resets the SELinux labels of uploaded files to the policy
+	// default for their destination.
+	Relabel bool
+
+	// PreserveLabels copies the SELinux labels of the source files to
+	// the destination. Setting labels locally usually requires root.
+	PreserveLabels bool
+
+	// Progress if set is called as each file is copied with its
+	// destination path, the bytes copied so far and its size.
+	Progress func(path string, copied, total int64)
+}
+
+// transfer is an SFTP session to a machine.
+type transfer struct {
+	m       Machine
+	opts    TransferOptions
+	client  *ssh.Client
+	session *ssh.Session
+	sftp    *sftp.Client
+
+	// labels holds the SELinux labels to apply, by destination path
+	labels map[string]string
+}
+
+func newTransfer(m Machine, opts TransferOptions) (*transfer, error) {
+	client, err := m.SSHClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed creating SSH client")
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "failed creating SSH session")
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, err
+	}
+	if err := session.Start("sudo " + sftpServer); err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.Wrapf(err, "starting %s", sftpServer)
+	}
+	sc, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.Wrapf(err, "starting SFTP session")
+	}
+	return &transfer{
+		m:       m,
+		opts:    opts,
+		client:  client,
+		session: session,
+		sftp:    sc,
+		labels:  make(map[string]string),
+	}, nil
+}
+
+func (t *transfer) Close() {
+	t.sftp.Close()
+	t.session.Close()
+	t.client.Close()
+}
+
+// unixMode returns the permission bits of mode as SFTP servers take them.
+func unixMode(mode os.FileMode) os.FileMode {
+	m := mode.Perm()
+	if mode&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+	return m
+}
+
+// fileMode returns the mode a file copied from one with mode gets.
+func (t *transfer) fileMode(mode os.FileMode) os.FileMode {
+	if t.opts.Mode != 0 {
+		return t.opts.Mode
+	}
+	return mode
+}
+
+// copy copies src to dst, reporting progress as dst.
+func (t *transfer) copy(dst io.Writer, src io.Reader, name string, size int64) error {
+	if t.opts.Progress != nil {
+		t.opts.Progress(name, 0, size)
+		dst = &progressWriter{w: dst, name: name, total: size, progress: t.opts.Progress}
+	}
+	_, err := io.Copy(dst, src)
+	return err
+}
+
+type progressWriter struct {
+	w        io.Writer
+	name     string
+	copied   int64
+	total    int64
+	progress func(string, int64, int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.copied += int64(n)
+	p.progress(p.name, p.copied, p.total)
+	return n, err
+}
+
+// upload copies the local file, directory or symlink src to dst.
+func (t *transfer) upload(src, dst string, fi os.FileInfo) error {
+	if t.opts.PreserveLabels {
+		buf := make([]byte, 256)
+		n, err := unix.Lgetxattr(src, selinuxXattr, buf)
+		if err == nil {
+			t.labels[dst] = string(bytes.TrimRight(buf[:n], "\x00"))
+		} else if err != unix.ENODATA && err != unix.ENOTSUP {
+			return errors.Wrapf(err, "reading SELinux label of %s", src)
+		}
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		t.sftp.Remove(dst) // Ignore errors
+		if err := t.sftp.Symlink(target, dst); err != nil {
+			return errors.Wrapf(err, "creating symlink %s", dst)
+		}
+		return nil
+	case fi.IsDir():
+		if err := t.sftp.MkdirAll(dst); err != nil {
+			return errors.Wrapf(err, "creating directory %s", dst)
+		}
+		return t.sftp.Chmod(dst, unixMode(fi.Mode()))
+	case fi.Mode().IsRegular():
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := t.sftp.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return errors.Wrapf(err, "creating %s", dst)
+		}
+		defer out.Close()
+		if err := t.copy(out, in, dst, fi.Size()); err != nil {
+			return errors.Wrapf(err, "writing %s", dst)
+		}
+		return t.sftp.Chmod(dst, unixMode(t.fileMode(fi.Mode())))
+	default:
+		plog.Debugf("Not uploading special file %s", src)
+		return nil
+	}
+}
+
+// download copies the remote file, directory or symlink src to dst.
+func (t *transfer) download(src, dst string, fi os.FileInfo) error {
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := t.sftp.ReadLink(src)
+		if err != nil {
+			return errors.Wrapf(err, "reading symlink %s", src)
+		}
+		os.Remove(dst) // Ignore errors
+		return os.Symlink(target, dst)
+	case fi.IsDir():
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		// Keep the directory writable until its contents are copied
+		return os.Chmod(dst, fi.Mode()&os.ModePerm|0700)
+	case fi.Mode().IsRegular():
+		in, err := t.sftp.Open(src)
+		if err != nil {
+			return errors.Wrapf(err, "opening %s", src)
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if err := t.copy(out, in, dst, fi.Size()); err != nil {
+			return errors.Wrapf(err, "reading %s", src)
+		}
+		return os.Chmod(dst, t.fileMode(fi.Mode()))
+	default:
+		plog.Debugf("Not downloading special file %s", src)
+		return nil
+	}
+}
+
+// finishUpload applies the ownership and labels of the options to the
+// uploaded tree dst.
+func (t *transfer) finishUpload(dst string) error {
+	var script strings.Builder
+	if t.opts.Owner != "" {
+		fmt.Fprintf(&script, "chown -hR %s\n", shellquote.Join(t.opts.Owner, dst))
+	}
+	if t.opts.Relabel {
+		fmt.Fprintf(&script, "restorecon -R %s\n", shellquote.Join(dst))
+	}
+	for p, label := range t.labels {
+		fmt.Fprintf(&script, "chcon -h %s\n", shellquote.Join(label, p))
+	}
+	return t.runScript(script.String())
+}
+
+// runScript runs a shell script as root on the machine.
+func (t *transfer) runScript(script string) error {
+	if script == "" {
+		return nil
+	}
+	var stderr bytes.Buffer
+	req := ExecRequest{
+		Argv:   []string{"sh", "-e"},
+		Sudo:   true,
+		Stdin:  strings.NewReader(script),
+		Stderr: &stderr,
+	}
+	res, err := t.m.Exec(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	if !res.Success() {
+		return fmt.Errorf("setting file attributes %s: %s", res, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// remoteLabels returns the SELinux labels of the tree src on the machine,
+// by path relative to src.
+func (t *transfer) remoteLabels(src string) (map[string]string, error) {
+	var stdout, stderr bytes.Buffer
+	res, err := t.m.Exec(context.Background(), ExecRequest{
+		Argv:   []string{"find", src, "-printf", `%P\t%Z\n`},
+		Sudo:   true,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !res.Success() {
+		return nil, fmt.Errorf("listing SELinux labels of %s %s: %s", src, res, strings.TrimSpace(stderr.String()))
+	}
+	labels := make(map[string]string)
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) == 2 && fields[1] != "?" {
+			labels[fields[0]] = fields[1]
+		}
+	}
+	return labels, scanner.Err()
+}
+
+// setLocalLabels labels the downloaded tree dst like the remote one.
+func setLocalLabels(dst string, labels map[string]string) error {
+	for rel, label := range labels {
+		p := filepath.Join(dst, filepath.FromSlash(rel))
+		if err := unix.Lsetxattr(p, selinuxXattr, []byte(label), 0); err != nil {
+			return errors.Wrapf(err, "setting SELinux label of %s", p)
+		}
+	}
+	return nil
+}
+
+// Upload copies the local file src to dst on m over SFTP, creating the
+// parent directories of dst.
+func Upload(m Machine, src, dst string, opts TransferOptions) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory", src)
+	}
+	t, err := newTransfer(m, opts)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	if err := t.sftp.MkdirAll(path.Dir(dst)); err != nil {
+		return errors.Wrapf(err, "creating directory %s", path.Dir(dst))
+	}
+	if err := t.upload(src, dst, fi); err != nil {
+		return err
+	}
+	return t.finishUpload(dst)
+}
+
+// Download copies the file src on m to the local path dst over SFTP,
+// creating the parent directories of dst.
+func Download(m Machine, src, dst string, opts TransferOptions) error {
+	t, err := newTransfer(m, opts)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	fi, err := t.sftp.Stat(src)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", src)
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory", src)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := t.download(src, dst, fi); err != nil {
+		return err
+	}
+	if opts.PreserveLabels {
+		labels, err := t.remoteLabels(src)
+		if err != nil {
+			return err
+		}
+		return setLocalLabels(dst, labels)
+	}
+	return nil
+}
+
+// CopyTreeToMachine copies the local tree src, which may also be a single
+// file, to dst on m over SFTP. Symlinks are copied as is; device nodes,
+// sockets and FIFOs are skipped.
+func CopyTreeToMachine(m Machine, src, dst string, opts TransferOptions) error {
+	t, err := newTransfer(m, opts)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	if err := t.sftp.MkdirAll(path.Dir(dst)); err != nil {
+		return errors.Wrapf(err, "creating directory %s", path.Dir(dst))
+	}
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		return t.upload(p, path.Join(dst, filepath.ToSlash(rel)), fi)
+	})
+	if err != nil {
+		return err
+	}
+	return t.finishUpload(dst)
+}
+
+// CopyTreeFromMachine copies the tree src on m, which may also be a single
+// file, to the local path dst over SFTP. Symlinks are copied as is;
+// device nodes, sockets and FIFOs are skipped.
+func CopyTreeFromMachine(m Machine, src, dst string, opts TransferOptions) error {
+	t, err := newTransfer(m, opts)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// Directories are restored to their mode once they are filled
+	type dirMode struct {
+		path string
+		mode os.FileMode
+	}
+	var dirs []dirMode
+	walker := t.sftp.Walk(src)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return errors.Wrapf(err, "reading %s", walker.Path())
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), src), "/")
+		p := filepath.Join(dst, filepath.FromSlash(rel))
+		fi := walker.Stat()
+		if err := t.download(walker.Path(), p, fi); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			dirs = append(dirs, dirMode{p, fi.Mode()})
+		}
+	}
+
+	if opts.PreserveLabels {
+		labels, err := t.remoteLabels(src)
+		if err != nil {
+			return err
+		}
+		if err := setLocalLabels(dst, labels); err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// localTransfer returns a transfer to an in-process SFTP server serving
+// the local filesystem, and a function closing it.
+func localTransfer(t *testing.T, opts TransferOptions) (*transfer, func()) {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	server, err := sftp.NewServer(pipeConn{serverIn, serverOut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientIn, clientOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closer := func() {
+		// The server leaves its end open
+		serverOut.Close()
+		client.Close()
+	}
+	return &transfer{opts: opts, sftp: client, labels: make(map[string]string)}, closer
+}
+
+func TestTransferTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	for _, d := range []string{"a", "a/b"} {
+		if err := os.MkdirAll(filepath.Join(src, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b/script"), []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b/script", filepath.Join(src, "a/link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "a/b"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []int64
+	tr, closer := localTransfer(t, TransferOptions{
+		Progress: func(path string, copied, total int64) {
+			progress = append(progress, copied)
+		},
+	})
+	defer closer()
+
+	// Upload from src to dst, then download dst to back, so both
+	// directions are covered
+	dst := filepath.Join(dir, "dst")
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		return tr.upload(p, filepath.Join(dst, rel), fi)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := filepath.Join(dir, "back")
+	walker := tr.sftp.Walk(dst)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dst, walker.Path())
+		if err := tr.download(walker.Path(), filepath.Join(back, rel), walker.Stat()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, root := range []string{dst, back} {
+		buf, err := ioutil.ReadFile(filepath.Join(root, "a/b/script"))
+		if err != nil || string(buf) != "#!/bin/sh\n" {
+			t.Errorf("%s: got script %q, %v", root, buf, err)
+		}
+		if fi, err := os.Stat(filepath.Join(root, "a/b/script")); err != nil || fi.Mode().Perm() != 0750 {
+			t.Errorf("%s: got script mode %v, %v", root, fi.Mode(), err)
+		}
+		if target, err := os.Readlink(filepath.Join(root, "a/link")); err != nil || target != "b/script" {
+			t.Errorf("%s: got link to %q, %v", root, target, err)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "a/b")); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("got directory mode %v, %v", fi.Mode(), err)
+	}
+	if len(progress) < 4 || progress[len(progress)-1] != int64(len("#!/bin/sh\n")) {
+		t.Errorf("got progress %v", progress)
+	}
+}
+
+func TestUnixMode(t *testing.T) {
+	if m := unixMode(os.ModeSetuid | os.ModeSticky | 0755); m != 05755 {
+		t.Errorf("got %o, expected 5755", m)
+	}
+}
@@ -15,7 +15,9 @@
 package sdk
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -94,6 +96,38 @@ func GetLocalBuild(root, buildid string) (*LocalBuild, error) {
 	}, nil
 }
 
+// GetLocalBuildIDs returns the IDs of the builds in builds/builds.json of
+// a coreos-assembler workdir, newest first.
+func GetLocalBuildIDs(root string) ([]string, error) {
+	if err := RequireCosaRoot(root); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "builds", "builds.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index struct {
+		Builds []json.RawMessage `json:"builds"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrapf(err, "parsing builds.json")
+	}
+	var ids []string
+	for _, raw := range index.Builds {
+		// older builds.json list bare IDs
+		var build struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &build.ID); err != nil {
+			if err := json.Unmarshal(raw, &build); err != nil {
+				return nil, errors.Wrapf(err, "parsing builds.json")
+			}
+		}
+		ids = append(ids, build.ID)
+	}
+	return ids, nil
+}
+
 func DefaultBoard() string {
 	defaultBoard := system.PortageArch() + "-usr"
 	return string(defaultBoard)
@@ -0,0 +1,76 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetLocalBuildIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdk-builds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := GetLocalBuildIDs(dir); err == nil {
+		t.Errorf("not a workdir: no error")
+	}
+	if err := os.Mkdir(filepath.Join(dir, "builds"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetLocalBuildIDs(dir); err == nil {
+		t.Errorf("no builds.json: no error")
+	}
+
+	tests := []struct {
+		name string
+		data string
+		ids  []string
+	}{
+		{"objects", `{"schema-version": "1.0.0", "builds": [{"id": "33.2", "arches": ["x86_64"]}, {"id": "33.1"}]}`, []string{"33.2", "33.1"}},
+		{"bare ids", `{"builds": ["33.2", "33.1"]}`, []string{"33.2", "33.1"}},
+		{"mixed", `{"builds": [{"id": "33.2"}, "33.1"]}`, []string{"33.2", "33.1"}},
+		{"no builds", `{"builds": []}`, nil},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(filepath.Join(dir, "builds", "builds.json"), []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := GetLocalBuildIDs(dir)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: got %q, expected %q", tt.name, ids, tt.ids)
+		}
+	}
+
+	for _, data := range []string{
+		`{"builds": [1]}`,
+		`{"builds": {}}`,
+		`{`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "builds", "builds.json"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GetLocalBuildIDs(dir); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}